@@ -0,0 +1,301 @@
+package powermetrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/stats"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// Baseline errors
+var (
+	ErrBaselineNotReached = errors.New("system did not stay idle long enough for a baseline")
+	ErrBaselineMismatch   = errors.New("baseline was measured on a different hardware model")
+)
+
+// BaselineCriteria controls when a window of samples is accepted as the idle
+// baseline.
+type BaselineCriteria struct {
+	// Duration is the length of the baseline window. Zero means 10 seconds.
+	Duration time.Duration
+	// MinGPUIdleRatio rejects samples whose GPU idle ratio is below it. Zero
+	// accepts every sample.
+	MinGPUIdleRatio float64
+}
+
+// Baseline is the idle power draw of a machine.
+type Baseline struct {
+	HWModel    string
+	KernOSVer  string
+	MeasuredAt time.Time
+	Duration   time.Duration
+	Samples    int
+	// Power is the average power of every component combined, and
+	// Uncertainty its standard error.
+	Power       units.Power
+	Uncertainty units.Power
+	Components  map[energy.Component]units.Power
+}
+
+// MeasureBaseline measures the baseline with the real command runner. See
+// Powermetrics.MeasureBaseline.
+func MeasureBaseline(ctx context.Context, config *Config, criteria BaselineCriteria) (*Baseline, error) {
+	return New().MeasureBaseline(ctx, config, criteria)
+}
+
+// MeasureBaseline streams samples until consecutive samples meeting criteria
+// span criteria.Duration, and returns their average power. A sample that
+// does not meet the criteria restarts the window. It returns
+// ErrBaselineNotReached when the stream ends first; use the context to bound
+// how long to wait. A nil config samples the GPU every second.
+func (p *Powermetrics) MeasureBaseline(ctx context.Context, config *Config, criteria BaselineCriteria) (*Baseline, error) {
+	if criteria.Duration <= 0 {
+		criteria.Duration = 10 * time.Second
+	}
+	if config == nil {
+		config = DefaultConfig().GPU()
+		config.SampleRate = time.Second
+	}
+	streamConfig := *config
+	streamConfig.SampleCount = 0
+
+	stream, err := p.Stream(ctx, &streamConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = stream.Stop() }()
+
+	var window []types.Sample
+	var covered time.Duration
+	for sample := range stream.Samples() {
+		gpu, ok := sample.(*types.GPUPowerSample)
+		if !ok {
+			continue
+		}
+		if gpu.GPU.IdleRatio < criteria.MinGPUIdleRatio {
+			window, covered = nil, 0
+			continue
+		}
+		window = append(window, gpu)
+		covered += gpu.Elapsed()
+		if covered >= criteria.Duration {
+			return newBaseline(window), nil
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBaselineNotReached, err)
+	}
+	return nil, ErrBaselineNotReached
+}
+
+func newBaseline(window []types.Sample) *Baseline {
+	report := energy.Integrate(window, energy.Options{})
+	b := &Baseline{
+		MeasuredAt: time.Now(),
+		Duration:   report.Covered,
+		Samples:    len(window),
+		Components: make(map[energy.Component]units.Power),
+	}
+	if len(window) > 0 {
+		b.HWModel = window[0].GetHWModel()
+		b.KernOSVer = window[0].GetKernOSVer()
+	}
+
+	var total units.Energy
+	for c, integral := range report.Components {
+		b.Components[c] = integral.AveragePower()
+		total += integral.Total
+	}
+	b.Power = total.Over(report.Covered)
+
+	// The standard error of the mean of the per-interval powers.
+	powers := make([]float64, 0, len(window))
+	for _, sample := range window {
+		var e units.Energy
+		for _, v := range energy.Readings(sample) {
+			e += v
+		}
+		powers = append(powers, e.Over(time.Duration(sample.GetElapsedNS())).Watts())
+	}
+	if s := stats.Describe(powers, nil); s.Count > 1 {
+		b.Uncertainty = units.Power(s.StdDev / math.Sqrt(float64(s.Count)))
+	}
+	return b
+}
+
+// NetEnergy is the energy of a workload with the idle baseline removed.
+type NetEnergy struct {
+	// Gross is the measured energy and Idle the baseline power over the
+	// covered duration of the workload.
+	Gross units.Energy
+	Idle  units.Energy
+	// Net is Gross minus Idle, and Uncertainty its standard uncertainty,
+	// combining the quantization of the readings with the uncertainty of
+	// the baseline.
+	Net         units.Energy
+	Uncertainty units.Energy
+	Components  map[energy.Component]units.Energy
+}
+
+// Subtract removes the baseline from a measurement. It returns
+// ErrBaselineMismatch when the measurement was taken on a different
+// hardware model.
+func (b *Baseline) Subtract(m *Measurement) (*NetEnergy, error) {
+	for _, sample := range m.Samples {
+		if hw := sample.GetHWModel(); b.HWModel != "" && hw != "" && hw != b.HWModel {
+			return nil, fmt.Errorf("%w: %s, measured on %s", ErrBaselineMismatch, b.HWModel, hw)
+		}
+	}
+
+	net := &NetEnergy{
+		Gross:      m.Energy,
+		Idle:       b.Power.Over(m.Covered),
+		Components: make(map[energy.Component]units.Energy),
+	}
+	net.Net = net.Gross - net.Idle
+
+	// Quantization is uniform over one step per interval reading, with a
+	// standard deviation of step/sqrt(12).
+	var variance float64
+	for _, sample := range m.Samples {
+		for c := range energy.Readings(sample) {
+			step := energy.Resolution(c).Joules()
+			variance += step * step / 12
+		}
+	}
+	idle := b.Uncertainty.Over(m.Covered).Joules()
+	net.Uncertainty = units.Energy(math.Sqrt(variance + idle*idle))
+
+	for c, e := range m.Components {
+		net.Components[c] = e - b.Components[c].Over(m.Covered)
+	}
+	return net, nil
+}
+
+type baselineJSON struct {
+	HWModel          string             `json:"hw_model"`
+	KernOSVer        string             `json:"kern_osversion"`
+	MeasuredAt       time.Time          `json:"measured_at"`
+	DurationSeconds  float64            `json:"duration_seconds"`
+	Samples          int                `json:"samples"`
+	PowerWatts       float64            `json:"power_watts"`
+	UncertaintyWatts float64            `json:"uncertainty_watts"`
+	ComponentsWatts  map[string]float64 `json:"components_watts"`
+}
+
+// MarshalJSON encodes the baseline with snake_case keys and explicit units.
+func (b *Baseline) MarshalJSON() ([]byte, error) {
+	out := baselineJSON{
+		HWModel:          b.HWModel,
+		KernOSVer:        b.KernOSVer,
+		MeasuredAt:       b.MeasuredAt,
+		DurationSeconds:  b.Duration.Seconds(),
+		Samples:          b.Samples,
+		PowerWatts:       b.Power.Watts(),
+		UncertaintyWatts: b.Uncertainty.Watts(),
+		ComponentsWatts:  make(map[string]float64, len(b.Components)),
+	}
+	for c, p := range b.Components {
+		out.ComponentsWatts[string(c)] = p.Watts()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a baseline written by MarshalJSON.
+func (b *Baseline) UnmarshalJSON(data []byte) error {
+	var in baselineJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*b = Baseline{
+		HWModel:     in.HWModel,
+		KernOSVer:   in.KernOSVer,
+		MeasuredAt:  in.MeasuredAt,
+		Duration:    time.Duration(in.DurationSeconds * float64(time.Second)),
+		Samples:     in.Samples,
+		Power:       units.Power(in.PowerWatts),
+		Uncertainty: units.Power(in.UncertaintyWatts),
+		Components:  make(map[energy.Component]units.Power, len(in.ComponentsWatts)),
+	}
+	for c, p := range in.ComponentsWatts {
+		b.Components[energy.Component(c)] = units.Power(p)
+	}
+	return nil
+}
+
+// WriteJSON writes the baseline as indented JSON.
+func (b *Baseline) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(b)
+}
+
+// ReadBaseline reads a baseline written by WriteJSON.
+func ReadBaseline(r io.Reader) (*Baseline, error) {
+	var b Baseline
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// BaselineStore keeps one baseline per hardware model in a directory, so a
+// baseline can be reused across runs on the same machine type.
+type BaselineStore struct {
+	Dir string
+}
+
+// Save stores b under its hardware model, replacing any previous baseline.
+func (s BaselineStore) Save(b *Baseline) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(s.path(b.HWModel))
+	if err != nil {
+		return err
+	}
+	if err := b.WriteJSON(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load returns the baseline stored for hwModel. The error satisfies
+// errors.Is(err, fs.ErrNotExist) when there is none.
+func (s BaselineStore) Load(hwModel string) (*Baseline, error) {
+	f, err := os.Open(s.path(hwModel))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ReadBaseline(f)
+}
+
+func (s BaselineStore) path(hwModel string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, hwModel)
+	if name == "" {
+		name = "unknown"
+	}
+	return filepath.Join(s.Dir, name+".json")
+}
@@ -0,0 +1,120 @@
+package powermetrics
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+func TestMeasureBaseline(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+
+	b, err := pm.MeasureBaseline(context.Background(), nil, BaselineCriteria{
+		Duration:        10 * time.Second,
+		MinGPUIdleRatio: 0.95,
+	})
+	if err != nil {
+		t.Fatalf("MeasureBaseline failed: %v", err)
+	}
+
+	// The first two samples (19 mJ and 107 mJ) are idle enough.
+	if b.Samples != 2 || b.HWModel != "Mac16,8" {
+		t.Errorf("Expected 2 samples on Mac16,8, got %d on %s", b.Samples, b.HWModel)
+	}
+	expected := 0.126 / (float64(5004758375+5014447083) / 1e9)
+	if math.Abs(b.Power.Watts()-expected) > 1e-12 {
+		t.Errorf("Expected %g W, got %g W", expected, b.Power.Watts())
+	}
+	if b.Uncertainty <= 0 {
+		t.Errorf("Expected a positive uncertainty, got %v", b.Uncertainty)
+	}
+
+	_, err = pm.MeasureBaseline(context.Background(), nil, BaselineCriteria{
+		Duration:        10 * time.Second,
+		MinGPUIdleRatio: 0.975,
+	})
+	if !errors.Is(err, ErrBaselineNotReached) {
+		t.Errorf("Expected ErrBaselineNotReached, got %v", err)
+	}
+}
+
+func TestBaselineSubtract(t *testing.T) {
+	mJ := func(v int64) *int64 { return &v }
+	b := &Baseline{
+		HWModel:     "Mac16,8",
+		Power:       100 * units.Milliwatt,
+		Uncertainty: 10 * units.Milliwatt,
+		Components:  map[energy.Component]units.Power{energy.GPU: 100 * units.Milliwatt},
+	}
+	m := &Measurement{
+		Energy:     2 * units.Joule,
+		Components: map[energy.Component]units.Energy{energy.GPU: 2 * units.Joule},
+		Covered:    10 * time.Second,
+		Samples: []types.Sample{
+			&types.GPUPowerSample{
+				BaseSample: types.BaseSample{HWModel: "Mac16,8", ElapsedNS: int64(10 * time.Second)},
+				GPU:        types.GPUInfo{GPUEnergy: mJ(2000)},
+			},
+		},
+	}
+
+	net, err := b.Subtract(m)
+	if err != nil {
+		t.Fatalf("Subtract failed: %v", err)
+	}
+	if math.Abs(net.Net.Joules()-1) > 1e-12 || math.Abs(net.Idle.Joules()-1) > 1e-12 {
+		t.Errorf("Expected 1 J idle and 1 J net, got %v and %v", net.Idle, net.Net)
+	}
+	if math.Abs(net.Components[energy.GPU].Joules()-1) > 1e-12 {
+		t.Errorf("Expected 1 J net for the GPU, got %v", net.Components[energy.GPU])
+	}
+	expected := math.Sqrt(1e-6/12 + 0.1*0.1)
+	if math.Abs(net.Uncertainty.Joules()-expected) > 1e-12 {
+		t.Errorf("Expected an uncertainty of %g J, got %g J", expected, net.Uncertainty.Joules())
+	}
+
+	b.HWModel = "Mac14,2"
+	if _, err := b.Subtract(m); !errors.Is(err, ErrBaselineMismatch) {
+		t.Errorf("Expected ErrBaselineMismatch, got %v", err)
+	}
+}
+
+func TestBaselineStore(t *testing.T) {
+	store := BaselineStore{Dir: t.TempDir()}
+	b := &Baseline{
+		HWModel:     "Mac16,8",
+		KernOSVer:   "24F74",
+		Duration:    10 * time.Second,
+		Samples:     10,
+		Power:       150 * units.Milliwatt,
+		Uncertainty: 5 * units.Milliwatt,
+		Components:  map[energy.Component]units.Power{energy.GPU: 150 * units.Milliwatt},
+	}
+	if err := store.Save(b); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := store.Load("Mac16,8")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Power != b.Power || loaded.Duration != b.Duration || loaded.Components[energy.GPU] != b.Components[energy.GPU] {
+		t.Errorf("Expected %+v, got %+v", b, loaded)
+	}
+
+	if _, err := store.Load("Mac14,2"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist for a missing model, got %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package powermetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// ErrBudgetExceeded is wrapped by the cause of a context cancelled by a
+// BudgetWatcher.
+var ErrBudgetExceeded = errors.New("energy budget exceeded")
+
+// Budget limits the energy or average power over a sliding window of
+// samples. Zero limits are not checked.
+type Budget struct {
+	// Energy is the most energy allowed within Window.
+	Energy units.Energy
+	// Power is the highest average power allowed over Window. It is only
+	// checked once the samples cover a full window.
+	Power units.Power
+	// Window is the length of the sliding window. Zero means the whole
+	// stream, in which case Power is checked on every sample.
+	Window time.Duration
+	// Components restricts the budget to some components. Nil counts every
+	// component.
+	Components []energy.Component
+	// OnExceeded, when set, is called once when the budget is first
+	// exceeded.
+	OnExceeded func(*BudgetExceeded)
+}
+
+// BudgetExceeded describes a budget violation. It is the cause of the
+// cancelled context, and wraps ErrBudgetExceeded.
+type BudgetExceeded struct {
+	Budget Budget
+	// Energy and AveragePower are the values over Window when the budget was
+	// exceeded. Window can be shorter than Budget.Window early in a stream.
+	Energy       units.Energy
+	AveragePower units.Power
+	Window       time.Duration
+	// Offset is the end of the offending sample since the stream started.
+	Offset time.Duration
+}
+
+func (e *BudgetExceeded) Error() string {
+	if e.Budget.Energy > 0 && e.Energy > e.Budget.Energy {
+		return fmt.Sprintf("%v: %v in %v, limit %v", ErrBudgetExceeded, e.Energy, e.Window, e.Budget.Energy)
+	}
+	return fmt.Sprintf("%v: %v average over %v, limit %v", ErrBudgetExceeded, e.AveragePower, e.Window, e.Budget.Power)
+}
+
+func (e *BudgetExceeded) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// BudgetWatcher checks samples against a budget and cancels its context when
+// the budget is exceeded. It is safe for concurrent use.
+type BudgetWatcher struct {
+	budget    Budget
+	perSample int
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+
+	mu        sync.Mutex
+	seen      int
+	offset    time.Duration
+	intervals []budgetInterval
+	pending   units.Energy
+	exceeded  *BudgetExceeded
+}
+
+// budgetInterval is the energy of one powermetrics sample.
+type budgetInterval struct {
+	elapsed time.Duration
+	energy  units.Energy
+}
+
+// NewBudgetWatcher returns a watcher for samples decoded by the given number
+// of samplers per powermetrics sample, and whose context is derived from
+// ctx. Call Stop to release the context once done.
+func NewBudgetWatcher(ctx context.Context, budget Budget, samplers int) *BudgetWatcher {
+	if samplers < 1 {
+		samplers = 1
+	}
+	w := &BudgetWatcher{budget: budget, perSample: samplers}
+	w.ctx, w.cancel = context.WithCancelCause(ctx)
+	return w
+}
+
+// WatchBudget watches every sample of stream in a new goroutine, which
+// ends with the stream. The stream's samples must not be consumed
+// elsewhere; use NewBudgetWatcher and Observe to combine the budget with
+// other processing.
+func WatchBudget(ctx context.Context, stream *Stream, budget Budget) *BudgetWatcher {
+	w := NewBudgetWatcher(ctx, budget, len(stream.samplers))
+	go func() {
+		for sample := range stream.Samples() {
+			_ = w.Observe(sample)
+		}
+	}()
+	return w
+}
+
+// Context returns the context that is cancelled when the budget is
+// exceeded. Its cause is the *BudgetExceeded.
+func (w *BudgetWatcher) Context() context.Context {
+	return w.ctx
+}
+
+// Stop cancels the context of the watcher.
+func (w *BudgetWatcher) Stop() {
+	w.cancel(context.Canceled)
+}
+
+// Exceeded returns the violation, or nil while the budget holds.
+func (w *BudgetWatcher) Exceeded() *BudgetExceeded {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.exceeded
+}
+
+// Observe adds a sample to the window. It returns the violation once the
+// budget has been exceeded.
+func (w *BudgetWatcher) Observe(sample types.Sample) error {
+	exceeded, first := w.add(sample)
+	if exceeded == nil {
+		return nil
+	}
+	if first {
+		if w.budget.OnExceeded != nil {
+			w.budget.OnExceeded(exceeded)
+		}
+		w.cancel(exceeded)
+	}
+	return exceeded
+}
+
+// add adds sample and returns the violation, and whether the sample caused
+// it.
+func (w *BudgetWatcher) add(sample types.Sample) (*BudgetExceeded, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exceeded != nil {
+		return w.exceeded, false
+	}
+
+	for component, e := range energy.Readings(sample) {
+		if w.counts(component) {
+			w.pending += e
+		}
+	}
+	w.seen++
+	if w.seen%w.perSample != 0 {
+		return nil, false
+	}
+
+	// The last sampler of a powermetrics sample completes its interval.
+	elapsed := time.Duration(sample.GetElapsedNS())
+	w.offset += elapsed
+	w.intervals = append(w.intervals, budgetInterval{elapsed: elapsed, energy: w.pending})
+	w.pending = 0
+	w.exceeded = w.check()
+	return w.exceeded, w.exceeded != nil
+}
+
+func (w *BudgetWatcher) counts(component energy.Component) bool {
+	if w.budget.Components == nil {
+		return true
+	}
+	for _, c := range w.budget.Components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the violation over the current window, if any. The oldest
+// interval is prorated by the part of it inside the window.
+func (w *BudgetWatcher) check() *BudgetExceeded {
+	var (
+		window time.Duration
+		total  units.Energy
+		full   = w.budget.Window <= 0
+	)
+	for i := len(w.intervals) - 1; i >= 0; i-- {
+		interval := w.intervals[i]
+		if w.budget.Window > 0 && window+interval.elapsed >= w.budget.Window {
+			if interval.elapsed > 0 {
+				fraction := float64(w.budget.Window-window) / float64(interval.elapsed)
+				total += interval.energy * units.Energy(fraction)
+			}
+			window = w.budget.Window
+			full = true
+			// Older intervals can no longer be part of a window.
+			w.intervals = w.intervals[i:]
+			break
+		}
+		window += interval.elapsed
+		total += interval.energy
+	}
+
+	power := total.Over(window)
+	if (w.budget.Energy > 0 && total > w.budget.Energy) ||
+		(w.budget.Power > 0 && full && power > w.budget.Power) {
+		return &BudgetExceeded{
+			Budget:       w.budget,
+			Energy:       total,
+			AveragePower: power,
+			Window:       window,
+			Offset:       w.offset,
+		}
+	}
+	return nil
+}
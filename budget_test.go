@@ -0,0 +1,128 @@
+package powermetrics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+func TestBudgetWatcher(t *testing.T) {
+	mJ := func(v int64) *int64 { return &v }
+	gpu := func(energy int64) types.Sample {
+		return &types.GPUPowerSample{
+			BaseSample: types.BaseSample{ElapsedNS: int64(time.Second)},
+			GPU:        types.GPUInfo{GPUEnergy: mJ(energy)},
+		}
+	}
+	// One joule per second, then a burst of 3 W.
+	energies := []int64{1000, 1000, 1000, 3000, 3000}
+
+	tests := []struct {
+		name     string
+		budget   Budget
+		exceeded int // index of the offending sample, -1 for none
+		energy   float64
+	}{
+		{"cumulative energy", Budget{Energy: 4 * units.Joule}, 3, 6},
+		{"windowed energy", Budget{Energy: 3500 * units.Millijoule, Window: 2 * time.Second}, 3, 4},
+		{"windowed energy within budget", Budget{Energy: 6 * units.Joule, Window: 2 * time.Second}, -1, 0},
+		{"windowed power", Budget{Power: 1500 * units.Milliwatt, Window: 2 * time.Second}, 3, 4},
+		{"prorated window", Budget{Power: 2.5 * units.Watt, Window: 1500 * time.Millisecond}, 4, 4.5},
+		{"other component", Budget{Energy: units.Joule, Components: []energy.Component{energy.CPU}}, -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called int
+			tt.budget.OnExceeded = func(*BudgetExceeded) { called++ }
+			w := NewBudgetWatcher(context.Background(), tt.budget, 1)
+			defer w.Stop()
+
+			exceededAt := -1
+			for i, e := range energies {
+				if err := w.Observe(gpu(e)); err != nil && exceededAt < 0 {
+					exceededAt = i
+				}
+			}
+			if exceededAt != tt.exceeded {
+				t.Fatalf("Expected the budget to be exceeded at sample %d, got %d", tt.exceeded, exceededAt)
+			}
+			if tt.exceeded < 0 {
+				if called != 0 || w.Exceeded() != nil || w.Context().Err() != nil {
+					t.Errorf("Expected the budget to hold, got %v", w.Exceeded())
+				}
+				return
+			}
+
+			if called != 1 {
+				t.Errorf("Expected OnExceeded to be called once, got %d", called)
+			}
+			exceeded := w.Exceeded()
+			if exceeded.Energy.Joules() < tt.energy-1e-9 || exceeded.Energy.Joules() > tt.energy+1e-9 {
+				t.Errorf("Expected %v J in the window, got %v", tt.energy, exceeded.Energy)
+			}
+			if exceeded.Offset != time.Duration(tt.exceeded+1)*time.Second {
+				t.Errorf("Expected offset %ds, got %v", tt.exceeded+1, exceeded.Offset)
+			}
+			if !errors.Is(context.Cause(w.Context()), ErrBudgetExceeded) {
+				t.Errorf("Expected the context cause to wrap ErrBudgetExceeded, got %v", context.Cause(w.Context()))
+			}
+		})
+	}
+}
+
+func TestBudgetGroupsSamplers(t *testing.T) {
+	mJ := func(v int64) *int64 { return &v }
+	base := types.BaseSample{ElapsedNS: int64(time.Second)}
+	w := NewBudgetWatcher(context.Background(), Budget{Energy: 1500 * units.Millijoule}, 2)
+	defer w.Stop()
+
+	for i := 0; i < 2; i++ {
+		if err := w.Observe(&types.GPUPowerSample{BaseSample: base, GPU: types.GPUInfo{GPUEnergy: mJ(1000)}}); err != nil {
+			t.Fatalf("Expected the budget to be checked after the tasks sample, got %v", err)
+		}
+		err := w.Observe(&types.TasksSample{BaseSample: base})
+		if (i == 1) != (err != nil) {
+			t.Errorf("Interval %d: unexpected result %v", i, err)
+		}
+	}
+	if exceeded := w.Exceeded(); exceeded == nil || exceeded.Window != 2*time.Second {
+		t.Errorf("Expected a 2s window, got %+v", exceeded)
+	}
+}
+
+func TestWatchBudget(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+	stream, err := pm.Stream(context.Background(), DefaultConfig().GPU())
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	defer func() { _ = stream.Stop() }()
+
+	w := WatchBudget(context.Background(), stream, Budget{Energy: 500 * units.Millijoule, Window: 10 * time.Second})
+	defer w.Stop()
+
+	select {
+	case <-w.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the budget to be exceeded")
+	}
+	var exceeded *BudgetExceeded
+	if !errors.As(context.Cause(w.Context()), &exceeded) {
+		t.Fatalf("Expected a *BudgetExceeded cause, got %v", context.Cause(w.Context()))
+	}
+	// The third sample spends 788 mJ on top of most of the 107 mJ before.
+	if exceeded.Energy < 788*units.Millijoule || exceeded.Energy > 895*units.Millijoule || exceeded.Window != 10*time.Second {
+		t.Errorf("Expected between 788 and 895 mJ over 10s, got %v over %v", exceeded.Energy, exceeded.Window)
+	}
+}
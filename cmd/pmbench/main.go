@@ -0,0 +1,128 @@
+// Command pmbench benchmarks the energy used by a command, much as hyperfine
+// does for time.
+//
+// Usage:
+//
+//	sudo pmbench [flags] -- command [args...]
+//
+// The command is run -warmup times unmeasured and then -runs times while
+// powermetrics samples power. The mean and confidence interval of the
+// energy, average power and duration of the runs are printed, and can be
+// exported as JSON or CSV.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/bench"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+func main() {
+	var (
+		runs          = flag.Int("runs", 10, "number of measured runs")
+		warmup        = flag.Int("warmup", 1, "number of unmeasured warmup runs")
+		cooldown      = flag.Duration("cooldown", time.Second, "time to wait before every run")
+		sampleRate    = flag.Duration("sample-rate", powermetrics.DefaultMeasureSampleRate, "powermetrics sample rate")
+		confidence    = flag.Float64("confidence", bench.DefaultConfidence, "confidence level of the reported intervals")
+		exportJSON    = flag.String("export-json", "", "write the results as JSON to `file`")
+		exportCSV     = flag.String("export-csv", "", "write the per-run results as CSV to `file`")
+		showOutput    = flag.Bool("show-output", false, "show the output of the command")
+		ignoreFailure = flag.Bool("ignore-failure", false, "keep going when the command exits with an error")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -- command [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *confidence <= 0 || *confidence >= 1 {
+		fmt.Fprintf(os.Stderr, "pmbench: -confidence must be between 0 and 1, got %v\n", *confidence)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sampling := powermetrics.DefaultConfig().GPU()
+	sampling.SampleRate = *sampleRate
+
+	opts := bench.Options{
+		Command:       flag.Args(),
+		Runs:          *runs,
+		Warmup:        *warmup,
+		Cooldown:      *cooldown,
+		Confidence:    *confidence,
+		IgnoreFailure: *ignoreFailure,
+		Sampling:      sampling,
+		Progress: func(run bench.Run) {
+			fmt.Fprintf(os.Stderr, "  run %d/%d: %v in %v\n", run.Index, *runs, run.Energy, run.Duration.Round(time.Millisecond))
+		},
+	}
+	if *showOutput {
+		opts.Stdout = os.Stdout
+		opts.Stderr = os.Stderr
+	}
+
+	fmt.Printf("Benchmark: %s\n", strings.Join(flag.Args(), " "))
+	result, err := bench.Benchmark(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmbench: %v\n", err)
+		os.Exit(1)
+	}
+
+	printResult(result)
+
+	if *exportJSON != "" {
+		if err := writeFile(*exportJSON, result.WriteJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "pmbench: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *exportCSV != "" {
+		if err := writeFile(*exportCSV, result.WriteCSV); err != nil {
+			fmt.Fprintf(os.Stderr, "pmbench: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func printResult(result *bench.Result) {
+	level := fmt.Sprintf("%.0f%% CI", result.Confidence*100)
+	e, p, d := result.Energy, result.Power, result.Duration
+	fmt.Printf("  Energy:   %v ± %v   [%s %v … %v]\n",
+		units.Energy(e.Mean), units.Energy(e.StdDev), level, units.Energy(e.Low), units.Energy(e.High))
+	fmt.Printf("  Power:    %v ± %v   [%s %v … %v]\n",
+		units.Power(p.Mean), units.Power(p.StdDev), level, units.Power(p.Low), units.Power(p.High))
+	fmt.Printf("  Duration: %v ± %v   [%s %v … %v]\n",
+		seconds(d.Mean), seconds(d.StdDev), level, seconds(d.Low), seconds(d.High))
+	fmt.Printf("  Runs:     %d (%d warmup)\n", len(result.Runs), result.Warmup)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,99 @@
+// Command pmbisect finds the commit that regressed the energy of a command.
+//
+// Usage:
+//
+//	sudo pmbisect -good v1.2.0 [-bad HEAD] [-build "make"] [flags] -- command [args...]
+//
+// Every tested commit is checked out in a temporary worktree, built with
+// -build and benchmarked as pmbench does, and its energy is compared with
+// the good revision as pmgate does. Inconclusive commits are benchmarked
+// again up to -retries times.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/bench"
+)
+
+func main() {
+	var (
+		repo       = flag.String("repo", "", "git repository (default the current directory)")
+		good       = flag.String("good", "", "revision without the regression (required)")
+		bad        = flag.String("bad", "HEAD", "revision with the regression")
+		build      = flag.String("build", "", "shell `command` building every commit before it is benchmarked")
+		runs       = flag.Int("runs", 10, "number of measured runs per commit")
+		warmup     = flag.Int("warmup", 1, "number of unmeasured warmup runs per commit")
+		cooldown   = flag.Duration("cooldown", time.Second, "time to wait before every run")
+		sampleRate = flag.Duration("sample-rate", powermetrics.DefaultMeasureSampleRate, "powermetrics sample rate")
+		threshold  = flag.Float64("threshold", bench.DefaultThreshold*100, "regression threshold in percent")
+		alpha      = flag.Float64("alpha", bench.DefaultAlpha, "significance level")
+		retries    = flag.Int("retries", bench.DefaultBisectRetries, "extra benchmarks of an inconclusive commit")
+		showOutput = flag.Bool("show-output", false, "show the output of the build and the command")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -good rev [flags] -- command [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *good == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		fmt.Fprintf(os.Stderr, "pmbisect: -alpha must be between 0 and 1, got %v\n", *alpha)
+		os.Exit(2)
+	}
+	if *retries == 0 {
+		*retries = -1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sampling := powermetrics.DefaultConfig().GPU()
+	sampling.SampleRate = *sampleRate
+
+	opts := bench.BisectOptions{
+		Repo:  *repo,
+		Good:  *good,
+		Bad:   *bad,
+		Build: *build,
+		Benchmark: bench.Options{
+			Command:  flag.Args(),
+			Runs:     *runs,
+			Warmup:   *warmup,
+			Cooldown: *cooldown,
+			Sampling: sampling,
+		},
+		Compare: bench.CompareOptions{Threshold: *threshold / 100, Alpha: *alpha},
+		Retries: *retries,
+		Progress: func(step bench.BisectStep) {
+			state := "good"
+			if step.Bad {
+				state = "bad"
+			}
+			fmt.Printf("%.12s %-4s %+7.2f%% (p=%.4f, %d runs)  %s\n",
+				step.Commit, state, step.Comparison.Change*100, step.Comparison.Test.PValue,
+				len(step.Result.Runs), step.Subject)
+		},
+	}
+	if *showOutput {
+		opts.Benchmark.Stdout = os.Stdout
+		opts.Benchmark.Stderr = os.Stderr
+	}
+
+	bisection, err := bench.Bisect(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmbisect: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\nFirst regressed commit: %s %s\n", bisection.Culprit, bisection.Subject)
+}
@@ -0,0 +1,162 @@
+// Command pmcsv writes powermetrics samples as CSV or TSV.
+//
+// Usage:
+//
+//	sudo pmcsv [-layout wide|long] [-tsv] [-count 10] [-sample-rate 1s] [-samplers gpu_power]
+//	pmcsv [-layout wide|long] [-tsv] -recording run.plist
+//
+// Live samples are written as they arrive. In the wide layout, the columns
+// are those of the first powermetrics sample; fields that appear later, such
+// as new tasks, are dropped and listed on standard error. The long layout
+// keeps every field.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/tabular"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func main() {
+	var (
+		layout     = flag.String("layout", "wide", "table layout, wide or long")
+		tsv        = flag.Bool("tsv", false, "write tab-separated values")
+		recording  = flag.String("recording", "", "read samples from a recording instead of running powermetrics")
+		count      = flag.Int("count", 0, "number of powermetrics samples to write, 0 for no limit")
+		sampleRate = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers   = flag.String("samplers", "gpu_power", "comma-separated powermetrics samplers")
+	)
+	flag.Parse()
+
+	opts := tabular.Options{Streaming: true}
+	switch *layout {
+	case "wide":
+	case "long":
+		opts.Layout = tabular.Long
+	default:
+		fmt.Fprintf(os.Stderr, "pmcsv: invalid layout %q\n", *layout)
+		os.Exit(2)
+	}
+	if *tsv {
+		opts.Comma = '\t'
+	}
+
+	if *recording != "" {
+		if err := convert(*recording, opts); err != nil {
+			log.Fatalf("pmcsv: %v", err)
+		}
+		return
+	}
+
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmcsv: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pmcsv: %v", err)
+	}
+	err = write(stream.Samples(), len(config.Samplers), opts)
+	if stopErr := stream.Stop(); stopErr != nil {
+		log.Fatalf("pmcsv: %v", stopErr)
+	}
+	if err != nil {
+		log.Fatalf("pmcsv: %v", err)
+	}
+}
+
+// convert writes the samples of a recording. The wide columns cover every
+// sample.
+func convert(path string, opts tabular.Options) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	rec, err := powermetrics.ReadRecording(f)
+	if err != nil {
+		return err
+	}
+	if opts.Layout == tabular.Wide {
+		if opts.Columns, err = tabular.SampleColumns(rec.Samples); err != nil {
+			return err
+		}
+	}
+	samples := make(chan types.Sample, len(rec.Samples))
+	for _, sample := range rec.Samples {
+		samples <- sample
+	}
+	close(samples)
+	return write(samples, 1, opts)
+}
+
+// write writes samples as they arrive. Unless set, the wide columns are
+// those of the first perSample samples.
+func write(samples <-chan types.Sample, perSample int, opts tabular.Options) error {
+	var (
+		w       *tabular.Writer
+		pending []types.Sample
+	)
+	flush := func() error {
+		if w == nil {
+			if opts.Layout == tabular.Wide && opts.Columns == nil {
+				columns, err := tabular.SampleColumns(pending)
+				if err != nil {
+					return err
+				}
+				opts.Columns = columns
+			}
+			w = tabular.NewWriter(os.Stdout, opts)
+		}
+		for _, sample := range pending {
+			if err := w.Write(sample); err != nil {
+				return err
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for sample := range samples {
+		pending = append(pending, sample)
+		if w == nil && len(pending) < perSample {
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	if len(pending) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	if w == nil {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if dropped := w.Dropped(); len(dropped) > 0 {
+		log.Printf("pmcsv: dropped fields missing from the first sample: %s", strings.Join(dropped, ", "))
+	}
+	return nil
+}
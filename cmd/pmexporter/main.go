@@ -0,0 +1,83 @@
+// Command pmexporter serves powermetrics samples as Prometheus metrics.
+//
+// Usage:
+//
+//	sudo pmexporter [-listen :9756] [-sample-rate 1s] [-samplers gpu_power,tasks,thermal]
+//
+// It runs a single long-lived powermetrics stream and serves the metrics
+// accumulated from it on /metrics in the Prometheus text exposition format.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/prom"
+)
+
+func main() {
+	var (
+		listen     = flag.String("listen", ":9756", "address to serve metrics on")
+		path       = flag.String("path", "/metrics", "HTTP path of the metrics")
+		sampleRate = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers   = flag.String("samplers", "gpu_power,tasks,thermal", "comma-separated powermetrics samplers")
+	)
+	flag.Parse()
+
+	config := &powermetrics.Config{SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmexporter: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pmexporter: %v", err)
+	}
+	collector := prom.NewCollector()
+	go func() {
+		for sample := range stream.Samples() {
+			collector.Observe(sample)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle(*path, collector)
+	server := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stream.Done():
+		}
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	log.Printf("serving %s on %s", *path, *listen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("pmexporter: %v", err)
+	}
+	if err := stream.Stop(); err != nil {
+		log.Fatalf("pmexporter: %v", err)
+	}
+	if ctx.Err() == nil {
+		log.Fatal("pmexporter: powermetrics exited")
+	}
+}
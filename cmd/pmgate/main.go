@@ -0,0 +1,142 @@
+// Command pmgate fails when the energy of a command regressed against a
+// stored baseline.
+//
+// Usage:
+//
+//	pmgate -baseline base.json -candidate new.json
+//	sudo pmgate -baseline base.json [flags] -- command [args...]
+//
+// Baseline and candidate files are JSON results written by pmbench
+// -export-json. When a command is given instead of -candidate, it is
+// benchmarked first. The per-run energies are compared with Welch's t-test,
+// and pmgate exits with status 1 when energy increased by more than
+// -threshold percent with significance at -alpha, and with status 2 on
+// errors.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/bench"
+)
+
+func main() {
+	var (
+		baselinePath  = flag.String("baseline", "", "baseline result `file` (required)")
+		candidatePath = flag.String("candidate", "", "candidate result `file`; benchmark the command instead when empty")
+		threshold     = flag.Float64("threshold", bench.DefaultThreshold*100, "regression threshold in percent")
+		alpha         = flag.Float64("alpha", bench.DefaultAlpha, "significance level")
+		jsonPath      = flag.String("json", "", "write the comparison as JSON to `file` (- for standard output)")
+		saveCandidate = flag.String("save-candidate", "", "write the benchmarked candidate result to `file`")
+		runs          = flag.Int("runs", 10, "number of measured runs when benchmarking")
+		warmup        = flag.Int("warmup", 1, "number of warmup runs when benchmarking")
+		cooldown      = flag.Duration("cooldown", time.Second, "time to wait before every run when benchmarking")
+		sampleRate    = flag.Duration("sample-rate", powermetrics.DefaultMeasureSampleRate, "powermetrics sample rate when benchmarking")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -baseline file (-candidate file | [flags] -- command [args...])\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *baselinePath == "" || (*candidatePath == "") == (flag.NArg() == 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		fmt.Fprintf(os.Stderr, "pmgate: -alpha must be between 0 and 1, got %v\n", *alpha)
+		os.Exit(2)
+	}
+
+	baseline, err := readResult(*baselinePath)
+	if err != nil {
+		fail(err)
+	}
+
+	var candidate *bench.Result
+	if *candidatePath != "" {
+		candidate, err = readResult(*candidatePath)
+	} else {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		sampling := powermetrics.DefaultConfig().GPU()
+		sampling.SampleRate = *sampleRate
+		candidate, err = bench.Benchmark(ctx, bench.Options{
+			Command:  flag.Args(),
+			Runs:     *runs,
+			Warmup:   *warmup,
+			Cooldown: *cooldown,
+			Sampling: sampling,
+		})
+		if err == nil && *saveCandidate != "" {
+			err = writeFile(*saveCandidate, candidate.WriteJSON)
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	comparison, err := bench.Compare(baseline, candidate, bench.CompareOptions{
+		Threshold: *threshold / 100,
+		Alpha:     *alpha,
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	if err := comparison.WriteText(os.Stderr); err != nil {
+		fail(err)
+	}
+	switch *jsonPath {
+	case "":
+	case "-":
+		err = comparison.WriteJSON(os.Stdout)
+	default:
+		err = writeFile(*jsonPath, comparison.WriteJSON)
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	if comparison.Regressed() {
+		os.Exit(1)
+	}
+}
+
+func readResult(path string) (*bench.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	result, err := bench.ReadJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return result, nil
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "pmgate: %v\n", err)
+	os.Exit(2)
+}
@@ -0,0 +1,131 @@
+// Command pminflux writes powermetrics samples in the InfluxDB line protocol.
+//
+// Usage:
+//
+//	sudo pminflux [-count 0] [-sample-rate 1s] [-samplers gpu_power,tasks,thermal] [-url URL]
+//
+// Without -url, lines are written to standard output as samples arrive, for
+// Telegraf's execd input plugin, or once with -count 1 for its exec input
+// plugin. With -url, samples are batched and posted to the InfluxDB write
+// endpoint instead.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/influx"
+)
+
+func main() {
+	var (
+		count         = flag.Int("count", 0, "number of powermetrics samples to write, 0 for no limit")
+		sampleRate    = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers      = flag.String("samplers", "gpu_power,tasks,thermal", "comma-separated powermetrics samplers")
+		fields        = flag.String("fields", "", "comma-separated fields to write, all when empty")
+		tags          = flag.String("tags", "hw_model,os_build", "comma-separated sample tags to write")
+		extraTags     = flag.String("extra-tags", "", "comma-separated key=value tags added to every line")
+		perTask       = flag.Bool("per-task", false, "write one line per task, tagged with its name and pid")
+		writeURL      = flag.String("url", "", "InfluxDB write endpoint, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b")
+		batchSize     = flag.Int("batch-size", influx.DefaultBatchSize, "lines per request with -url")
+		flushInterval = flag.Duration("flush-interval", influx.DefaultFlushInterval, "longest delay before lines are sent with -url")
+	)
+	flag.Parse()
+
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range splitList(*samplers) {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(name))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pminflux: %v\n", err)
+		os.Exit(2)
+	}
+
+	// An empty -tags writes no sample tags, unlike nil Options.Tags.
+	opts := influx.Options{Tags: append([]string{}, splitList(*tags)...), PerTask: *perTask}
+	if *fields != "" {
+		opts.Fields = splitList(*fields)
+	}
+	for _, tag := range splitList(*extraTags) {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			fmt.Fprintf(os.Stderr, "pminflux: invalid tag %q, expected key=value\n", tag)
+			os.Exit(2)
+		}
+		if opts.ExtraTags == nil {
+			opts.ExtraTags = make(map[string]string)
+		}
+		opts.ExtraTags[key] = value
+	}
+
+	var writer *influx.Writer
+	if *writeURL != "" {
+		var err error
+		writer, err = influx.NewWriter(*writeURL, influx.WriterOptions{
+			Token:         os.Getenv("INFLUX_TOKEN"),
+			BatchSize:     *batchSize,
+			FlushInterval: *flushInterval,
+			Encoding:      opts,
+			OnError:       func(err error) { log.Printf("pminflux: %v", err) },
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pminflux: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pminflux: %v", err)
+	}
+
+	if writer != nil {
+		err = writer.Run(ctx, stream)
+	} else {
+		err = encode(stream, opts)
+	}
+	if stopErr := stream.Stop(); stopErr != nil {
+		log.Fatalf("pminflux: %v", stopErr)
+	}
+	if err != nil {
+		log.Fatalf("pminflux: %v", err)
+	}
+}
+
+// encode writes every sample of stream to standard output, flushing after
+// each one so that Telegraf receives complete samples.
+func encode(stream *powermetrics.Stream, opts influx.Options) error {
+	out := bufio.NewWriter(os.Stdout)
+	enc := influx.NewEncoder(out, opts)
+	for sample := range stream.Samples() {
+		if err := enc.Encode(sample); err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,91 @@
+// Command pmjson writes powermetrics samples as newline-delimited JSON.
+//
+// Usage:
+//
+//	sudo pmjson [-count 10] [-sample-rate 1s] [-samplers gpu_power,tasks,thermal]
+//	pmjson -recording run.plist
+//	pmjson -schema
+//
+// Every line is a record of the versioned representation defined by
+// pkg/jsonfeed; -schema prints its JSON Schema.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/jsonfeed"
+)
+
+func main() {
+	var (
+		schema     = flag.Bool("schema", false, "print the JSON Schema of the records and exit")
+		recording  = flag.String("recording", "", "read samples from a recording instead of running powermetrics")
+		count      = flag.Int("count", 0, "number of powermetrics samples to write, 0 for no limit")
+		sampleRate = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers   = flag.String("samplers", "gpu_power", "comma-separated powermetrics samplers")
+	)
+	flag.Parse()
+
+	if *schema {
+		data, err := jsonfeed.Schema()
+		if err != nil {
+			log.Fatalf("pmjson: %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	enc := jsonfeed.NewEncoder(os.Stdout)
+	if *recording != "" {
+		f, err := os.Open(*recording)
+		if err != nil {
+			log.Fatalf("pmjson: %v", err)
+		}
+		rec, err := powermetrics.ReadRecording(f)
+		_ = f.Close()
+		if err != nil {
+			log.Fatalf("pmjson: %v", err)
+		}
+		for _, sample := range rec.Samples {
+			if err := enc.Encode(sample); err != nil {
+				log.Fatalf("pmjson: %v", err)
+			}
+		}
+		return
+	}
+
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmjson: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pmjson: %v", err)
+	}
+	for sample := range stream.Samples() {
+		if err := enc.Encode(sample); err != nil {
+			_ = stream.Stop()
+			log.Fatalf("pmjson: %v", err)
+		}
+	}
+	if err := stream.Stop(); err != nil {
+		log.Fatalf("pmjson: %v", err)
+	}
+}
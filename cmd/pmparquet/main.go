@@ -0,0 +1,107 @@
+// Command pmparquet writes powermetrics samples to an Apache Parquet file.
+//
+// Usage:
+//
+//	sudo pmparquet -o gpu.parquet [-count 3600] [-sample-rate 1s] [-samplers gpu_power,thermal]
+//	pmparquet -o run.parquet -recording run.plist
+//
+// The file is completed when the sample count is reached or on SIGINT and
+// SIGTERM; its schema is described in pkg/parquetfile.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/parquetfile"
+)
+
+func main() {
+	var (
+		output        = flag.String("o", "", "output file")
+		recording     = flag.String("recording", "", "read samples from a recording instead of running powermetrics")
+		count         = flag.Int("count", 0, "number of powermetrics samples to write, 0 for no limit")
+		sampleRate    = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers      = flag.String("samplers", "gpu_power", "comma-separated powermetrics samplers")
+		rowGroupRows  = flag.Int("row-group-rows", parquetfile.DefaultRowGroupRows, "maximum rows per row group")
+		rowGroupBytes = flag.Int64("row-group-bytes", parquetfile.DefaultRowGroupBytes, "maximum uncompressed bytes per row group")
+		flushInterval = flag.Duration("flush-interval", parquetfile.DefaultFlushInterval, "maximum age of a row group, negative to disable")
+	)
+	flag.Parse()
+
+	if *output == "" {
+		fmt.Fprintln(os.Stderr, "pmparquet: -o is required")
+		os.Exit(2)
+	}
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmparquet: %v\n", err)
+		os.Exit(2)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("pmparquet: %v", err)
+	}
+	w := parquetfile.NewWriter(f, parquetfile.Options{
+		RowGroupRows:  *rowGroupRows,
+		RowGroupBytes: *rowGroupBytes,
+		FlushInterval: *flushInterval,
+	})
+
+	if *recording != "" {
+		err = writeRecording(w, *recording)
+	} else {
+		err = writeStream(w, config)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("pmparquet: %v", err)
+	}
+}
+
+func writeRecording(w *parquetfile.Writer, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	rec, err := powermetrics.ReadRecording(in)
+	_ = in.Close()
+	if err != nil {
+		return err
+	}
+	for _, sample := range rec.Samples {
+		if err := w.Write(sample); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func writeStream(w *parquetfile.Writer, config *powermetrics.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		return err
+	}
+	if err := w.Run(stream); err != nil {
+		_ = stream.Stop()
+		return err
+	}
+	return stream.Stop()
+}
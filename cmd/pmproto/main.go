@@ -0,0 +1,114 @@
+// Command pmproto writes powermetrics samples as a length-delimited protocol
+// buffer stream, and converts such streams back to newline-delimited JSON.
+//
+// Usage:
+//
+//	sudo pmproto [-count 10] [-sample-rate 1s] [-samplers gpu_power,tasks,thermal] > run.pb
+//	pmproto -recording run.plist > run.pb
+//	pmproto -decode < run.pb
+//
+// Messages follow pkg/pb/powermetrics.proto; -decode prints the records
+// defined by pkg/jsonfeed.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/jsonfeed"
+	"github.com/matiasinsaurralde/powermetrics/pkg/pb"
+)
+
+func main() {
+	var (
+		decode     = flag.Bool("decode", false, "read a protobuf stream from stdin and write newline-delimited JSON")
+		recording  = flag.String("recording", "", "read samples from a recording instead of running powermetrics")
+		count      = flag.Int("count", 0, "number of powermetrics samples to write, 0 for no limit")
+		sampleRate = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers   = flag.String("samplers", "gpu_power", "comma-separated powermetrics samplers")
+	)
+	flag.Parse()
+
+	if *decode {
+		dec := pb.NewDecoder(os.Stdin)
+		enc := jsonfeed.NewEncoder(os.Stdout)
+		for {
+			sample, err := dec.Decode()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				log.Fatalf("pmproto: %v", err)
+			}
+			if err := enc.Encode(sample); err != nil {
+				log.Fatalf("pmproto: %v", err)
+			}
+		}
+	}
+
+	// Samples are flushed one by one, so that readers of a pipe see them as
+	// they arrive.
+	out := bufio.NewWriter(os.Stdout)
+	enc := pb.NewEncoder(out)
+	if *recording != "" {
+		f, err := os.Open(*recording)
+		if err != nil {
+			log.Fatalf("pmproto: %v", err)
+		}
+		rec, err := powermetrics.ReadRecording(f)
+		_ = f.Close()
+		if err != nil {
+			log.Fatalf("pmproto: %v", err)
+		}
+		for _, sample := range rec.Samples {
+			if err := enc.Encode(sample); err != nil {
+				log.Fatalf("pmproto: %v", err)
+			}
+		}
+		if err := out.Flush(); err != nil {
+			log.Fatalf("pmproto: %v", err)
+		}
+		return
+	}
+
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmproto: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pmproto: %v", err)
+	}
+	for sample := range stream.Samples() {
+		err := enc.Encode(sample)
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			_ = stream.Stop()
+			log.Fatalf("pmproto: %v", err)
+		}
+	}
+	if err := stream.Stop(); err != nil {
+		log.Fatalf("pmproto: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Command pmremotewrite pushes powermetrics samples to a Prometheus
+// remote-write endpoint.
+//
+// Usage:
+//
+//	sudo pmremotewrite -url http://localhost:9090/api/v1/write [-sample-rate 1s] [-samplers gpu_power,tasks,thermal]
+//	sudo pmremotewrite -url URL -queue-dir /var/lib/pmremotewrite -labels instance=mac-1
+//
+// The bearer token, if any, is read from REMOTE_WRITE_TOKEN. With
+// -queue-dir, requests that could not be sent are kept on disk and sent
+// once the endpoint is back, even after a restart.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/prom"
+	"github.com/matiasinsaurralde/powermetrics/pkg/remotewrite"
+)
+
+func main() {
+	var (
+		count             = flag.Int("count", 0, "number of powermetrics samples to send, 0 for no limit")
+		sampleRate        = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers          = flag.String("samplers", "gpu_power,tasks,thermal", "comma-separated powermetrics samplers")
+		writeURL          = flag.String("url", "", "remote-write endpoint, e.g. http://localhost:9090/api/v1/write")
+		labels            = flag.String("labels", "", "comma-separated name=value labels added to every series")
+		queueDir          = flag.String("queue-dir", "", "directory where unsent requests are kept, in memory when empty")
+		flushInterval     = flag.Duration("flush-interval", remotewrite.DefaultFlushInterval, "longest delay before samples are sent")
+		maxSamplesPerSend = flag.Int("max-samples-per-send", remotewrite.DefaultMaxSamplesPerSend, "samples per request")
+	)
+	flag.Parse()
+
+	if *writeURL == "" {
+		fmt.Fprintln(os.Stderr, "pmremotewrite: -url is required")
+		os.Exit(2)
+	}
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmremotewrite: %v\n", err)
+		os.Exit(2)
+	}
+
+	opts := remotewrite.Options{
+		QueueDir:          *queueDir,
+		FlushInterval:     *flushInterval,
+		MaxSamplesPerSend: *maxSamplesPerSend,
+		OnError:           func(err error) { log.Printf("pmremotewrite: %v", err) },
+	}
+	for _, label := range strings.Split(*labels, ",") {
+		if label = strings.TrimSpace(label); label == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(label, "=")
+		if !ok || name == "" {
+			fmt.Fprintf(os.Stderr, "pmremotewrite: invalid label %q, expected name=value\n", label)
+			os.Exit(2)
+		}
+		opts.ExtraLabels = append(opts.ExtraLabels, prom.Label{Name: name, Value: value})
+	}
+	if token := os.Getenv("REMOTE_WRITE_TOKEN"); token != "" {
+		opts.Headers = map[string]string{"Authorization": "Bearer " + token}
+	}
+	client, err := remotewrite.NewClient(*writeURL, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmremotewrite: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pmremotewrite: %v", err)
+	}
+	err = client.Run(ctx, stream)
+	if stopErr := stream.Stop(); stopErr != nil {
+		log.Fatalf("pmremotewrite: %v", stopErr)
+	}
+	if err != nil {
+		log.Fatalf("pmremotewrite: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// interrupt sends an interrupt to p.
+func interrupt(p *os.Process) {
+	_ = p.Signal(os.Interrupt)
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// interrupt sends SIGINT to the process group led by p.
+func interrupt(p *os.Process) {
+	_ = syscall.Kill(-p.Pid, syscall.SIGINT)
+}
@@ -0,0 +1,110 @@
+// Command pmrun runs a command and reports the CPU time, wakeups and energy
+// impact of that command and its descendants only.
+//
+// Usage:
+//
+//	sudo pmrun [flags] -- command [args...]
+//
+// The command inherits the standard streams, and pmrun exits with its exit
+// code. The report is written to standard error.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+)
+
+func main() {
+	sampleRate := flag.Duration("sample-rate", powermetrics.DefaultProcessSampleRate, "powermetrics sample rate")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -- command [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// The command runs in a process group of its own, which interrupts from
+	// the terminal do not reach, so they are forwarded once it has started.
+	var process atomic.Pointer[os.Process]
+	stopCatching := catchInterrupts(func() {
+		if p := process.Load(); p != nil {
+			interrupt(p)
+		}
+	})
+	defer stopCatching()
+
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	config := &powermetrics.Config{
+		SampleRate: *sampleRate,
+		Format:     powermetrics.FormatPlist,
+		Samplers:   []powermetrics.Sampler{powermetrics.GPUPower, powermetrics.Tasks},
+	}
+	run, err := powermetrics.StartProcess(context.Background(), config, cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmrun: %v\n", err)
+		os.Exit(1)
+	}
+	process.Store(run.Process)
+	usage, err := run.Wait()
+	if usage == nil {
+		fmt.Fprintf(os.Stderr, "pmrun: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "\npmrun: %d process(es) attributed to pid %d over %v (%d samples)\n",
+		len(usage.PIDs), usage.PID, usage.System.Duration.Round(time.Millisecond), usage.Samples)
+	fmt.Fprintf(os.Stderr, "  CPU time:      %v\n", usage.CPUTime.Round(time.Microsecond))
+	fmt.Fprintf(os.Stderr, "  Wakeups:       %d\n", usage.Wakeups)
+	fmt.Fprintf(os.Stderr, "  Energy impact: %.2f\n", usage.EnergyImpact)
+	fmt.Fprintf(os.Stderr, "  System energy: %v (%v average)\n", usage.System.Energy, usage.System.AveragePower)
+
+	if usage.ProcessState != nil {
+		os.Exit(usage.ProcessState.ExitCode())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmrun: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// catchInterrupts keeps pmrun running on interrupts, so that it reports the
+// usage once the command exits, and calls forward on each of them. An
+// interrupt from the terminal also reaches powermetrics, which belongs to
+// the same process group. Unlike an ignored signal, which children inherit,
+// a caught signal leaves them the default disposition. The returned function
+// stops catching.
+func catchInterrupts(forward func()) func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				forward()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
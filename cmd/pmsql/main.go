@@ -0,0 +1,127 @@
+// Command pmsql records powermetrics samples in a SQLite database, and
+// prints the samples of a time range back as newline-delimited JSON.
+//
+// Usage:
+//
+//	sudo pmsql -db samples.db [-count 0] [-sample-rate 1s] [-samplers gpu_power,tasks,thermal]
+//	pmsql -db samples.db -query [-from 2025-07-06T05:00:00Z] [-to 2025-07-06T06:00:00Z]
+//
+// The tables are described by pkg/sqlstore; -query prints the records
+// defined by pkg/jsonfeed.
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/jsonfeed"
+	"github.com/matiasinsaurralde/powermetrics/pkg/sqlstore"
+)
+
+func main() {
+	var (
+		dbPath        = flag.String("db", "", "SQLite database file (required)")
+		count         = flag.Int("count", 0, "number of powermetrics samples to record, 0 for no limit")
+		sampleRate    = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers      = flag.String("samplers", "gpu_power,tasks,thermal", "comma-separated powermetrics samplers")
+		batchSize     = flag.Int("batch-size", sqlstore.DefaultBatchSize, "samples per transaction")
+		flushInterval = flag.Duration("flush-interval", sqlstore.DefaultFlushInterval, "longest delay before samples are inserted")
+		query         = flag.Bool("query", false, "print stored samples instead of recording")
+		from          = flag.String("from", "", "with -query, RFC 3339 time of the first sample, the beginning when empty")
+		to            = flag.String("to", "", "with -query, RFC 3339 time after the last sample, now when empty")
+	)
+	flag.Parse()
+
+	if *dbPath == "" {
+		fmt.Fprintln(os.Stderr, "pmsql: -db is required")
+		os.Exit(2)
+	}
+	start, err := parseTime(*from, time.Unix(0, 0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmsql: invalid -from: %v\n", err)
+		os.Exit(2)
+	}
+	end, err := parseTime(*to, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmsql: invalid -to: %v\n", err)
+		os.Exit(2)
+	}
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmsql: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := sql.Open("sqlite", *dbPath)
+	if err != nil {
+		log.Fatalf("pmsql: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	store, err := sqlstore.Open(ctx, db, sqlstore.Options{
+		BatchSize:     *batchSize,
+		FlushInterval: *flushInterval,
+		OnError:       func(err error) { log.Printf("pmsql: %v", err) },
+	})
+	if err != nil {
+		log.Fatalf("pmsql: %v", err)
+	}
+
+	if *query {
+		if err := printSamples(ctx, store, start, end); err != nil {
+			log.Fatalf("pmsql: %v", err)
+		}
+		return
+	}
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pmsql: %v", err)
+	}
+	err = store.Run(ctx, stream)
+	if stopErr := stream.Stop(); stopErr != nil {
+		log.Fatalf("pmsql: %v", stopErr)
+	}
+	if err != nil {
+		log.Fatalf("pmsql: %v", err)
+	}
+}
+
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func printSamples(ctx context.Context, store *sqlstore.Store, from, to time.Time) error {
+	samples, err := store.Query(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	enc := jsonfeed.NewEncoder(out)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,83 @@
+// Command pmstatsd pushes powermetrics samples to a StatsD or DogStatsD
+// agent.
+//
+// Usage:
+//
+//	sudo pmstatsd [-addr 127.0.0.1:8125] [-sample-rate 1s] [-samplers gpu_power,tasks,thermal]
+//	sudo pmstatsd -network unixgram -addr /var/run/datadog/dsd.socket -dogstatsd -tags env:prod
+//
+// Without -dogstatsd, states are part of the metric names, as plain StatsD
+// has no tags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/statsd"
+)
+
+func main() {
+	var (
+		count         = flag.Int("count", 0, "number of powermetrics samples to send, 0 for no limit")
+		sampleRate    = flag.Duration("sample-rate", time.Second, "powermetrics sample rate")
+		samplers      = flag.String("samplers", "gpu_power,tasks,thermal", "comma-separated powermetrics samplers")
+		network       = flag.String("network", "udp", "udp or unixgram")
+		addr          = flag.String("addr", "127.0.0.1:8125", "agent address, or socket path with -network unixgram")
+		prefix        = flag.String("prefix", statsd.DefaultPrefix, "metric name prefix")
+		dogStatsD     = flag.Bool("dogstatsd", false, "send DogStatsD tags")
+		tags          = flag.String("tags", "", "comma-separated key:value tags added to every metric with -dogstatsd")
+		maxPacketSize = flag.Int("max-packet-size", 0, "maximum datagram size, 0 for the network default")
+	)
+	flag.Parse()
+
+	config := &powermetrics.Config{SampleCount: *count, SampleRate: *sampleRate, Format: powermetrics.FormatPlist}
+	for _, name := range strings.Split(*samplers, ",") {
+		config.Samplers = append(config.Samplers, powermetrics.Sampler(strings.TrimSpace(name)))
+	}
+	if err := powermetrics.ValidateSamplers(config.Samplers); err != nil {
+		fmt.Fprintf(os.Stderr, "pmstatsd: %v\n", err)
+		os.Exit(2)
+	}
+	if *tags != "" && !*dogStatsD {
+		fmt.Fprintln(os.Stderr, "pmstatsd: -tags requires -dogstatsd")
+		os.Exit(2)
+	}
+
+	opts := statsd.Options{Prefix: *prefix, DogStatsD: *dogStatsD}
+	for _, tag := range strings.Split(*tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			opts.ExtraTags = append(opts.ExtraTags, tag)
+		}
+	}
+	client, err := statsd.NewClient(*network, *addr, statsd.ClientOptions{
+		MaxPacketSize: *maxPacketSize,
+		Encoding:      opts,
+		OnError:       func(err error) { log.Printf("pmstatsd: %v", err) },
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmstatsd: %v\n", err)
+		os.Exit(2)
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stream, err := powermetrics.New().Stream(ctx, config)
+	if err != nil {
+		log.Fatalf("pmstatsd: %v", err)
+	}
+	client.Run(stream)
+	if err := stream.Stop(); err != nil {
+		log.Fatalf("pmstatsd: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package powermetrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// Efficiency relates the work counted by an EfficiencyTracker to the energy
+// spent over the same sample intervals.
+type Efficiency struct {
+	Work   float64
+	Energy units.Energy
+	// Start and End delimit the intervals since the stream started.
+	Start time.Duration
+	End   time.Duration
+}
+
+// Duration returns the length of the intervals.
+func (e Efficiency) Duration() time.Duration {
+	return e.End - e.Start
+}
+
+// WorkPerJoule returns the work done per joule, or zero when no energy was
+// spent.
+func (e Efficiency) WorkPerJoule() float64 {
+	if e.Energy <= 0 {
+		return 0
+	}
+	return e.Work / e.Energy.Joules()
+}
+
+// EnergyPerUnit returns the energy spent per unit of work, or zero when no
+// work was done.
+func (e Efficiency) EnergyPerUnit() units.Energy {
+	if e.Work <= 0 {
+		return 0
+	}
+	return e.Energy / units.Energy(e.Work)
+}
+
+// Throughput returns the work done per second.
+func (e Efficiency) Throughput() float64 {
+	if e.Duration() <= 0 {
+		return 0
+	}
+	return e.Work / e.Duration().Seconds()
+}
+
+// AveragePower returns the average power over the intervals.
+func (e Efficiency) AveragePower() units.Power {
+	return e.Energy.Over(e.Duration())
+}
+
+// EfficiencyTracker counts work, such as requests, frames or tokens, while a
+// stream runs, and attributes it to the sample intervals it was done in.
+// It is safe for concurrent use.
+type EfficiencyTracker struct {
+	started   time.Time
+	perSample int
+	window    time.Duration
+
+	mu        sync.Mutex
+	seen      int
+	pending   units.Energy
+	offset    time.Duration
+	events    []workEvent
+	intervals []workInterval
+	total     map[string]*Efficiency
+}
+
+type workEvent struct {
+	counter string
+	offset  time.Duration
+	n       float64
+}
+
+// workInterval is the energy and work of one powermetrics sample.
+type workInterval struct {
+	start, end time.Duration
+	energy     units.Energy
+	work       map[string]float64
+}
+
+// NewEfficiencyTracker returns a tracker for a stream started at started,
+// such as Stream.Started, whose samples are decoded by the given number of
+// samplers per powermetrics sample. Efficiency reports over the most recent
+// intervals spanning at least window; zero means the last interval only.
+func NewEfficiencyTracker(started time.Time, samplers int, window time.Duration) *EfficiencyTracker {
+	if samplers < 1 {
+		samplers = 1
+	}
+	return &EfficiencyTracker{
+		started:   started,
+		perSample: samplers,
+		window:    window,
+		total:     make(map[string]*Efficiency),
+	}
+}
+
+// TrackEfficiency tracks every sample of stream in a new goroutine, which
+// ends with the stream. The stream's samples must not be consumed
+// elsewhere; use NewEfficiencyTracker and Observe to combine the tracker
+// with other processing.
+func TrackEfficiency(stream *Stream, window time.Duration) *EfficiencyTracker {
+	t := NewEfficiencyTracker(stream.Started(), len(stream.samplers), window)
+	go func() {
+		for sample := range stream.Samples() {
+			t.Observe(sample)
+		}
+	}()
+	return t
+}
+
+// Add counts n units of work for counter now.
+func (t *EfficiencyTracker) Add(counter string, n float64) {
+	t.AddAt(counter, n, time.Now())
+}
+
+// AddAt counts n units of work for counter at the given time. Work is
+// attributed to the interval containing at; work older than the last
+// completed interval is attributed to the next one.
+func (t *EfficiencyTracker) AddAt(counter string, n float64, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, workEvent{counter: counter, offset: at.Sub(t.started), n: n})
+	if _, ok := t.total[counter]; !ok {
+		t.total[counter] = &Efficiency{}
+	}
+}
+
+// Observe adds a sample. The work counted before the end of its interval is
+// attributed to it once every sampler of the interval was observed.
+func (t *EfficiencyTracker) Observe(sample types.Sample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, e := range energy.Readings(sample) {
+		t.pending += e
+	}
+	t.seen++
+	if t.seen%t.perSample != 0 {
+		return
+	}
+
+	interval := workInterval{
+		start:  t.offset,
+		end:    t.offset + time.Duration(sample.GetElapsedNS()),
+		energy: t.pending,
+		work:   make(map[string]float64),
+	}
+	t.offset, t.pending = interval.end, 0
+
+	sort.SliceStable(t.events, func(i, j int) bool { return t.events[i].offset < t.events[j].offset })
+	n := sort.Search(len(t.events), func(i int) bool { return t.events[i].offset >= interval.end })
+	for _, event := range t.events[:n] {
+		interval.work[event.counter] += event.n
+	}
+	t.events = append(t.events[:0], t.events[n:]...)
+
+	for counter, total := range t.total {
+		if total.End == 0 {
+			total.Start = interval.start
+		}
+		total.Work += interval.work[counter]
+		total.Energy += interval.energy
+		total.End = interval.end
+	}
+	t.intervals = append(t.intervals, interval)
+
+	// Keep the intervals of the most recent window only.
+	var covered time.Duration
+	for i := len(t.intervals) - 1; i >= 0; i-- {
+		covered += t.intervals[i].end - t.intervals[i].start
+		if covered >= t.window {
+			t.intervals = t.intervals[i:]
+			break
+		}
+	}
+}
+
+// Efficiency returns the efficiency of counter over the most recent
+// intervals spanning at least the window, or every interval while the
+// stream is shorter than the window. Windows always start and end on sample
+// boundaries.
+func (t *EfficiencyTracker) Efficiency(counter string) Efficiency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var e Efficiency
+	for i, interval := range t.intervals {
+		if i == 0 {
+			e.Start = interval.start
+		}
+		e.End = interval.end
+		e.Work += interval.work[counter]
+		e.Energy += interval.energy
+	}
+	return e
+}
+
+// Total returns the efficiency of counter over every interval since the
+// counter was first used.
+func (t *EfficiencyTracker) Total(counter string) Efficiency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if total, ok := t.total[counter]; ok {
+		return *total
+	}
+	return Efficiency{}
+}
+
+// Counters returns the names of the counters, sorted.
+func (t *EfficiencyTracker) Counters() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counters := make([]string, 0, len(t.total))
+	for counter := range t.total {
+		counters = append(counters, counter)
+	}
+	sort.Strings(counters)
+	return counters
+}
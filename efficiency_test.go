@@ -0,0 +1,74 @@
+package powermetrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+func TestEfficiencyTracker(t *testing.T) {
+	mJ := func(v int64) *int64 { return &v }
+	started := time.Date(2025, 7, 6, 5, 15, 0, 0, time.UTC)
+	at := func(offset time.Duration) time.Time { return started.Add(offset) }
+	interval := func(energy int64) []types.Sample {
+		base := types.BaseSample{ElapsedNS: int64(time.Second)}
+		return []types.Sample{
+			&types.GPUPowerSample{BaseSample: base, GPU: types.GPUInfo{GPUEnergy: mJ(energy)}},
+			&types.ThermalSample{BaseSample: base},
+		}
+	}
+
+	tracker := NewEfficiencyTracker(started, 2, 2*time.Second)
+	tracker.AddAt("tokens", 100, at(200*time.Millisecond))
+	tracker.AddAt("tokens", 100, at(900*time.Millisecond))
+	tracker.AddAt("frames", 30, at(1500*time.Millisecond))
+	tracker.AddAt("tokens", 400, at(1100*time.Millisecond))
+	// Work done after the last sample is not attributed yet.
+	tracker.AddAt("tokens", 1000, at(2500*time.Millisecond))
+
+	for _, energy := range []int64{1000, 4000} {
+		for _, sample := range interval(energy) {
+			tracker.Observe(sample)
+		}
+	}
+
+	tokens := tracker.Efficiency("tokens")
+	if tokens.Work != 600 || tokens.Energy != 5*units.Joule || tokens.Duration() != 2*time.Second {
+		t.Fatalf("Expected 600 tokens for 5 J over 2s, got %+v", tokens)
+	}
+	if tokens.WorkPerJoule() != 120 {
+		t.Errorf("Expected 120 tokens/J, got %v", tokens.WorkPerJoule())
+	}
+	if math.Abs(tokens.EnergyPerUnit().Millijoules()-1e3/120) > 1e-9 {
+		t.Errorf("Expected %v mJ/token, got %v", 1e3/120, tokens.EnergyPerUnit())
+	}
+	if tokens.Throughput() != 300 || tokens.AveragePower() != 2.5*units.Watt {
+		t.Errorf("Expected 300 tokens/s at 2.5 W, got %v at %v", tokens.Throughput(), tokens.AveragePower())
+	}
+
+	// The window slides by whole intervals.
+	for _, sample := range interval(1000) {
+		tracker.Observe(sample)
+	}
+	tokens = tracker.Efficiency("tokens")
+	if tokens.Start != time.Second || tokens.End != 3*time.Second || tokens.Work != 1400 || tokens.Energy != 5*units.Joule {
+		t.Errorf("Expected 1400 tokens for 5 J from 1s to 3s, got %+v", tokens)
+	}
+	if total := tracker.Total("tokens"); total.Work != 1600 || total.Energy != 6*units.Joule || total.Duration() != 3*time.Second {
+		t.Errorf("Expected 1600 tokens for 6 J over 3s in total, got %+v", total)
+	}
+	if frames := tracker.Efficiency("frames"); frames.Work != 30 {
+		t.Errorf("Expected 30 frames, got %+v", frames)
+	}
+
+	counters := tracker.Counters()
+	if len(counters) != 2 || counters[0] != "frames" || counters[1] != "tokens" {
+		t.Errorf("Expected frames and tokens, got %v", counters)
+	}
+	if e := tracker.Efficiency("unknown"); e.Work != 0 || e.WorkPerJoule() != 0 || e.EnergyPerUnit() != 0 {
+		t.Errorf("Expected no work for an unknown counter, got %+v", e)
+	}
+}
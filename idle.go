@@ -0,0 +1,190 @@
+package powermetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// ErrNotIdle is returned by WaitForIdle when the system did not become quiet
+// in time.
+var ErrNotIdle = errors.New("system did not become idle")
+
+// IdleCriteria defines when the system is considered quiet. Zero thresholds
+// are not checked, and only the samplers needed by the enabled thresholds
+// are run.
+type IdleCriteria struct {
+	// Quiet is how long every sample must meet the thresholds. Zero means
+	// 10 seconds.
+	Quiet time.Duration
+	// Timeout bounds the wait. Zero waits until the context is done.
+	Timeout time.Duration
+	// SampleRate is the powermetrics sample rate. Zero means one second.
+	SampleRate time.Duration
+	// MinGPUIdleRatio is the lowest acceptable GPU idle ratio.
+	MinGPUIdleRatio float64
+	// MaxCPUUsage is the highest acceptable CPU usage as a fraction of all
+	// CPUs, e.g. 0.05 for 5%.
+	MaxCPUUsage float64
+	// MaxThermalPressure is the most severe acceptable thermal pressure
+	// level, one of types.ThermalPressureLevels, e.g. "Nominal".
+	MaxThermalPressure string
+}
+
+// IdleReport describes the state of the system while waiting for it to
+// become quiet.
+type IdleReport struct {
+	// Waited is how long WaitForIdle waited and Quiet how long the system
+	// had been quiet when it returned.
+	Waited time.Duration
+	Quiet  time.Duration
+	// Samples is the number of powermetrics samples evaluated.
+	Samples int
+	// Reasons explains why the most recent busy sample was rejected.
+	Reasons []string
+	// BusyTasks lists the tasks of the most recent busy sample ordered by
+	// CPU usage, when the tasks sampler ran.
+	BusyTasks []types.TaskInfo
+}
+
+// String summarizes the report.
+func (r *IdleReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "waited %v, quiet for %v", r.Waited.Round(time.Millisecond), r.Quiet.Round(time.Millisecond))
+	if len(r.Reasons) > 0 {
+		fmt.Fprintf(&b, "; busy: %s", strings.Join(r.Reasons, ", "))
+	}
+	for i, task := range r.BusyTasks {
+		if i == 5 {
+			break
+		}
+		fmt.Fprintf(&b, "; %s[%d] %.1f ms/s", task.Name, task.PID, task.CPUTimeMSPerS)
+	}
+	return b.String()
+}
+
+// WaitForIdle waits with the real command runner. See
+// Powermetrics.WaitForIdle.
+func WaitForIdle(ctx context.Context, criteria IdleCriteria) (*IdleReport, error) {
+	return New().WaitForIdle(ctx, criteria)
+}
+
+// WaitForIdle streams samples until every sample has met criteria for
+// criteria.Quiet. When the timeout expires, the context is done or
+// powermetrics exits first, it returns the report together with an error
+// wrapping ErrNotIdle that explains what kept the system busy. An unknown
+// MaxThermalPressure is rejected before sampling starts.
+func (p *Powermetrics) WaitForIdle(ctx context.Context, criteria IdleCriteria) (*IdleReport, error) {
+	if level := criteria.MaxThermalPressure; level != "" &&
+		types.ThermalPressureRank(level) == len(types.ThermalPressureLevels) {
+		return nil, fmt.Errorf("invalid idle criteria: unknown thermal pressure level %q, expected one of %s",
+			level, strings.Join(types.ThermalPressureLevels, ", "))
+	}
+	if criteria.Quiet <= 0 {
+		criteria.Quiet = 10 * time.Second
+	}
+	if criteria.SampleRate <= 0 {
+		criteria.SampleRate = time.Second
+	}
+	if criteria.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, criteria.Timeout)
+		defer cancel()
+	}
+
+	config := &Config{SampleRate: criteria.SampleRate, Format: FormatPlist}
+	if criteria.MinGPUIdleRatio > 0 {
+		config.Samplers = append(config.Samplers, GPUPower)
+	}
+	if criteria.MaxCPUUsage > 0 {
+		config.Samplers = append(config.Samplers, Tasks)
+	}
+	if criteria.MaxThermalPressure != "" {
+		config.Samplers = append(config.Samplers, Thermal)
+	}
+	if len(config.Samplers) == 0 {
+		return &IdleReport{}, nil
+	}
+
+	stream, err := p.Stream(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = stream.Stop() }()
+
+	started := time.Now()
+	report := &IdleReport{}
+	group := make([]types.Sample, 0, len(config.Samplers))
+	for sample := range stream.Samples() {
+		group = append(group, sample)
+		if len(group) < len(config.Samplers) {
+			continue
+		}
+
+		report.Samples++
+		reasons, busyTasks := evaluateIdle(group, criteria, runtime.NumCPU())
+		if len(reasons) == 0 {
+			report.Quiet += time.Duration(sample.GetElapsedNS())
+		} else {
+			report.Quiet = 0
+			report.Reasons = reasons
+			report.BusyTasks = busyTasks
+		}
+		group = group[:0]
+
+		if report.Quiet >= criteria.Quiet {
+			report.Waited = time.Since(started)
+			return report, nil
+		}
+	}
+
+	report.Waited = time.Since(started)
+	if err := stream.Err(); err != nil {
+		return report, err
+	}
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("%w (%s): %w", ErrNotIdle, report, err)
+	}
+	return report, fmt.Errorf("%w (%s): powermetrics exited", ErrNotIdle, report)
+}
+
+// evaluateIdle returns the reasons the samples of one powermetrics sample do
+// not meet criteria, and the tasks ordered by CPU usage when it is too high.
+func evaluateIdle(samples []types.Sample, criteria IdleCriteria, cpus int) ([]string, []types.TaskInfo) {
+	var (
+		reasons   []string
+		busyTasks []types.TaskInfo
+	)
+	for _, sample := range samples {
+		switch s := sample.(type) {
+		case *types.GPUPowerSample:
+			if criteria.MinGPUIdleRatio > 0 && s.GPU.IdleRatio < criteria.MinGPUIdleRatio {
+				reasons = append(reasons, fmt.Sprintf("GPU idle ratio %.2f%% below %.2f%%",
+					s.GPU.IdleRatio*100, criteria.MinGPUIdleRatio*100))
+			}
+		case *types.TasksSample:
+			usage := s.AllTasks.CPUTimeMSPerS / 1000 / float64(cpus)
+			if criteria.MaxCPUUsage > 0 && usage > criteria.MaxCPUUsage {
+				reasons = append(reasons, fmt.Sprintf("CPU usage %.2f%% above %.2f%%",
+					usage*100, criteria.MaxCPUUsage*100))
+				busyTasks = append([]types.TaskInfo(nil), s.Tasks...)
+				sort.SliceStable(busyTasks, func(i, j int) bool {
+					return busyTasks[i].CPUTimeMSPerS > busyTasks[j].CPUTimeMSPerS
+				})
+			}
+		case *types.ThermalSample:
+			if criteria.MaxThermalPressure != "" &&
+				types.ThermalPressureRank(s.ThermalPressure) > types.ThermalPressureRank(criteria.MaxThermalPressure) {
+				reasons = append(reasons, fmt.Sprintf("thermal pressure %s above %s",
+					s.ThermalPressure, criteria.MaxThermalPressure))
+			}
+		}
+	}
+	return reasons, busyTasks
+}
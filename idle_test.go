@@ -0,0 +1,109 @@
+package powermetrics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestWaitForIdle(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+
+	report, err := pm.WaitForIdle(context.Background(), IdleCriteria{
+		Quiet:           10 * time.Second,
+		MinGPUIdleRatio: 0.95,
+	})
+	if err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	if report.Samples != 2 || report.Quiet < 10*time.Second {
+		t.Errorf("Expected to be quiet after 2 samples, got %+v", report)
+	}
+
+	report, err = pm.WaitForIdle(context.Background(), IdleCriteria{
+		Quiet:           10 * time.Second,
+		MinGPUIdleRatio: 0.975,
+	})
+	if !errors.Is(err, ErrNotIdle) {
+		t.Fatalf("Expected ErrNotIdle, got %v", err)
+	}
+	if len(report.Reasons) != 1 || !strings.Contains(report.Reasons[0], "GPU idle ratio 96.07%") {
+		t.Errorf("Expected the last GPU idle ratio as the reason, got %v", report.Reasons)
+	}
+}
+
+func TestEvaluateIdle(t *testing.T) {
+	samples := []types.Sample{
+		&types.GPUPowerSample{GPU: types.GPUInfo{IdleRatio: 0.99}},
+		&types.TasksSample{
+			Tasks: []types.TaskInfo{
+				{PID: 1, Name: "launchd", CPUTimeMSPerS: 5},
+				{PID: 412, Name: "mds_stores", CPUTimeMSPerS: 900},
+			},
+			AllTasks: types.TaskInfo{CPUTimeMSPerS: 905},
+		},
+		&types.ThermalSample{ThermalPressure: "Heavy"},
+	}
+	criteria := IdleCriteria{
+		MinGPUIdleRatio:    0.95,
+		MaxCPUUsage:        0.05,
+		MaxThermalPressure: "Nominal",
+	}
+
+	reasons, busy := evaluateIdle(samples, criteria, 8)
+	if len(reasons) != 2 {
+		t.Fatalf("Expected CPU usage and thermal pressure reasons, got %v", reasons)
+	}
+	if !strings.Contains(reasons[0], "CPU usage 11.31%") || !strings.Contains(reasons[1], "thermal pressure Heavy") {
+		t.Errorf("Unexpected reasons %v", reasons)
+	}
+	if len(busy) != 2 || busy[0].Name != "mds_stores" {
+		t.Errorf("Expected mds_stores to be the busiest task, got %v", busy)
+	}
+
+	criteria.MaxCPUUsage = 0.2
+	criteria.MaxThermalPressure = "Heavy"
+	if reasons, _ := evaluateIdle(samples, criteria, 8); len(reasons) != 0 {
+		t.Errorf("Expected the samples to be quiet, got %v", reasons)
+	}
+}
+
+func TestWaitForIdleUnknownThermalPressure(t *testing.T) {
+	for _, level := range []string{"heavy", "Serious"} {
+		pm := NewWithRunner(&MockCommandRunner{})
+		report, err := pm.WaitForIdle(context.Background(), IdleCriteria{MaxThermalPressure: level})
+		if report != nil || err == nil || !strings.Contains(err.Error(), "unknown thermal pressure level") {
+			t.Errorf("Expected %q to be rejected before sampling, got %v and %v", level, report, err)
+		}
+	}
+}
+
+func TestThermalXMLUnmarshaling(t *testing.T) {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+<key>elapsed_ns</key><integer>1000000000</integer>
+<key>hw_model</key><string>Mac16,8</string>
+<key>timestamp</key><date>2025-07-08T05:36:13Z</date>
+<key>thermal_pressure</key><string>Nominal</string>
+</dict>
+</plist>`)
+
+	samples, err := decodeSamples(doc, []Sampler{Thermal})
+	if err != nil {
+		t.Fatalf("Failed to decode thermal sample: %v", err)
+	}
+	thermal, ok := samples[0].(*types.ThermalSample)
+	if !ok || thermal.ThermalPressure != "Nominal" {
+		t.Errorf("Expected Nominal thermal pressure, got %+v", samples[0])
+	}
+}
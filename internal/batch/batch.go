@@ -0,0 +1,47 @@
+// Package batch runs the loop shared by the sinks that buffer samples and
+// send them in batches.
+package batch
+
+import (
+	"context"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Sink buffers samples on Write and sends them on Flush.
+type Sink interface {
+	Write(ctx context.Context, sample types.Sample) error
+	Flush(ctx context.Context) error
+}
+
+// Run writes every sample of samples to sink until the channel is closed,
+// flushing at least every interval, and flushes the last batch. Errors are
+// reported to onError, if not nil; Run returns the error of the last flush.
+func Run(ctx context.Context, samples <-chan types.Sample, sink Sink, interval time.Duration, onError func(error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	report := func(err error) {
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+	for {
+		select {
+		case sample, ok := <-samples:
+			if !ok {
+				// The context may be done already, so the last batch gets
+				// a context of its own.
+				flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), interval)
+				defer cancel()
+				err := sink.Flush(flushCtx)
+				report(err)
+				return err
+			}
+			report(sink.Write(ctx, sample))
+		case <-ticker.C:
+			report(sink.Flush(ctx))
+		}
+	}
+}
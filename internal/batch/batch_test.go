@@ -0,0 +1,70 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+type recorder struct {
+	written, flushed int
+	flushErr         error
+	lastCtxErr       error
+}
+
+func (r *recorder) Write(ctx context.Context, sample types.Sample) error {
+	r.written++
+	return nil
+}
+
+func (r *recorder) Flush(ctx context.Context) error {
+	r.flushed++
+	r.lastCtxErr = ctx.Err()
+	return r.flushErr
+}
+
+func TestRun(t *testing.T) {
+	samples := make(chan types.Sample, 3)
+	for range 3 {
+		samples <- &types.ThermalSample{}
+	}
+	close(samples)
+
+	// The last batch is flushed even once the context is done.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	wantErr := errors.New("unavailable")
+	r := &recorder{flushErr: wantErr}
+	var reported []error
+	err := Run(ctx, samples, r, time.Hour, func(err error) { reported = append(reported, err) })
+	if !errors.Is(err, wantErr) {
+		t.Errorf("Expected the error of the last flush, got %v", err)
+	}
+	if r.written != 3 || r.flushed != 1 {
+		t.Errorf("Expected 3 writes and 1 flush, got %d and %d", r.written, r.flushed)
+	}
+	if r.lastCtxErr != nil {
+		t.Errorf("Expected the last flush to get a live context, got %v", r.lastCtxErr)
+	}
+	if len(reported) != 1 {
+		t.Errorf("Expected 1 reported error, got %v", reported)
+	}
+}
+
+func TestRunFlushesPeriodically(t *testing.T) {
+	samples := make(chan types.Sample)
+	r := &recorder{}
+	done := make(chan error)
+	go func() { done <- Run(t.Context(), samples, r, 10*time.Millisecond, nil) }()
+	time.Sleep(55 * time.Millisecond)
+	close(samples)
+	if err := <-done; err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if r.flushed < 2 {
+		t.Errorf("Expected periodic flushes, got %d", r.flushed)
+	}
+}
@@ -0,0 +1,54 @@
+// Package sampletest loads the samples of the recordings in testdata for
+// the tests of the encoders and sinks.
+package sampletest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Recordings of testdata
+const (
+	GPUPower = "gpu_power_multiple_samples.xml"
+	Tasks    = "tasks_multiple_samples.xml"
+)
+
+// Read returns the samples of the recording name in testdata.
+func Read(t *testing.T, name string) []types.Sample {
+	t.Helper()
+	path := filepath.Join(testdata(), name)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer func() { _ = f.Close() }()
+	recording, err := powermetrics.ReadRecording(f)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return recording.Samples
+}
+
+// Samples returns samples of every sampler: the GPU power and tasks
+// recordings followed by a thermal and a battery sample.
+func Samples(t *testing.T) []types.Sample {
+	t.Helper()
+	samples := Read(t, GPUPower)
+	samples = append(samples, Read(t, Tasks)...)
+	return append(samples,
+		&types.ThermalSample{BaseSample: types.BaseSample{HWModel: "Mac16,8"}, ThermalPressure: "Moderate"},
+		&types.BatterySample{Battery: types.BatteryInfo{PercentCharge: 87}},
+	)
+}
+
+// testdata returns the directory of the recordings, which tests of every
+// package find next to this file.
+func testdata() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "testdata")
+}
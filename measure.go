@@ -0,0 +1,238 @@
+package powermetrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// DefaultMeasureSampleRate is the sample rate Measure uses when no
+// configuration is given. Short intervals keep the wait for the sample that
+// covers the end of the workload short.
+const DefaultMeasureSampleRate = 100 * time.Millisecond
+
+// Measurement is the energy attributed to a workload run by Measure.
+type Measurement struct {
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	// Energy is the sum of the component energies.
+	Energy       units.Energy
+	Components   map[energy.Component]units.Energy
+	AveragePower units.Power
+	// Covered is the part of Duration accounted for by samples. It is
+	// shorter than Duration when powermetrics exited early.
+	Covered time.Duration
+	// Samples holds the samples overlapping the workload.
+	Samples []types.Sample
+}
+
+// Measure runs workload with the real command runner. See
+// Powermetrics.Measure.
+func Measure(ctx context.Context, config *Config, workload func(context.Context) error) (*Measurement, error) {
+	return New().Measure(ctx, config, workload)
+}
+
+// Measure starts sampling, runs workload and stops sampling once the sample
+// covering the end of the workload has arrived. Samples straddling the start
+// or end of the workload are prorated by the part of their interval that
+// overlaps it.
+//
+// SampleCount is ignored. A nil config samples the GPU every
+// DefaultMeasureSampleRate; a config without samplers is rejected with
+// ErrNoSamplers. If workload fails, the measurement is returned
+// together with the workload's error. Invalid samples are skipped, but their
+// interval is kept between the ones around them; when it is unknown, the
+// measurement is returned together with ErrSkippedSamples.
+func (p *Powermetrics) Measure(ctx context.Context, config *Config, workload func(context.Context) error) (*Measurement, error) {
+	if config == nil {
+		config = DefaultConfig().GPU()
+		config.SampleRate = DefaultMeasureSampleRate
+	}
+	return p.measure(ctx, config, workload, nil)
+}
+
+// measure implements Measure. When observe is not nil it is called with
+// every sample as it arrives, from a single goroutine.
+func (p *Powermetrics) measure(ctx context.Context, config *Config, workload func(context.Context) error, observe func(types.Sample)) (*Measurement, error) {
+	// Every powermetrics sample yields one value per decoded sampler, so
+	// the interval covered by a sample is complete once all of them
+	// arrived.
+	perSample := 0
+	for _, sampler := range config.Samplers {
+		if newSample(sampler) != nil {
+			perSample++
+		}
+	}
+	if perSample == 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", ErrNoSamplers)
+	}
+
+	streamConfig := *config
+	streamConfig.SampleCount = 0
+
+	stream, err := p.Stream(ctx, &streamConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		collected []types.Sample
+		// starts holds the start of the interval of every powermetrics
+		// sample, chained using their elapsed time, and chainEnd the end
+		// of the last one.
+		starts   []time.Time
+		chainEnd time.Time
+		covered  time.Time
+		progress = make(chan struct{}, 1)
+		drained  = make(chan struct{})
+	)
+	go func() {
+		defer close(drained)
+		for sample := range stream.Samples() {
+			mu.Lock()
+			n := len(collected)
+			if n%perSample == 0 {
+				if n == 0 {
+					chainEnd = firstIntervalStart(stream.Started().Add(stream.skippedBefore(0)), sample)
+				} else {
+					chainEnd = chainEnd.Add(stream.skippedBefore(n))
+				}
+				starts = append(starts, chainEnd)
+				chainEnd = chainEnd.Add(time.Duration(sample.GetElapsedNS()))
+			}
+			collected = append(collected, sample)
+			if len(collected)%perSample == 0 {
+				covered = chainEnd
+			}
+			mu.Unlock()
+			if observe != nil {
+				observe(sample)
+			}
+			select {
+			case progress <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	start := time.Now()
+	workloadErr := workload(ctx)
+	end := time.Now()
+
+wait:
+	for {
+		mu.Lock()
+		done := !covered.IsZero() && !covered.Before(end)
+		mu.Unlock()
+		if done {
+			break
+		}
+		select {
+		case <-progress:
+		case <-drained:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	streamErr := stream.Stop()
+	<-drained
+	if streamErr != nil {
+		return nil, streamErr
+	}
+
+	m := prorate(starts, collected, perSample, start, end)
+	if workloadErr != nil {
+		return m, workloadErr
+	}
+	if n := stream.skippedUnknownCount(); n > 0 {
+		return m, fmt.Errorf("%w: %d", ErrSkippedSamples, n)
+	}
+	if err := ctx.Err(); err != nil {
+		return m, fmt.Errorf("measurement interrupted: %w", err)
+	}
+	return m, nil
+}
+
+// firstIntervalStart returns the start of the interval of the first sample
+// of a stream started at started. Both started and the timestamp of the
+// sample minus its elapsed time are lower bounds, as powermetrics takes a
+// while to start sampling and timestamps are truncated to the second, so
+// the later one is used.
+func firstIntervalStart(started time.Time, sample types.Sample) time.Time {
+	if timestamp := sample.GetTimestamp(); !timestamp.IsZero() {
+		return maxTime(started, timestamp.Add(-time.Duration(sample.GetElapsedNS())))
+	}
+	return started
+}
+
+// prorate attributes the energy of samples to the window [start, end].
+// Every perSample consecutive samples were decoded from the same
+// powermetrics sample by different samplers and share an interval, which
+// begins at the matching element of starts. Intervals are chained using
+// their elapsed time, which is far more precise than the one second
+// resolution of their timestamps.
+func prorate(starts []time.Time, samples []types.Sample, perSample int, start, end time.Time) *Measurement {
+	m := &Measurement{
+		Start:      start,
+		End:        end,
+		Duration:   end.Sub(start),
+		Components: make(map[energy.Component]units.Energy),
+	}
+
+	if perSample < 1 {
+		perSample = 1
+	}
+	var intervalStart, intervalEnd time.Time
+	for i, sample := range samples {
+		elapsed := time.Duration(sample.GetElapsedNS())
+		first := i%perSample == 0
+		if first {
+			intervalStart = starts[i/perSample]
+			intervalEnd = intervalStart.Add(elapsed)
+		}
+		if elapsed <= 0 {
+			continue
+		}
+
+		overlap := minTime(intervalEnd, end).Sub(maxTime(intervalStart, start))
+		if overlap <= 0 {
+			continue
+		}
+		fraction := float64(overlap) / float64(elapsed)
+		for component, e := range energy.Readings(sample) {
+			share := e * units.Energy(fraction)
+			m.Components[component] += share
+			m.Energy += share
+		}
+		m.Samples = append(m.Samples, sample)
+		if first {
+			m.Covered += overlap
+		}
+	}
+
+	m.AveragePower = m.Energy.Over(m.Covered)
+	return m
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
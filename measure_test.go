@@ -0,0 +1,189 @@
+package powermetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestProrate(t *testing.T) {
+	mJ := func(v int64) *int64 { return &v }
+	anchor := time.Date(2025, 7, 6, 5, 15, 0, 0, time.UTC)
+	interval := func(offset time.Duration, energy int64) []types.Sample {
+		base := types.BaseSample{Timestamp: anchor.Add(offset), ElapsedNS: int64(time.Second)}
+		return []types.Sample{
+			&types.GPUPowerSample{BaseSample: base, GPU: types.GPUInfo{GPUEnergy: mJ(energy)}},
+			&types.TasksSample{BaseSample: base},
+		}
+	}
+	// Two samplers per powermetrics sample.
+	var samples []types.Sample
+	samples = append(samples, interval(time.Second, 1000)...)
+	samples = append(samples, interval(2*time.Second, 2000)...)
+	samples = append(samples, interval(3*time.Second, 4000)...)
+
+	// The window covers the second half of the first sample and the first
+	// quarter of the third.
+	start := anchor.Add(500 * time.Millisecond)
+	end := anchor.Add(2250 * time.Millisecond)
+	starts := []time.Time{anchor, anchor.Add(time.Second), anchor.Add(2 * time.Second)}
+	m := prorate(starts, samples, 2, start, end)
+
+	if m.Duration != 1750*time.Millisecond || m.Covered != m.Duration {
+		t.Errorf("Expected 1.75s duration fully covered, got %v and %v", m.Duration, m.Covered)
+	}
+	if math.Abs(m.Energy.Joules()-3.5) > 1e-9 {
+		t.Errorf("Expected 3.5 J, got %v", m.Energy)
+	}
+	if math.Abs(m.Components[energy.GPU].Joules()-3.5) > 1e-9 {
+		t.Errorf("Expected 3.5 J for the GPU, got %v", m.Components[energy.GPU])
+	}
+	if math.Abs(m.AveragePower.Watts()-2) > 1e-9 {
+		t.Errorf("Expected 2 W average, got %v", m.AveragePower)
+	}
+	if len(m.Samples) != 6 {
+		t.Errorf("Expected 6 overlapping samples, got %d", len(m.Samples))
+	}
+}
+
+// gpuDocument returns a powermetrics plist document of the GPU sampler
+// covering elapsed and holding gpu as its gpu value.
+func gpuDocument(elapsed time.Duration, gpu string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+<key>elapsed_ns</key><integer>%d</integer>
+<key>timestamp</key><date>2025-07-06T05:15:15Z</date>
+<key>gpu</key>%s
+</dict>
+</plist>
+`, elapsed, gpu)
+}
+
+func TestFirstIntervalStart(t *testing.T) {
+	started := time.Date(2025, 7, 6, 5, 15, 0, 100*int(time.Millisecond), time.UTC)
+	sample := func(timestamp time.Time) types.Sample {
+		return &types.GPUPowerSample{BaseSample: types.BaseSample{Timestamp: timestamp, ElapsedNS: int64(time.Second)}}
+	}
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      time.Time
+	}{
+		{"no timestamp", time.Time{}, started},
+		{"before started", started.Add(500 * time.Millisecond), started},
+		{"after started", started.Add(2 * time.Second), started.Add(time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstIntervalStart(started, sample(tt.timestamp)); !got.Equal(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMeasureSkippedSample(t *testing.T) {
+	// The second document is invalid, but its interval still separates the
+	// first and third ones.
+	gpu := "<dict><key>gpu_energy</key><integer>100</integer></dict>"
+	output := gpuDocument(50*time.Millisecond, gpu) +
+		gpuDocument(10*time.Second, "<string>invalid</string>") +
+		gpuDocument(time.Second, gpu)
+
+	pm := NewWithRunner(&MockCommandRunner{Output: []byte(output)})
+	m, err := pm.Measure(context.Background(), nil, func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Measure failed: %v", err)
+	}
+	if len(m.Samples) != 1 {
+		t.Errorf("Expected only the first sample to overlap the workload, got %d", len(m.Samples))
+	}
+	if m.Covered >= m.Duration {
+		t.Errorf("Expected the skipped interval not to be covered, got %v of %v", m.Covered, m.Duration)
+	}
+}
+
+func TestMeasureSkippedUnknownSample(t *testing.T) {
+	output := "<plist><dict><key>elapsed_ns</key><string>invalid</string></dict></plist>"
+
+	pm := NewWithRunner(&MockCommandRunner{Output: []byte(output)})
+	_, err := pm.Measure(context.Background(), nil, func(ctx context.Context) error {
+		return nil
+	})
+	if !errors.Is(err, ErrSkippedSamples) {
+		t.Errorf("Expected ErrSkippedSamples, got %v", err)
+	}
+}
+
+func TestMeasureWithMock(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+	m, err := pm.Measure(context.Background(), nil, func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Measure failed: %v", err)
+	}
+
+	if m.Duration < 10*time.Millisecond {
+		t.Errorf("Expected duration of at least 10ms, got %v", m.Duration)
+	}
+	if m.Covered != m.Duration {
+		t.Errorf("Expected the workload to be fully covered, got %v of %v", m.Covered, m.Duration)
+	}
+	// The workload lies within the first 5s sample, which reported 19 mJ.
+	if m.Energy <= 0 || m.Energy.Millijoules() >= 19 {
+		t.Errorf("Expected a prorated share of 19 mJ, got %v", m.Energy)
+	}
+}
+
+func TestMeasureWorkloadError(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	workloadErr := errors.New("workload failed")
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+	m, err := pm.Measure(context.Background(), nil, func(ctx context.Context) error {
+		return workloadErr
+	})
+	if !errors.Is(err, workloadErr) {
+		t.Errorf("Expected workload error, got %v", err)
+	}
+	if m == nil {
+		t.Error("Expected a measurement alongside the workload error")
+	}
+}
+
+func TestMeasureWithoutSamplers(t *testing.T) {
+	pm := NewWithRunner(&MockCommandRunner{})
+	ran := false
+	_, err := pm.Measure(context.Background(), &Config{SampleRate: time.Second}, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, ErrNoSamplers) {
+		t.Errorf("Expected ErrNoSamplers, got %v", err)
+	}
+	if ran {
+		t.Error("Expected the workload not to run")
+	}
+}
@@ -0,0 +1,151 @@
+package powermetrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// Phase is the energy and GPU residency attributed to a workload phase.
+type Phase struct {
+	Name string
+	// Start and End are offsets from the start of the stream.
+	Start time.Duration
+	End   time.Duration
+	// Covered is the part of the phase accounted for by samples.
+	Covered time.Duration
+	// Energy is the sum of the component energies.
+	Energy       units.Energy
+	Components   map[energy.Component]units.Energy
+	AveragePower units.Power
+	// GPU holds the GPU metrics over the phase, including the residency of
+	// every DVFM state.
+	GPU types.GPUMetrics
+}
+
+// Duration returns the length of the phase.
+func (p Phase) Duration() time.Duration {
+	return p.End - p.Start
+}
+
+// Phases attributes samples to the phases delimited by markers, such as the
+// samples of a stream and Stream.Markers. Every perSample consecutive
+// samples share an interval, and intervals are chained from the start of the
+// stream as in Measure. A phase lasts until the next marker or the end of
+// the last interval; time before the first marker is not attributed.
+// Samples straddling a phase boundary are prorated by the part of their
+// interval inside the phase. Phases are returned in marker order, and a
+// phase started more than once is reported once per marker.
+func Phases(samples []types.Sample, perSample int, markers []Marker) []Phase {
+	if perSample < 1 {
+		perSample = 1
+	}
+
+	var total time.Duration
+	for i, sample := range samples {
+		if i%perSample == 0 {
+			total += time.Duration(sample.GetElapsedNS())
+		}
+	}
+
+	sorted := append([]Marker(nil), markers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	var phases []Phase
+	for i, marker := range sorted {
+		if marker.Name == "" {
+			continue
+		}
+		end := total
+		if i+1 < len(sorted) {
+			end = sorted[i+1].Offset
+		}
+		phases = append(phases, Phase{
+			Name:       marker.Name,
+			Start:      marker.Offset,
+			End:        max(end, marker.Offset),
+			Components: make(map[energy.Component]units.Energy),
+		})
+	}
+
+	gpu := make([]types.GPUSeries, len(phases))
+	var intervalStart, intervalEnd time.Duration
+	for i, sample := range samples {
+		elapsed := time.Duration(sample.GetElapsedNS())
+		first := i%perSample == 0
+		if first {
+			intervalStart, intervalEnd = intervalEnd, intervalEnd+elapsed
+		}
+		if elapsed <= 0 {
+			continue
+		}
+
+		for j := range phases {
+			phase := &phases[j]
+			overlap := min(intervalEnd, phase.End) - max(intervalStart, phase.Start)
+			if overlap <= 0 {
+				continue
+			}
+			fraction := float64(overlap) / float64(elapsed)
+			for component, e := range energy.Readings(sample) {
+				share := e * units.Energy(fraction)
+				phase.Components[component] += share
+				phase.Energy += share
+			}
+			if first {
+				phase.Covered += overlap
+			}
+			if s, ok := sample.(*types.GPUPowerSample); ok {
+				gpu[j] = append(gpu[j], scaleGPUSample(s, overlap, fraction))
+			}
+		}
+	}
+
+	for j := range phases {
+		phase := &phases[j]
+		phase.AveragePower = phase.Energy.Over(phase.Covered)
+		phase.GPU = gpu[j].Metrics()
+		// The prorated energy is more precise than the rounded energy of
+		// the scaled samples.
+		if e, ok := phase.Components[energy.GPU]; ok {
+			phase.GPU.Energy = e
+			phase.GPU.AveragePower = e.Over(phase.GPU.Elapsed)
+		}
+	}
+	return phases
+}
+
+// scaleGPUSample returns a copy of s covering only elapsed, the given
+// fraction of its interval. Durations and energy are scaled; ratios are
+// unchanged.
+func scaleGPUSample(s *types.GPUPowerSample, elapsed time.Duration, fraction float64) *types.GPUPowerSample {
+	scale := func(ns int64) int64 { return int64(math.Round(float64(ns) * fraction)) }
+
+	scaled := *s
+	scaled.ElapsedNS = int64(elapsed)
+	scaled.GPU.IdleNS = scale(s.GPU.IdleNS)
+	if s.GPU.GPUEnergy != nil {
+		e := scale(*s.GPU.GPUEnergy)
+		scaled.GPU.GPUEnergy = &e
+	}
+	scaled.GPU.DVFMStates = make([]types.DVFMState, len(s.GPU.DVFMStates))
+	for i, state := range s.GPU.DVFMStates {
+		state.UsedNS = scale(state.UsedNS)
+		scaled.GPU.DVFMStates[i] = state
+	}
+	scaled.GPU.SWRequestedState = make([]types.SWReqState, len(s.GPU.SWRequestedState))
+	for i, state := range s.GPU.SWRequestedState {
+		state.UsedNS = scale(state.UsedNS)
+		scaled.GPU.SWRequestedState[i] = state
+	}
+	scaled.GPU.SWState = make([]types.SWState, len(s.GPU.SWState))
+	for i, state := range s.GPU.SWState {
+		state.UsedNS = scale(state.UsedNS)
+		scaled.GPU.SWState[i] = state
+	}
+	return &scaled
+}
@@ -0,0 +1,77 @@
+package powermetrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/energy"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestPhases(t *testing.T) {
+	mJ := func(v int64) *int64 { return &v }
+	interval := func(energy int64, dvfm ...types.DVFMState) []types.Sample {
+		base := types.BaseSample{ElapsedNS: int64(time.Second)}
+		return []types.Sample{
+			&types.GPUPowerSample{BaseSample: base, GPU: types.GPUInfo{GPUEnergy: mJ(energy), DVFMStates: dvfm}},
+			&types.TasksSample{BaseSample: base},
+		}
+	}
+	var samples []types.Sample
+	samples = append(samples, interval(1000, types.DVFMState{Freq: 1000, UsedNS: int64(500 * time.Millisecond), UsedRatio: 0.5})...)
+	samples = append(samples, interval(2000, types.DVFMState{Freq: 2000, UsedNS: int64(time.Second), UsedRatio: 1})...)
+	samples = append(samples, interval(4000)...)
+
+	markers := []Marker{
+		{Name: "compile", Offset: 1500 * time.Millisecond},
+		{Name: "download", Offset: 500 * time.Millisecond},
+		{Offset: 2500 * time.Millisecond},
+	}
+	phases := Phases(samples, 2, markers)
+	if len(phases) != 2 {
+		t.Fatalf("Expected 2 phases, got %d", len(phases))
+	}
+
+	tests := []struct {
+		name    string
+		energy  float64
+		pstates map[int64]time.Duration
+	}{
+		{"download", 1.5, map[int64]time.Duration{1000: 250 * time.Millisecond, 2000: 500 * time.Millisecond}},
+		{"compile", 3, map[int64]time.Duration{2000: 500 * time.Millisecond}},
+	}
+	for i, tt := range tests {
+		phase := phases[i]
+		if phase.Name != tt.name {
+			t.Errorf("Phase %d: Expected %s, got %s", i, tt.name, phase.Name)
+		}
+		if phase.Duration() != time.Second || phase.Covered != time.Second {
+			t.Errorf("%s: Expected 1s fully covered, got %v and %v", tt.name, phase.Duration(), phase.Covered)
+		}
+		if math.Abs(phase.Energy.Joules()-tt.energy) > 1e-9 || math.Abs(phase.AveragePower.Watts()-tt.energy) > 1e-9 {
+			t.Errorf("%s: Expected %v J at %v W, got %v at %v", tt.name, tt.energy, tt.energy, phase.Energy, phase.AveragePower)
+		}
+		if phase.Components[energy.GPU] != phase.Energy || phase.GPU.Energy != phase.Energy {
+			t.Errorf("%s: Expected the GPU to account for all energy, got %v and %v",
+				tt.name, phase.Components[energy.GPU], phase.GPU.Energy)
+		}
+		if phase.GPU.Elapsed != time.Second {
+			t.Errorf("%s: Expected 1s of GPU samples, got %v", tt.name, phase.GPU.Elapsed)
+		}
+		if len(phase.GPU.PStates) != len(tt.pstates) {
+			t.Errorf("%s: Expected %d P-states, got %+v", tt.name, len(tt.pstates), phase.GPU.PStates)
+		}
+		for _, state := range phase.GPU.PStates {
+			if want := tt.pstates[int64(state.Frequency.Megahertz())]; state.Used != want {
+				t.Errorf("%s: Expected %v at %v, got %v", tt.name, want, state.Frequency, state.Used)
+			}
+		}
+	}
+
+	// The last phase lasts until the end of the samples.
+	phases = Phases(samples, 2, []Marker{{Name: "test", Offset: 2 * time.Second}})
+	if len(phases) != 1 || phases[0].End != 3*time.Second || phases[0].Energy.Joules() != 4 {
+		t.Errorf("Expected a final phase of 4 J ending at 3s, got %+v", phases)
+	}
+}
@@ -0,0 +1,318 @@
+// Package bench benchmarks the energy used by external commands.
+//
+// A benchmark runs a command a number of times after some warmup runs,
+// waiting between runs so the system can cool down, and measures the energy,
+// average power and duration of every run with powermetrics.Measure.
+package bench
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/stats"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// DefaultConfidence is the confidence level of the reported intervals.
+const DefaultConfidence = 0.95
+
+var (
+	// ErrNoCommand is returned when Options.Command is empty.
+	ErrNoCommand = errors.New("no command to benchmark")
+	// ErrInvalidConfidence is returned when Options.Confidence is not
+	// between 0 and 1.
+	ErrInvalidConfidence = errors.New("confidence level must be between 0 and 1")
+)
+
+// Options configures a benchmark.
+type Options struct {
+	// Command is the program and its arguments.
+	Command []string
+	// Dir is the working directory of the command. Empty means the current
+	// directory.
+	Dir string
+	// Runs is the number of measured runs. Zero means 10.
+	Runs int
+	// Warmup is the number of unmeasured runs before the measured ones.
+	Warmup int
+	// Cooldown is the time to wait before every run.
+	Cooldown time.Duration
+	// Confidence is the level of the reported intervals, between 0 and 1
+	// exclusive. Zero means DefaultConfidence.
+	Confidence float64
+	// IgnoreFailure keeps benchmarking when the command exits with an error.
+	IgnoreFailure bool
+	// Stdout and Stderr receive the output of the command. Nil discards it.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Powermetrics is the instance used for sampling. Nil means
+	// powermetrics.New().
+	Powermetrics *powermetrics.Powermetrics
+	// Sampling is passed to Powermetrics.Measure. Nil uses its defaults.
+	Sampling *powermetrics.Config
+	// Progress, when set, is called after every measured run.
+	Progress func(run Run)
+}
+
+// Run is a single measured execution of the command.
+type Run struct {
+	Index        int
+	Energy       units.Energy
+	AveragePower units.Power
+	Duration     time.Duration
+	ExitCode     int
+}
+
+// Result is the outcome of a benchmark.
+type Result struct {
+	Command  []string
+	Warmup   int
+	Runs     []Run
+	Energy   stats.Estimate // joules
+	Power    stats.Estimate // watts
+	Duration stats.Estimate // seconds
+	// Confidence is the level of the estimates' intervals.
+	Confidence float64
+}
+
+// Benchmark runs the benchmark described by opts.
+func Benchmark(ctx context.Context, opts Options) (*Result, error) {
+	if len(opts.Command) == 0 {
+		return nil, ErrNoCommand
+	}
+	if opts.Runs <= 0 {
+		opts.Runs = 10
+	}
+	if opts.Confidence == 0 {
+		opts.Confidence = DefaultConfidence
+	}
+	if !isLevel(opts.Confidence) {
+		return nil, fmt.Errorf("%w, got %v", ErrInvalidConfidence, opts.Confidence)
+	}
+	pm := opts.Powermetrics
+	if pm == nil {
+		pm = powermetrics.New()
+	}
+
+	for i := 0; i < opts.Warmup; i++ {
+		if err := cooldown(ctx, opts.Cooldown); err != nil {
+			return nil, err
+		}
+		if _, err := execute(ctx, opts); err != nil && !opts.IgnoreFailure {
+			return nil, fmt.Errorf("warmup run %d: %w", i+1, err)
+		}
+	}
+
+	result := &Result{Command: opts.Command, Warmup: opts.Warmup}
+	for i := 0; i < opts.Runs; i++ {
+		if err := cooldown(ctx, opts.Cooldown); err != nil {
+			return nil, err
+		}
+
+		var exitCode int
+		m, err := pm.Measure(ctx, opts.Sampling, func(ctx context.Context) error {
+			var err error
+			exitCode, err = execute(ctx, opts)
+			return err
+		})
+		if m == nil {
+			return nil, fmt.Errorf("run %d: %w", i+1, err)
+		}
+		if err != nil && !opts.IgnoreFailure {
+			return nil, fmt.Errorf("run %d: %w", i+1, err)
+		}
+
+		run := Run{
+			Index:        i + 1,
+			Energy:       m.Energy,
+			AveragePower: m.AveragePower,
+			Duration:     m.Duration,
+			ExitCode:     exitCode,
+		}
+		result.Runs = append(result.Runs, run)
+		if opts.Progress != nil {
+			opts.Progress(run)
+		}
+	}
+
+	result.summarize(opts.Confidence)
+	return result, nil
+}
+
+// EnergyValues returns the energy of every run in joules.
+func (r *Result) EnergyValues() []float64 {
+	values := make([]float64, len(r.Runs))
+	for i, run := range r.Runs {
+		values[i] = run.Energy.Joules()
+	}
+	return values
+}
+
+func (r *Result) summarize(level float64) {
+	power := make([]float64, len(r.Runs))
+	duration := make([]float64, len(r.Runs))
+	for i, run := range r.Runs {
+		power[i] = run.AveragePower.Watts()
+		duration[i] = run.Duration.Seconds()
+	}
+	r.Confidence = level
+	r.Energy = stats.MeanConfidenceInterval(r.EnergyValues(), level)
+	r.Power = stats.MeanConfidenceInterval(power, level)
+	r.Duration = stats.MeanConfidenceInterval(duration, level)
+}
+
+func execute(ctx context.Context, opts Options) (int, error) {
+	cmd := exec.CommandContext(ctx, opts.Command[0], opts.Command[1:]...)
+	cmd.Dir = opts.Dir
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), fmt.Errorf("command failed: %w", err)
+	}
+	return 0, err
+}
+
+func cooldown(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type jsonEstimate struct {
+	Mean       float64 `json:"mean"`
+	StdDev     float64 `json:"stddev"`
+	Low        float64 `json:"ci_low"`
+	High       float64 `json:"ci_high"`
+	Confidence float64 `json:"confidence"`
+}
+
+func newJSONEstimate(e stats.Estimate) jsonEstimate {
+	return jsonEstimate{Mean: e.Mean, StdDev: e.StdDev, Low: e.Low, High: e.High, Confidence: e.Level}
+}
+
+type jsonRun struct {
+	Index           int     `json:"index"`
+	EnergyJoules    float64 `json:"energy_joules"`
+	PowerWatts      float64 `json:"power_watts"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	ExitCode        int     `json:"exit_code"`
+}
+
+type jsonResult struct {
+	Command         []string     `json:"command"`
+	Warmup          int          `json:"warmup"`
+	EnergyJoules    jsonEstimate `json:"energy_joules"`
+	PowerWatts      jsonEstimate `json:"power_watts"`
+	DurationSeconds jsonEstimate `json:"duration_seconds"`
+	Runs            []jsonRun    `json:"runs"`
+}
+
+// MarshalJSON encodes the result with snake_case keys and explicit units.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	out := jsonResult{
+		Command:         r.Command,
+		Warmup:          r.Warmup,
+		EnergyJoules:    newJSONEstimate(r.Energy),
+		PowerWatts:      newJSONEstimate(r.Power),
+		DurationSeconds: newJSONEstimate(r.Duration),
+		Runs:            make([]jsonRun, len(r.Runs)),
+	}
+	for i, run := range r.Runs {
+		out.Runs[i] = jsonRun{
+			Index:           run.Index,
+			EnergyJoules:    run.Energy.Joules(),
+			PowerWatts:      run.AveragePower.Watts(),
+			DurationSeconds: run.Duration.Seconds(),
+			ExitCode:        run.ExitCode,
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a result written by MarshalJSON.
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var in jsonResult
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	r.Command = in.Command
+	r.Warmup = in.Warmup
+	r.Runs = make([]Run, len(in.Runs))
+	for i, run := range in.Runs {
+		r.Runs[i] = Run{
+			Index:        run.Index,
+			Energy:       units.Energy(run.EnergyJoules),
+			AveragePower: units.Power(run.PowerWatts),
+			Duration:     time.Duration(run.DurationSeconds * float64(time.Second)),
+			ExitCode:     run.ExitCode,
+		}
+	}
+	level := in.EnergyJoules.Confidence
+	if level <= 0 {
+		level = DefaultConfidence
+	}
+	r.summarize(level)
+	return nil
+}
+
+// WriteJSON writes the result as indented JSON.
+func (r *Result) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// ReadJSON reads a result written by WriteJSON.
+func ReadJSON(rd io.Reader) (*Result, error) {
+	var r Result
+	if err := json.NewDecoder(rd).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// WriteCSV writes one row per measured run.
+func (r *Result) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"run", "energy_joules", "power_watts", "duration_seconds", "exit_code"}); err != nil {
+		return err
+	}
+	for _, run := range r.Runs {
+		record := []string{
+			strconv.Itoa(run.Index),
+			strconv.FormatFloat(run.Energy.Joules(), 'g', -1, 64),
+			strconv.FormatFloat(run.AveragePower.Watts(), 'g', -1, 64),
+			strconv.FormatFloat(run.Duration.Seconds(), 'g', -1, 64),
+			strconv.Itoa(run.ExitCode),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// isLevel reports whether v lies strictly between 0 and 1, as confidence
+// and significance levels must.
+func isLevel(v float64) bool {
+	return v > 0 && v < 1
+}
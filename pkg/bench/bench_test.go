@@ -0,0 +1,137 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics"
+)
+
+func mockPowermetrics(t *testing.T) *powermetrics.Powermetrics {
+	t.Helper()
+	xmlData, err := os.ReadFile("../../testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+	return powermetrics.NewWithRunner(&powermetrics.MockCommandRunner{Output: xmlData})
+}
+
+func TestBenchmark(t *testing.T) {
+	var progress int
+	result, err := Benchmark(context.Background(), Options{
+		Command:      []string{"sh", "-c", "sleep 0.01"},
+		Runs:         3,
+		Warmup:       1,
+		Powermetrics: mockPowermetrics(t),
+		Progress:     func(Run) { progress++ },
+	})
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+
+	if len(result.Runs) != 3 || progress != 3 {
+		t.Fatalf("Expected 3 runs and 3 progress calls, got %d and %d", len(result.Runs), progress)
+	}
+	if result.Energy.N != 3 || result.Energy.Mean <= 0 {
+		t.Errorf("Expected a positive mean energy over 3 runs, got %+v", result.Energy)
+	}
+	if result.Energy.Low > result.Energy.Mean || result.Energy.High < result.Energy.Mean {
+		t.Errorf("Expected the interval to contain the mean, got %+v", result.Energy)
+	}
+	if result.Duration.Mean < 0.01 {
+		t.Errorf("Expected a mean duration of at least 10ms, got %v", result.Duration.Mean)
+	}
+	if result.Confidence != DefaultConfidence {
+		t.Errorf("Expected the default confidence, got %v", result.Confidence)
+	}
+}
+
+func TestBenchmarkInvalidConfidence(t *testing.T) {
+	for _, confidence := range []float64{-0.5, 1, 95} {
+		ran := false
+		_, err := Benchmark(context.Background(), Options{
+			Command:      []string{"true"},
+			Runs:         1,
+			Confidence:   confidence,
+			Powermetrics: mockPowermetrics(t),
+			Progress:     func(Run) { ran = true },
+		})
+		if !errors.Is(err, ErrInvalidConfidence) {
+			t.Errorf("Expected ErrInvalidConfidence for %v, got %v", confidence, err)
+		}
+		if ran {
+			t.Errorf("Expected no runs for %v", confidence)
+		}
+	}
+}
+
+func TestBenchmarkFailure(t *testing.T) {
+	_, err := Benchmark(context.Background(), Options{
+		Command:      []string{"sh", "-c", "exit 3"},
+		Runs:         1,
+		Powermetrics: mockPowermetrics(t),
+	})
+	if err == nil {
+		t.Fatal("Expected a failing command to abort the benchmark")
+	}
+
+	result, err := Benchmark(context.Background(), Options{
+		Command:       []string{"sh", "-c", "exit 3"},
+		Runs:          1,
+		IgnoreFailure: true,
+		Powermetrics:  mockPowermetrics(t),
+	})
+	if err != nil {
+		t.Fatalf("Expected failures to be ignored, got %v", err)
+	}
+	if result.Runs[0].ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.Runs[0].ExitCode)
+	}
+
+	if _, err := Benchmark(context.Background(), Options{}); !errors.Is(err, ErrNoCommand) {
+		t.Errorf("Expected ErrNoCommand, got %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	result, err := Benchmark(context.Background(), Options{
+		Command:      []string{"true"},
+		Runs:         2,
+		Powermetrics: mockPowermetrics(t),
+	})
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"energy_joules"`) {
+		t.Errorf("Expected JSON to contain energy_joules, got %s", buf.String())
+	}
+	decoded, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if len(decoded.Runs) != 2 || decoded.Energy.Mean != result.Energy.Mean {
+		t.Errorf("Expected JSON round trip to preserve runs, got %+v", decoded)
+	}
+
+	buf.Reset()
+	if err := result.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if len(records) != 3 || records[0][1] != "energy_joules" {
+		t.Errorf("Expected a header and 2 rows, got %v", records)
+	}
+}
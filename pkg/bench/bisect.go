@@ -0,0 +1,289 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DefaultBisectRetries is the number of times Bisect benchmarks a commit
+// again when its comparison is inconclusive.
+const DefaultBisectRetries = 2
+
+// Bisection errors
+var (
+	ErrNoRegression = errors.New("bad revision did not regress against the good revision")
+	ErrInconclusive = errors.New("comparison stayed inconclusive")
+)
+
+// BisectOptions configures Bisect.
+type BisectOptions struct {
+	// Repo is the git repository. Empty means the current directory.
+	Repo string
+	// Good and Bad are the revisions known not to regress and to regress.
+	// Good must be an ancestor of Bad. Empty Bad means HEAD.
+	Good string
+	Bad  string
+	// Build, when set, is run with sh -c in the checked out commit before
+	// it is benchmarked. It is not measured.
+	Build string
+	// Benchmark describes how every commit is benchmarked. Its Dir is set
+	// to the checked out commit, so Command can refer to the build output
+	// with a relative path.
+	Benchmark Options
+	// Compare controls how a commit is compared against the good revision.
+	Compare CompareOptions
+	// Retries is the number of times an inconclusive commit is benchmarked
+	// again, adding its runs to the previous ones. Zero means
+	// DefaultBisectRetries; use a negative value to disable retries.
+	Retries int
+	// Progress, when set, is called after every commit is decided.
+	Progress func(step BisectStep)
+}
+
+// BisectStep is the decision taken for one commit.
+type BisectStep struct {
+	Commit     string
+	Subject    string
+	Result     *Result
+	Comparison *Comparison
+	// Attempts is the number of benchmarks the decision took.
+	Attempts int
+	// Bad reports whether the commit regressed against the good revision.
+	Bad bool
+}
+
+// Bisection is the outcome of Bisect.
+type Bisection struct {
+	Good string
+	Bad  string
+	// Culprit is the first commit that regressed and Subject its subject.
+	Culprit string
+	Subject string
+	// Baseline is the benchmark of the good revision.
+	Baseline *Result
+	Steps    []BisectStep
+}
+
+// benchmark is replaced in tests.
+var benchmark = Benchmark
+
+// Bisect finds the first commit between opts.Good and opts.Bad whose
+// energy regressed, by binary search over the first-parent history.
+//
+// The good revision is benchmarked once as the baseline, and every other
+// commit is compared against it with Compare: a regression marks the commit
+// bad, and an unchanged or improved energy marks it good. Inconclusive
+// commits are benchmarked again up to opts.Retries times before Bisect
+// gives up with ErrInconclusive. Commits are checked out in a temporary
+// worktree, so the working tree of the repository is left untouched.
+func Bisect(ctx context.Context, opts BisectOptions) (*Bisection, error) {
+	if len(opts.Benchmark.Command) == 0 {
+		return nil, ErrNoCommand
+	}
+	if c := opts.Benchmark.Confidence; c != 0 && !isLevel(c) {
+		return nil, fmt.Errorf("%w, got %v", ErrInvalidConfidence, c)
+	}
+	if a := opts.Compare.Alpha; a != 0 && !isLevel(a) {
+		return nil, fmt.Errorf("%w, got %v", ErrInvalidAlpha, a)
+	}
+	if opts.Bad == "" {
+		opts.Bad = "HEAD"
+	}
+	if opts.Retries == 0 {
+		opts.Retries = DefaultBisectRetries
+	}
+	repo := gitRepo{dir: opts.Repo}
+
+	good, err := repo.resolve(ctx, opts.Good)
+	if err != nil {
+		return nil, err
+	}
+	bad, err := repo.resolve(ctx, opts.Bad)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := repo.git(ctx, "merge-base", "--is-ancestor", good, bad); err != nil {
+		return nil, fmt.Errorf("%s is not an ancestor of %s: %w", opts.Good, opts.Bad, err)
+	}
+	commits, err := repo.lines(ctx, "rev-list", "--first-parent", "--ancestry-path", "--reverse", good+".."+bad)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commits between %s and %s", opts.Good, opts.Bad)
+	}
+
+	worktree, err := os.MkdirTemp("", "pmbisect-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(worktree) }()
+	if _, err := repo.git(ctx, "worktree", "add", "--detach", worktree, good); err != nil {
+		return nil, err
+	}
+	defer func() { _, _ = repo.git(context.Background(), "worktree", "remove", "--force", worktree) }()
+
+	b := &bisector{opts: opts, repo: repo, worktree: worktree}
+	bisection := &Bisection{Good: good, Bad: bad}
+	if bisection.Baseline, err = b.benchmark(ctx, good); err != nil {
+		return nil, err
+	}
+	b.baseline = bisection.Baseline
+
+	decide := func(commit string) (bool, error) {
+		step, err := b.decide(ctx, commit)
+		if err != nil {
+			return false, err
+		}
+		bisection.Steps = append(bisection.Steps, step)
+		if opts.Progress != nil {
+			opts.Progress(step)
+		}
+		return step.Bad, nil
+	}
+
+	// commits[lo] is good, or lo is -1 for the good revision itself, and
+	// commits[hi] is bad.
+	lo, hi := -1, len(commits)-1
+	if isBad, err := decide(commits[hi]); err != nil {
+		return bisection, err
+	} else if !isBad {
+		return bisection, ErrNoRegression
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		isBad, err := decide(commits[mid])
+		if err != nil {
+			return bisection, err
+		}
+		if isBad {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	bisection.Culprit = commits[hi]
+	bisection.Subject, _ = repo.subject(ctx, bisection.Culprit)
+	return bisection, nil
+}
+
+type bisector struct {
+	opts     BisectOptions
+	repo     gitRepo
+	worktree string
+	baseline *Result
+}
+
+// decide benchmarks commit until its comparison against the baseline is
+// conclusive.
+func (b *bisector) decide(ctx context.Context, commit string) (BisectStep, error) {
+	step := BisectStep{Commit: commit}
+	step.Subject, _ = b.repo.subject(ctx, commit)
+	for {
+		result, err := b.benchmark(ctx, commit)
+		if err != nil {
+			return step, err
+		}
+		step.Attempts++
+		if step.Result == nil {
+			step.Result = result
+		} else {
+			step.Result.merge(result, b.opts.Benchmark.Confidence)
+		}
+
+		step.Comparison, err = Compare(b.baseline, step.Result, b.opts.Compare)
+		if err != nil {
+			return step, fmt.Errorf("commit %s: %w", commit, err)
+		}
+		switch step.Comparison.Verdict {
+		case Regressed:
+			step.Bad = true
+			return step, nil
+		case Unchanged, Improved:
+			return step, nil
+		}
+		if step.Attempts > b.opts.Retries {
+			return step, fmt.Errorf("commit %s after %d attempts: %w", commit, step.Attempts, ErrInconclusive)
+		}
+	}
+}
+
+// benchmark checks out commit in the worktree, builds it and benchmarks it.
+func (b *bisector) benchmark(ctx context.Context, commit string) (*Result, error) {
+	if _, err := (gitRepo{dir: b.worktree}).git(ctx, "checkout", "--quiet", "--detach", commit); err != nil {
+		return nil, err
+	}
+	if b.opts.Build != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", b.opts.Build)
+		cmd.Dir = b.worktree
+		cmd.Stdout = b.opts.Benchmark.Stdout
+		cmd.Stderr = b.opts.Benchmark.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("build of %s failed: %w", commit, err)
+		}
+	}
+
+	opts := b.opts.Benchmark
+	opts.Dir = b.worktree
+	result, err := benchmark(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("benchmark of %s failed: %w", commit, err)
+	}
+	return result, nil
+}
+
+// merge appends the runs of other and recomputes the estimates.
+func (r *Result) merge(other *Result, level float64) {
+	if level <= 0 {
+		level = DefaultConfidence
+	}
+	for _, run := range other.Runs {
+		run.Index = len(r.Runs) + 1
+		r.Runs = append(r.Runs, run)
+	}
+	r.summarize(level)
+}
+
+// gitRepo runs git commands in a repository.
+type gitRepo struct {
+	dir string
+}
+
+func (g gitRepo) git(ctx context.Context, args ...string) (string, error) {
+	if g.dir != "" {
+		args = append([]string{"-C", g.dir}, args...)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (g gitRepo) lines(ctx context.Context, args ...string) ([]string, error) {
+	output, err := g.git(ctx, args...)
+	if err != nil || output == "" {
+		return nil, err
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+func (g gitRepo) resolve(ctx context.Context, rev string) (string, error) {
+	if rev == "" {
+		return "", errors.New("empty git revision")
+	}
+	return g.git(ctx, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+}
+
+func (g gitRepo) subject(ctx context.Context, commit string) (string, error) {
+	return g.git(ctx, "log", "-1", "--format=%s", commit)
+}
@@ -0,0 +1,209 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// gitHistory creates a repository with one commit per entry of energies.
+// Every commit writes its energy in joules to the file "energy", optionally
+// followed by a noise amplitude for the first benchmark of the commit.
+func gitHistory(t *testing.T, energies []string) (string, []string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	git("init", "--quiet")
+	var commits []string
+	for i, e := range energies {
+		if err := os.WriteFile(filepath.Join(dir, "energy"), []byte(e), 0o644); err != nil {
+			t.Fatalf("Failed to write energy: %v", err)
+		}
+		git("add", "energy")
+		git("commit", "--quiet", "--allow-empty", "-m", "commit "+strconv.Itoa(i))
+		commits = append(commits, git("rev-parse", "HEAD"))
+	}
+	return dir, commits
+}
+
+// fakeBenchmark replaces the benchmark with one that reads the energy of
+// the checked out commit, and returns the number of calls per energy file.
+func fakeBenchmark(t *testing.T) map[string]int {
+	t.Helper()
+	calls := make(map[string]int)
+	benchmark = func(_ context.Context, opts Options) (*Result, error) {
+		data, err := os.ReadFile(filepath.Join(opts.Dir, "energy"))
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(string(data))
+		mean, _ := strconv.ParseFloat(fields[0], 64)
+		var noise float64
+		if len(fields) > 1 && calls[string(data)] == 0 {
+			noise, _ = strconv.ParseFloat(fields[1], 64)
+		}
+		calls[string(data)]++
+
+		result := &Result{Command: opts.Command}
+		for i, d := range []float64{-0.01, 0.01, -0.02, 0.02, 0} {
+			result.Runs = append(result.Runs, Run{
+				Index:  i + 1,
+				Energy: units.Energy(mean + d + float64(i%2*2-1)*noise),
+			})
+		}
+		result.summarize(DefaultConfidence)
+		return result, nil
+	}
+	t.Cleanup(func() { benchmark = Benchmark })
+	return calls
+}
+
+func TestBisect(t *testing.T) {
+	calls := fakeBenchmark(t)
+	repo, commits := gitHistory(t, []string{"10", "10.05", "9.98", "10", "12", "12.1", "12", "11.9"})
+
+	var progress int
+	bisection, err := Bisect(context.Background(), BisectOptions{
+		Repo:      repo,
+		Good:      commits[0],
+		Bad:       commits[len(commits)-1],
+		Build:     "test -f energy",
+		Benchmark: Options{Command: []string{"true"}},
+		Progress:  func(BisectStep) { progress++ },
+	})
+	if err != nil {
+		t.Fatalf("Bisect failed: %v", err)
+	}
+
+	if bisection.Culprit != commits[4] {
+		t.Errorf("Expected culprit %s, got %s", commits[4], bisection.Culprit)
+	}
+	if bisection.Subject != "commit 4" {
+		t.Errorf("Expected subject 'commit 4', got %q", bisection.Subject)
+	}
+	if bisection.Baseline == nil || bisection.Baseline.Energy.Mean != 10 {
+		t.Errorf("Expected a baseline of 10 J, got %+v", bisection.Baseline)
+	}
+	// The bad revision and log2(7) commits.
+	if len(bisection.Steps) != 4 || progress != 4 {
+		t.Errorf("Expected 4 steps and progress calls, got %d and %d", len(bisection.Steps), progress)
+	}
+	for _, step := range bisection.Steps {
+		if step.Attempts != 1 {
+			t.Errorf("Expected commit %s to be decided in one attempt, got %d", step.Commit, step.Attempts)
+		}
+	}
+	if calls["10"] != 2 {
+		t.Errorf("Expected the good energy file to be benchmarked twice, got %d", calls["10"])
+	}
+
+	out, err := exec.Command("git", "-C", repo, "worktree", "list").Output()
+	if err != nil {
+		t.Fatalf("git worktree list failed: %v", err)
+	}
+	if lines := strings.Count(strings.TrimSpace(string(out)), "\n"); lines != 0 {
+		t.Errorf("Expected the temporary worktree to be removed, got:\n%s", out)
+	}
+}
+
+func TestBisectRetriesInconclusive(t *testing.T) {
+	fakeBenchmark(t)
+	// Commit 2 is too noisy to decide at first and regressed.
+	repo, commits := gitHistory(t, []string{"10", "10", "12 5", "12"})
+
+	bisection, err := Bisect(context.Background(), BisectOptions{
+		Repo:      repo,
+		Good:      commits[0],
+		Bad:       commits[3],
+		Benchmark: Options{Command: []string{"true"}},
+	})
+	if err != nil {
+		t.Fatalf("Bisect failed: %v", err)
+	}
+	if bisection.Culprit != commits[2] {
+		t.Errorf("Expected culprit %s, got %s", commits[2], bisection.Culprit)
+	}
+
+	var retried bool
+	for _, step := range bisection.Steps {
+		if step.Commit == commits[2] {
+			retried = step.Attempts > 1 && len(step.Result.Runs) == 5*step.Attempts
+		}
+	}
+	if !retried {
+		t.Errorf("Expected the noisy commit to be benchmarked again with its runs merged, got %+v", bisection.Steps)
+	}
+
+	fakeBenchmark(t)
+	_, err = Bisect(context.Background(), BisectOptions{
+		Repo:      repo,
+		Good:      commits[0],
+		Bad:       commits[3],
+		Benchmark: Options{Command: []string{"true"}},
+		Retries:   -1,
+	})
+	if !errors.Is(err, ErrInconclusive) {
+		t.Errorf("Expected ErrInconclusive without retries, got %v", err)
+	}
+}
+
+func TestBisectErrors(t *testing.T) {
+	fakeBenchmark(t)
+	repo, commits := gitHistory(t, []string{"10", "10.02", "9.99"})
+
+	_, err := Bisect(context.Background(), BisectOptions{
+		Repo:      repo,
+		Good:      commits[0],
+		Bad:       commits[2],
+		Benchmark: Options{Command: []string{"true"}},
+	})
+	if !errors.Is(err, ErrNoRegression) {
+		t.Errorf("Expected ErrNoRegression, got %v", err)
+	}
+
+	_, err = Bisect(context.Background(), BisectOptions{
+		Repo:      repo,
+		Good:      commits[2],
+		Bad:       commits[0],
+		Benchmark: Options{Command: []string{"true"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "not an ancestor") {
+		t.Errorf("Expected an ancestry error, got %v", err)
+	}
+
+	if _, err := Bisect(context.Background(), BisectOptions{Repo: repo, Good: commits[0]}); !errors.Is(err, ErrNoCommand) {
+		t.Errorf("Expected ErrNoCommand, got %v", err)
+	}
+
+	_, err = Bisect(context.Background(), BisectOptions{
+		Repo:      repo,
+		Good:      commits[0],
+		Benchmark: Options{Command: []string{"true"}},
+		Compare:   CompareOptions{Alpha: 1},
+	})
+	if !errors.Is(err, ErrInvalidAlpha) {
+		t.Errorf("Expected ErrInvalidAlpha, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/stats"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+var (
+	// ErrTooFewRuns is returned by Compare when either result has fewer
+	// than two runs.
+	ErrTooFewRuns = errors.New("at least two runs per result are needed for a comparison")
+	// ErrInvalidAlpha is returned by Compare when CompareOptions.Alpha is
+	// not between 0 and 1.
+	ErrInvalidAlpha = errors.New("significance level must be between 0 and 1")
+)
+
+// Default comparison settings.
+const (
+	DefaultThreshold = 0.05
+	DefaultAlpha     = 0.05
+)
+
+// Verdict is the outcome of a comparison.
+type Verdict string
+
+const (
+	// Regressed means energy increased by more than the threshold, with
+	// significance.
+	Regressed Verdict = "regressed"
+	// Improved means energy decreased by more than the threshold, with
+	// significance.
+	Improved Verdict = "improved"
+	// Unchanged means the confidence interval of the change lies within the
+	// threshold.
+	Unchanged Verdict = "unchanged"
+	// Inconclusive means the runs are too noisy to decide; measure more.
+	Inconclusive Verdict = "inconclusive"
+)
+
+// CompareOptions controls Compare.
+type CompareOptions struct {
+	// Threshold is the relative change in mean energy that matters, e.g.
+	// 0.05 for 5%. Zero means DefaultThreshold.
+	Threshold float64
+	// Alpha is the significance level of the test, between 0 and 1
+	// exclusive. Zero means DefaultAlpha.
+	Alpha float64
+}
+
+// Comparison is the result of comparing a candidate run against a baseline
+// with Welch's t-test on the per-run energy.
+type Comparison struct {
+	Baseline  stats.Estimate // joules
+	Candidate stats.Estimate // joules
+	// Change is the relative change of the mean energy, and ChangeLow and
+	// ChangeHigh its confidence interval at 1-Alpha.
+	Change     float64
+	ChangeLow  float64
+	ChangeHigh float64
+	Test       stats.TTest
+	Threshold  float64
+	Alpha      float64
+	Verdict    Verdict
+}
+
+// Compare compares the per-run energy of candidate against baseline.
+func Compare(baseline, candidate *Result, opts CompareOptions) (*Comparison, error) {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultThreshold
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+	if !isLevel(opts.Alpha) {
+		return nil, fmt.Errorf("%w, got %v", ErrInvalidAlpha, opts.Alpha)
+	}
+
+	base, cand := baseline.EnergyValues(), candidate.EnergyValues()
+	test, ok := stats.WelchTTest(base, cand)
+	if !ok {
+		return nil, ErrTooFewRuns
+	}
+
+	level := 1 - opts.Alpha
+	c := &Comparison{
+		Baseline:  stats.MeanConfidenceInterval(base, level),
+		Candidate: stats.MeanConfidenceInterval(cand, level),
+		Test:      test,
+		Threshold: opts.Threshold,
+		Alpha:     opts.Alpha,
+	}
+	if c.Baseline.Mean == 0 {
+		return nil, fmt.Errorf("baseline mean energy is zero")
+	}
+
+	lo, hi := test.ConfidenceInterval(level)
+	c.Change = test.Difference / c.Baseline.Mean
+	c.ChangeLow = lo / c.Baseline.Mean
+	c.ChangeHigh = hi / c.Baseline.Mean
+
+	significant := test.PValue < opts.Alpha
+	switch {
+	case significant && c.Change > opts.Threshold:
+		c.Verdict = Regressed
+	case significant && c.Change < -opts.Threshold:
+		c.Verdict = Improved
+	case c.ChangeLow >= -opts.Threshold && c.ChangeHigh <= opts.Threshold:
+		c.Verdict = Unchanged
+	default:
+		c.Verdict = Inconclusive
+	}
+	return c, nil
+}
+
+// Regressed reports whether the candidate regressed.
+func (c *Comparison) Regressed() bool {
+	return c.Verdict == Regressed
+}
+
+// WriteText writes a human-readable report.
+func (c *Comparison) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w,
+		"Baseline:  %v ± %v (n=%d)\n"+
+			"Candidate: %v ± %v (n=%d)\n"+
+			"Change:    %+.2f%% [%+.2f%% … %+.2f%%] (threshold ±%.2f%%)\n"+
+			"Welch:     t=%.3f df=%.1f p=%.4f (alpha %.3f)\n"+
+			"Verdict:   %s\n",
+		units.Energy(c.Baseline.Mean), units.Energy(c.Baseline.StdDev), c.Baseline.N,
+		units.Energy(c.Candidate.Mean), units.Energy(c.Candidate.StdDev), c.Candidate.N,
+		c.Change*100, c.ChangeLow*100, c.ChangeHigh*100, c.Threshold*100,
+		c.Test.T, c.Test.DF, c.Test.PValue, c.Alpha,
+		c.Verdict)
+	return err
+}
+
+type comparisonJSON struct {
+	Verdict         Verdict      `json:"verdict"`
+	Regressed       bool         `json:"regressed"`
+	BaselineJoules  jsonEstimate `json:"baseline_energy_joules"`
+	CandidateJoules jsonEstimate `json:"candidate_energy_joules"`
+	Change          float64      `json:"change_ratio"`
+	ChangeLow       float64      `json:"change_ratio_ci_low"`
+	ChangeHigh      float64      `json:"change_ratio_ci_high"`
+	Threshold       float64      `json:"threshold_ratio"`
+	Alpha           float64      `json:"alpha"`
+	T               *float64     `json:"t"`
+	DF              float64      `json:"df"`
+	PValue          float64      `json:"p_value"`
+}
+
+// MarshalJSON encodes the comparison with snake_case keys.
+func (c *Comparison) MarshalJSON() ([]byte, error) {
+	return json.Marshal(comparisonJSON{
+		Verdict:         c.Verdict,
+		Regressed:       c.Regressed(),
+		BaselineJoules:  newJSONEstimate(c.Baseline),
+		CandidateJoules: newJSONEstimate(c.Candidate),
+		Change:          c.Change,
+		ChangeLow:       c.ChangeLow,
+		ChangeHigh:      c.ChangeHigh,
+		Threshold:       c.Threshold,
+		Alpha:           c.Alpha,
+		T:               finite(c.Test.T),
+		DF:              c.Test.DF,
+		PValue:          c.Test.PValue,
+	})
+}
+
+// finite returns nil for values JSON cannot represent, such as the infinite
+// t statistic of two constant samples.
+func finite(v float64) *float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+// WriteJSON writes the comparison as indented JSON.
+func (c *Comparison) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
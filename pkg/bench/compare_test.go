@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+func resultWithEnergy(joules ...float64) *Result {
+	r := &Result{}
+	for i, j := range joules {
+		r.Runs = append(r.Runs, Run{Index: i + 1, Energy: units.Energy(j)})
+	}
+	r.summarize(DefaultConfidence)
+	return r
+}
+
+func TestCompare(t *testing.T) {
+	baseline := resultWithEnergy(10.0, 10.2, 9.9, 10.1, 9.8, 10.0)
+
+	tests := []struct {
+		name      string
+		candidate *Result
+		expected  Verdict
+	}{
+		{"regressed", resultWithEnergy(11.5, 11.6, 11.4, 11.7, 11.5, 11.3), Regressed},
+		{"improved", resultWithEnergy(8.0, 8.1, 7.9, 8.2, 8.0, 7.8), Improved},
+		{"unchanged", resultWithEnergy(10.1, 10.0, 9.9, 10.2, 10.0, 9.9), Unchanged},
+		{"inconclusive", resultWithEnergy(8, 13, 9, 12, 10, 14), Inconclusive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Compare(baseline, tt.candidate, CompareOptions{Threshold: 0.05})
+			if err != nil {
+				t.Fatalf("Compare failed: %v", err)
+			}
+			if c.Verdict != tt.expected {
+				t.Errorf("Expected %s, got %s (change %+.2f%%, p=%.4f)", tt.expected, c.Verdict, c.Change*100, c.Test.PValue)
+			}
+		})
+	}
+
+	if _, err := Compare(baseline, resultWithEnergy(10), CompareOptions{}); !errors.Is(err, ErrTooFewRuns) {
+		t.Errorf("Expected ErrTooFewRuns, got %v", err)
+	}
+	for _, alpha := range []float64{-0.05, 1, 5} {
+		if _, err := Compare(baseline, baseline, CompareOptions{Alpha: alpha}); !errors.Is(err, ErrInvalidAlpha) {
+			t.Errorf("Expected ErrInvalidAlpha for %v, got %v", alpha, err)
+		}
+	}
+}
+
+func TestComparisonReports(t *testing.T) {
+	c, err := Compare(resultWithEnergy(10, 10), resultWithEnergy(12, 12), CompareOptions{})
+	if err != nil {
+		t.Fatalf("Compare failed: %v", err)
+	}
+	if !c.Regressed() {
+		t.Errorf("Expected a regression between constant samples, got %s", c.Verdict)
+	}
+
+	var text bytes.Buffer
+	if err := c.WriteText(&text); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	if !strings.Contains(text.String(), "Verdict:   regressed") {
+		t.Errorf("Unexpected report:\n%s", text.String())
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON: %v", err)
+	}
+	if decoded["verdict"] != "regressed" || decoded["regressed"] != true {
+		t.Errorf("Unexpected JSON: %s", buf.String())
+	}
+}
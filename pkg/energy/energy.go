@@ -0,0 +1,207 @@
+// Package energy integrates per-interval energy readings over a sampling
+// session.
+//
+// Totals are the sum of the energy powermetrics reports for each interval,
+// never an average multiplied by wall time, so they stay correct when the
+// sample rate varies. Time that no sample accounts for is reported
+// separately instead of being silently extrapolated.
+package energy
+
+import (
+	"sort"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// Component identifies a power domain that reports energy.
+type Component string
+
+const (
+	GPU Component = "gpu"
+	CPU Component = "cpu"
+	ANE Component = "ane"
+)
+
+// DefaultGapTolerance is the default slack allowed between the timestamps of
+// consecutive samples and their elapsed intervals. powermetrics timestamps
+// have a resolution of one second.
+const DefaultGapTolerance = time.Second
+
+// resolution is the quantization step of each component's energy readings.
+var resolution = map[Component]units.Energy{
+	GPU: units.Millijoule,
+	CPU: units.Millijoule,
+	ANE: units.Millijoule,
+}
+
+// Resolution returns the quantization step of a component's energy
+// readings.
+func Resolution(c Component) units.Energy {
+	if r, ok := resolution[c]; ok {
+		return r
+	}
+	return units.Millijoule
+}
+
+// Options controls integration.
+type Options struct {
+	// GapTolerance is how much longer than its elapsed interval the time
+	// between two sample timestamps may be before it is reported as a gap.
+	// Zero means DefaultGapTolerance.
+	GapTolerance time.Duration
+}
+
+// Gap is a stretch of wall time that no sample accounts for, for example
+// while the machine slept or powermetrics was restarted.
+type Gap struct {
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+}
+
+// Integral is the energy consumed by a single component.
+type Integral struct {
+	Component Component
+	// Total is the sum of the interval energies.
+	Total units.Energy
+	// ErrorBound is the worst-case absolute error of Total caused by the
+	// quantization of the readings. The true energy over the covered time
+	// lies within Total ± ErrorBound.
+	ErrorBound units.Energy
+	// Covered is the time accounted for by samples with a reading.
+	Covered time.Duration
+	// Samples is the number of samples with a reading for the component.
+	Samples int
+	// Missing is the number of samples without a reading for the component.
+	Missing int
+}
+
+// Bounds returns the lowest and highest energy consistent with the readings.
+func (i Integral) Bounds() (lo, hi units.Energy) {
+	lo = i.Total - i.ErrorBound
+	if lo < 0 {
+		lo = 0
+	}
+	return lo, i.Total + i.ErrorBound
+}
+
+// AveragePower returns Total over Covered.
+func (i Integral) AveragePower() units.Power {
+	return i.Total.Over(i.Covered)
+}
+
+// Report is the result of integrating a sampling session.
+type Report struct {
+	// Start and End delimit the session, from the beginning of the first
+	// sample interval to the timestamp of the last sample.
+	Start time.Time
+	End   time.Time
+	// Covered is the sum of the sample intervals.
+	Covered time.Duration
+	// Uncovered is the part of the session no sample accounts for.
+	Uncovered time.Duration
+	// Gaps lists the detected discontinuities in timestamp order.
+	Gaps []Gap
+	// Components holds the integral of every component seen in the session.
+	Components map[Component]Integral
+}
+
+// Span returns the wall time between Start and End.
+func (r Report) Span() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Integrate sums the energy of every component over samples, which may be in
+// any order, and detects gaps between them.
+func Integrate(samples []types.Sample, opts Options) Report {
+	tolerance := opts.GapTolerance
+	if tolerance <= 0 {
+		tolerance = DefaultGapTolerance
+	}
+
+	ordered := make([]types.Sample, 0, len(samples))
+	for _, s := range samples {
+		if s != nil {
+			ordered = append(ordered, s)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].GetTimestamp().Before(ordered[j].GetTimestamp())
+	})
+
+	report := Report{Components: make(map[Component]Integral)}
+	if len(ordered) == 0 {
+		return report
+	}
+
+	first := ordered[0]
+	report.Start = first.GetTimestamp().Add(-time.Duration(first.GetElapsedNS()))
+	report.End = ordered[len(ordered)-1].GetTimestamp()
+
+	seen := make(map[Component]bool)
+	for _, s := range ordered {
+		for c := range Readings(s) {
+			seen[c] = true
+		}
+	}
+
+	for i, s := range ordered {
+		elapsed := time.Duration(s.GetElapsedNS())
+		report.Covered += elapsed
+
+		if i > 0 {
+			prev := ordered[i-1].GetTimestamp()
+			start := s.GetTimestamp().Add(-elapsed)
+			if gap := start.Sub(prev); gap > tolerance {
+				report.Gaps = append(report.Gaps, Gap{Start: prev, End: start, Duration: gap})
+			}
+		}
+
+		values := Readings(s)
+		for c := range seen {
+			integral := report.Components[c]
+			integral.Component = c
+			if e, ok := values[c]; ok {
+				integral.Total += e
+				integral.ErrorBound += Resolution(c) / 2
+				integral.Covered += elapsed
+				integral.Samples++
+			} else {
+				integral.Missing++
+			}
+			report.Components[c] = integral
+		}
+	}
+
+	if uncovered := report.Span() - report.Covered; uncovered > 0 {
+		report.Uncovered = uncovered
+	}
+	return report
+}
+
+// IntegrateGPU is a convenience wrapper around Integrate for GPU series such
+// as powermetrics.Result.Samples.
+func IntegrateGPU(series types.GPUSeries, opts Options) Report {
+	samples := make([]types.Sample, 0, len(series))
+	for _, s := range series {
+		if s != nil {
+			samples = append(samples, s)
+		}
+	}
+	return Integrate(samples, opts)
+}
+
+// Readings returns the energy readings carried by a sample. Only the GPU
+// sampler reports energy today; CPU and ANE readings will appear here once
+// their samplers are parsed.
+func Readings(sample types.Sample) map[Component]units.Energy {
+	values := make(map[Component]units.Energy)
+	if s, ok := sample.(*types.GPUPowerSample); ok {
+		if e, ok := s.GPU.Energy(); ok {
+			values[GPU] = e
+		}
+	}
+	return values
+}
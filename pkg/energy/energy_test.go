@@ -0,0 +1,87 @@
+package energy
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func gpuSample(ts time.Time, elapsed time.Duration, mJ int64) *types.GPUPowerSample {
+	return &types.GPUPowerSample{
+		BaseSample: types.BaseSample{Timestamp: ts, ElapsedNS: int64(elapsed)},
+		GPU:        types.GPUInfo{GPUEnergy: &mJ},
+	}
+}
+
+func TestIntegrateGPU(t *testing.T) {
+	base := time.Date(2025, 7, 6, 5, 15, 15, 0, time.UTC)
+	series := types.GPUSeries{
+		gpuSample(base.Add(5*time.Second), 5*time.Second, 100),
+		// Out of order on purpose.
+		gpuSample(base.Add(40*time.Second), 5*time.Second, 300),
+		gpuSample(base.Add(10*time.Second), 5*time.Second, 200),
+		{BaseSample: types.BaseSample{Timestamp: base.Add(45 * time.Second), ElapsedNS: int64(5 * time.Second)}},
+	}
+
+	report := IntegrateGPU(series, Options{})
+
+	if !report.Start.Equal(base) || !report.End.Equal(base.Add(45*time.Second)) {
+		t.Errorf("Unexpected session bounds: %v - %v", report.Start, report.End)
+	}
+	if report.Covered != 20*time.Second {
+		t.Errorf("Expected 20s covered, got %v", report.Covered)
+	}
+	if report.Uncovered != 25*time.Second {
+		t.Errorf("Expected 25s uncovered, got %v", report.Uncovered)
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0].Duration != 25*time.Second {
+		t.Fatalf("Expected a single 25s gap, got %+v", report.Gaps)
+	}
+
+	gpu, ok := report.Components[GPU]
+	if !ok {
+		t.Fatal("Expected a GPU integral")
+	}
+	if math.Abs(gpu.Total.Millijoules()-600) > 1e-9 {
+		t.Errorf("Expected 600 mJ, got %v", gpu.Total)
+	}
+	if gpu.Samples != 3 || gpu.Missing != 1 {
+		t.Errorf("Expected 3 samples with readings and 1 missing, got %d and %d", gpu.Samples, gpu.Missing)
+	}
+	if gpu.Covered != 15*time.Second {
+		t.Errorf("Expected GPU readings to cover 15s, got %v", gpu.Covered)
+	}
+	if math.Abs(gpu.ErrorBound.Millijoules()-1.5) > 1e-9 {
+		t.Errorf("Expected an error bound of 1.5 mJ, got %v", gpu.ErrorBound)
+	}
+	lo, hi := gpu.Bounds()
+	if math.Abs(lo.Millijoules()-598.5) > 1e-9 || math.Abs(hi.Millijoules()-601.5) > 1e-9 {
+		t.Errorf("Unexpected bounds %v - %v", lo, hi)
+	}
+	if math.Abs(gpu.AveragePower().Milliwatts()-40) > 1e-9 {
+		t.Errorf("Expected 40 mW average power, got %v", gpu.AveragePower())
+	}
+}
+
+func TestIntegrateToleratesTimestampResolution(t *testing.T) {
+	base := time.Date(2025, 7, 6, 5, 15, 15, 0, time.UTC)
+	series := types.GPUSeries{
+		gpuSample(base, 5004758375, 19),
+		gpuSample(base.Add(6*time.Second), 5014447083, 107),
+		gpuSample(base.Add(11*time.Second), 5010073541, 788),
+	}
+
+	report := IntegrateGPU(series, Options{})
+	if len(report.Gaps) != 0 {
+		t.Errorf("Expected no gaps within timestamp resolution, got %+v", report.Gaps)
+	}
+}
+
+func TestIntegrateEmpty(t *testing.T) {
+	report := Integrate(nil, Options{})
+	if len(report.Components) != 0 || report.Covered != 0 {
+		t.Errorf("Expected empty report, got %+v", report)
+	}
+}
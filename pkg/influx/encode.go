@@ -0,0 +1,277 @@
+// Package influx encodes powermetrics samples in the InfluxDB line protocol
+// and writes them to InfluxDB over HTTP.
+//
+// Every sample becomes one line per measurement. GPU power samples yield a
+// powermetrics_gpu line and one line per DVFM state and software state, with
+// the frequency or state as a tag. Tasks samples yield a powermetrics_tasks
+// line for all tasks, and optionally one powermetrics_task line per task.
+// Battery and thermal samples yield a powermetrics_battery and a
+// powermetrics_thermal line. Timestamps have nanosecond precision.
+package influx
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Measurement names
+const (
+	MeasurementGPU              = "powermetrics_gpu"
+	MeasurementDVFM             = "powermetrics_gpu_dvfm"
+	MeasurementSWState          = "powermetrics_gpu_sw_state"
+	MeasurementSWRequestedState = "powermetrics_gpu_sw_requested_state"
+	MeasurementTasks            = "powermetrics_tasks"
+	MeasurementTask             = "powermetrics_task"
+	MeasurementBattery          = "powermetrics_battery"
+	MeasurementThermal          = "powermetrics_thermal"
+)
+
+// Options selects what is encoded.
+type Options struct {
+	// Tags lists the sample tags to include, among hw_model and os_build.
+	// Nil includes both. State tags, such as frequency_mhz, are always
+	// included.
+	Tags []string
+	// Fields lists the fields to include, such as power_watts or
+	// used_ratio. Nil includes every field. Lines left without fields are
+	// omitted.
+	Fields []string
+	// ExtraTags are added to every line, e.g. the host name.
+	ExtraTags map[string]string
+	// PerTask adds one powermetrics_task line per task of tasks samples,
+	// tagged with the task name and pid, so that tasks sharing a name keep
+	// distinct series. Every process is a series of its own.
+	PerTask bool
+}
+
+// Encoder writes samples to a stream in the line protocol.
+type Encoder struct {
+	w    io.Writer
+	opts Options
+	buf  []byte
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer, opts Options) *Encoder {
+	return &Encoder{w: w, opts: opts}
+}
+
+// Encode writes the lines of sample, each terminated by a newline.
+func (e *Encoder) Encode(sample types.Sample) error {
+	e.buf = AppendSample(e.buf[:0], sample, e.opts)
+	if len(e.buf) == 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// field is a single line protocol field. Exactly one of the value fields
+// is used, depending on kind.
+type field struct {
+	key  string
+	kind byte // 'f' float, 'i' integer, 's' string
+	f    float64
+	i    int64
+	s    string
+}
+
+func floatField(key string, v float64) field { return field{key: key, kind: 'f', f: v} }
+func intField(key string, v int64) field     { return field{key: key, kind: 'i', i: v} }
+func stringField(key string, v string) field { return field{key: key, kind: 's', s: v} }
+
+// AppendSample appends the lines of sample to dst and returns the extended
+// buffer. Unsupported sample types append nothing.
+func AppendSample(dst []byte, sample types.Sample, opts Options) []byte {
+	var ts int64
+	hasTS := !sample.GetTimestamp().IsZero()
+	if hasTS {
+		ts = sample.GetTimestamp().UnixNano()
+	}
+	tags := sampleTags(sample, opts)
+	line := func(measurement string, extra [][2]string, fields ...field) {
+		dst = appendLine(dst, measurement, append(extra, tags...), fields, opts.Fields, ts, hasTS)
+	}
+
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		fields := []field{
+			intField("elapsed_ns", s.ElapsedNS),
+			floatField("frequency_hz", s.GPU.Frequency().Hertz()),
+			floatField("idle_ratio", s.GPU.IdleRatio),
+			intField("idle_ns", s.GPU.IdleNS),
+		}
+		if e, ok := s.GPU.Energy(); ok {
+			fields = append(fields, floatField("energy_joules", e.Joules()))
+		}
+		if p, ok := s.Power(); ok {
+			fields = append(fields, floatField("power_watts", p.Watts()))
+		}
+		line(MeasurementGPU, nil, fields...)
+		for _, state := range mergeDVFMStates(s.GPU.DVFMStates) {
+			line(MeasurementDVFM, [][2]string{{"frequency_mhz", strconv.FormatInt(state.Freq, 10)}},
+				intField("used_ns", state.UsedNS), floatField("used_ratio", state.UsedRatio))
+		}
+		for _, state := range s.GPU.SWState {
+			line(MeasurementSWState, [][2]string{{"state", state.SWState}},
+				intField("used_ns", state.UsedNS), floatField("used_ratio", state.UsedRatio))
+		}
+		for _, state := range s.GPU.SWRequestedState {
+			line(MeasurementSWRequestedState, [][2]string{{"state", state.SWReqState}},
+				intField("used_ns", state.UsedNS), floatField("used_ratio", state.UsedRatio))
+		}
+	case *types.TasksSample:
+		line(MeasurementTasks, nil, taskFields(&s.AllTasks)...)
+		if opts.PerTask {
+			for i := range s.Tasks {
+				task := &s.Tasks[i]
+				line(MeasurementTask, [][2]string{{"name", task.Name}, {"pid", strconv.Itoa(task.PID)}},
+					taskFields(task)...)
+			}
+		}
+	case *types.BatterySample:
+		line(MeasurementBattery, nil,
+			intField("percent_charge", int64(s.Battery.PercentCharge)),
+			floatField("charge_ratio", float64(s.Battery.Charge())))
+	case *types.ThermalSample:
+		line(MeasurementThermal, nil,
+			stringField("pressure", s.ThermalPressure),
+			intField("pressure_level", int64(types.ThermalPressureRank(s.ThermalPressure))))
+	}
+	return dst
+}
+
+// mergeDVFMStates sums the residency of states sharing a frequency, which
+// powermetrics reports on some GPUs, as their lines would otherwise
+// overwrite each other.
+func mergeDVFMStates(states []types.DVFMState) []types.DVFMState {
+	merged := make([]types.DVFMState, 0, len(states))
+	index := make(map[int64]int, len(states))
+	for _, state := range states {
+		if i, ok := index[state.Freq]; ok {
+			merged[i].UsedNS += state.UsedNS
+			merged[i].UsedRatio += state.UsedRatio
+			continue
+		}
+		index[state.Freq] = len(merged)
+		merged = append(merged, state)
+	}
+	return merged
+}
+
+func taskFields(t *types.TaskInfo) []field {
+	return []field{
+		intField("cputime_ns", t.CPUTimeNS),
+		floatField("cputime_ms_per_s", t.CPUTimeMSPerS),
+		floatField("cputime_userland_ratio", t.CPUTimeUserlandRatio),
+		intField("intr_wakeups", t.IntrWakeups),
+		intField("idle_wakeups", t.IdleWakeups),
+		intField("diskio_bytesread", t.DiskIOBytesRead),
+		intField("diskio_byteswritten", t.DiskIOBytesWritten),
+		intField("packets_received", t.PacketsReceived),
+		intField("packets_sent", t.PacketsSent),
+		intField("bytes_received", t.BytesReceived),
+		intField("bytes_sent", t.BytesSent),
+		floatField("energy_impact", t.EnergyImpact),
+		floatField("energy_impact_per_s", t.EnergyImpactPerS),
+	}
+}
+
+// sampleTags returns the selected tags of sample and the extra tags.
+func sampleTags(sample types.Sample, opts Options) [][2]string {
+	var tags [][2]string
+	for _, tag := range [][2]string{{"hw_model", sample.GetHWModel()}, {"os_build", sample.GetKernOSVer()}} {
+		if opts.Tags == nil || contains(opts.Tags, tag[0]) {
+			tags = append(tags, tag)
+		}
+	}
+	for k, v := range opts.ExtraTags {
+		tags = append(tags, [2]string{k, v})
+	}
+	return tags
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// appendLine appends one line. Tags with empty values and fields that are
+// not selected or not finite are skipped, and the line is omitted when no
+// field is left.
+func appendLine(dst []byte, measurement string, tags [][2]string, fields []field, selected []string, ts int64, hasTS bool) []byte {
+	start := len(dst)
+	dst = append(dst, measurementEscaper.Replace(measurement)...)
+
+	sortTags(tags)
+	for _, tag := range tags {
+		if tag[1] == "" {
+			continue
+		}
+		dst = append(dst, ',')
+		dst = append(dst, keyEscaper.Replace(tag[0])...)
+		dst = append(dst, '=')
+		dst = append(dst, keyEscaper.Replace(tag[1])...)
+	}
+
+	n := 0
+	for _, f := range fields {
+		if selected != nil && !contains(selected, f.key) {
+			continue
+		}
+		if f.kind == 'f' && (math.IsNaN(f.f) || math.IsInf(f.f, 0)) {
+			continue
+		}
+		if n == 0 {
+			dst = append(dst, ' ')
+		} else {
+			dst = append(dst, ',')
+		}
+		n++
+		dst = append(dst, keyEscaper.Replace(f.key)...)
+		dst = append(dst, '=')
+		switch f.kind {
+		case 'f':
+			dst = strconv.AppendFloat(dst, f.f, 'g', -1, 64)
+		case 'i':
+			dst = strconv.AppendInt(dst, f.i, 10)
+			dst = append(dst, 'i')
+		case 's':
+			dst = append(dst, '"')
+			dst = append(dst, stringEscaper.Replace(f.s)...)
+			dst = append(dst, '"')
+		}
+	}
+	if n == 0 {
+		return dst[:start]
+	}
+
+	if hasTS {
+		dst = append(dst, ' ')
+		dst = strconv.AppendInt(dst, ts, 10)
+	}
+	return append(dst, '\n')
+}
+
+// sortTags sorts tags by key, as InfluxDB recommends.
+func sortTags(tags [][2]string) {
+	for i := 1; i < len(tags); i++ {
+		for j := i; j > 0 && tags[j][0] < tags[j-1][0]; j-- {
+			tags[j], tags[j-1] = tags[j-1], tags[j]
+		}
+	}
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
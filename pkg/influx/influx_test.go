@@ -0,0 +1,269 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestEncoder(t *testing.T) {
+	samples := sampletest.Read(t, sampletest.GPUPower)
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, Options{}).Encode(samples[0]); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	text := buf.String()
+
+	for _, want := range []string{
+		`powermetrics_gpu,hw_model=Mac16\,8,os_build=24F74 elapsed_ns=`,
+		",energy_joules=0.019,",
+		`powermetrics_gpu_dvfm,frequency_mhz=338,hw_model=Mac16\,8,os_build=24F74 used_ns=`,
+		`powermetrics_gpu_sw_state,hw_model=Mac16\,8,os_build=24F74,state=SW_P1 used_ns=`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, text)
+		}
+	}
+
+	ts := " " + strconv.FormatInt(samples[0].GetTimestamp().UnixNano(), 10) + "\n"
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" && !strings.HasSuffix(line, ts) {
+			t.Errorf("Expected line %q to end with the timestamp in nanoseconds", line)
+		}
+	}
+}
+
+func TestAppendSample(t *testing.T) {
+	at := time.Unix(1700000000, 5)
+	tests := []struct {
+		name   string
+		sample types.Sample
+		opts   Options
+		want   string
+	}{
+		{
+			name: "escaping",
+			sample: &types.TasksSample{
+				BaseSample: types.BaseSample{Timestamp: at, HWModel: "Mac 16,8", KernOSVer: "a=b"},
+				Tasks:      []types.TaskInfo{{PID: 7, Name: `my app,v2`, CPUTimeNS: 10}},
+			},
+			opts: Options{PerTask: true, Fields: []string{"cputime_ns"}},
+			want: `powermetrics_tasks,hw_model=Mac\ 16\,8,os_build=a\=b cputime_ns=0i 1700000000000000005` + "\n" +
+				`powermetrics_task,hw_model=Mac\ 16\,8,name=my\ app\,v2,os_build=a\=b,pid=7 cputime_ns=10i 1700000000000000005` + "\n",
+		},
+		{
+			name: "tasks sharing a name",
+			sample: &types.TasksSample{
+				BaseSample: types.BaseSample{Timestamp: at},
+				Tasks:      []types.TaskInfo{{PID: 7, Name: "zsh", CPUTimeNS: 10}, {PID: 8, Name: "zsh", CPUTimeNS: 20}},
+			},
+			opts: Options{PerTask: true, Tags: []string{}, Fields: []string{"cputime_ns"}},
+			want: `powermetrics_tasks cputime_ns=0i 1700000000000000005` + "\n" +
+				`powermetrics_task,name=zsh,pid=7 cputime_ns=10i 1700000000000000005` + "\n" +
+				`powermetrics_task,name=zsh,pid=8 cputime_ns=20i 1700000000000000005` + "\n",
+		},
+		{
+			name:   "string field",
+			sample: &types.ThermalSample{ThermalPressure: `Very "hot" \o/`},
+			want:   `powermetrics_thermal pressure="Very \"hot\" \\o/",pressure_level=5i` + "\n",
+		},
+		{
+			name: "tag selection and extra tags",
+			sample: &types.ThermalSample{
+				BaseSample:      types.BaseSample{Timestamp: at, HWModel: "Mac16,8", KernOSVer: "24F74"},
+				ThermalPressure: "Nominal",
+			},
+			opts: Options{Tags: []string{"os_build"}, ExtraTags: map[string]string{"host": "ci"}},
+			want: `powermetrics_thermal,host=ci,os_build=24F74 pressure="Nominal",pressure_level=0i 1700000000000000005` + "\n",
+		},
+		{
+			name:   "no fields left",
+			sample: &types.ThermalSample{ThermalPressure: "Nominal"},
+			opts:   Options{Fields: []string{"power_watts"}},
+			want:   "",
+		},
+		{
+			name: "state lines",
+			sample: &types.GPUPowerSample{
+				BaseSample: types.BaseSample{Timestamp: at},
+				GPU: types.GPUInfo{
+					DVFMStates: []types.DVFMState{{Freq: 338, UsedRatio: 0.25}, {Freq: 1182, UsedNS: 1, UsedRatio: 0.125}, {Freq: 1182, UsedNS: 2, UsedRatio: 0.25}},
+					SWState:    []types.SWState{{SWState: "SW_P1", UsedNS: 3}},
+				},
+			},
+			opts: Options{Fields: []string{"used_ratio", "used_ns"}},
+			want: "powermetrics_gpu_dvfm,frequency_mhz=338 used_ns=0i,used_ratio=0.25 1700000000000000005\n" +
+				"powermetrics_gpu_dvfm,frequency_mhz=1182 used_ns=3i,used_ratio=0.375 1700000000000000005\n" +
+				"powermetrics_gpu_sw_state,state=SW_P1 used_ns=3i,used_ratio=0 1700000000000000005\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AppendSample(nil, tt.sample, tt.opts)); got != tt.want {
+				t.Errorf("Expected:\n%q\ngot:\n%q", tt.want, got)
+			}
+		})
+	}
+}
+
+// influxServer is a stand-in for the InfluxDB write endpoint that fails the
+// first failures requests with status.
+type influxServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	requests int
+	batches  []string
+	query    string
+	auth     string
+}
+
+func (s *influxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.query = r.URL.RawQuery
+	s.auth = r.Header.Get("Authorization")
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, `{"message":"try later"}`, s.status)
+		return
+	}
+	s.batches = append(s.batches, string(body))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWriterBatches(t *testing.T) {
+	influx := &influxServer{}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+
+	w, err := NewWriter(server.URL+"/api/v2/write?org=o&bucket=b", WriterOptions{
+		Token:     "secret",
+		BatchSize: 2,
+		Encoding:  Options{Fields: []string{"pressure_level"}},
+	})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	ctx := context.Background()
+	for i := range 3 {
+		sample := &types.ThermalSample{BaseSample: types.BaseSample{Timestamp: time.Unix(int64(i), 0)}, ThermalPressure: "Nominal"}
+		if err := w.Write(ctx, sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	want := []string{
+		"powermetrics_thermal pressure_level=0i 0\npowermetrics_thermal pressure_level=0i 1000000000\n",
+		"powermetrics_thermal pressure_level=0i 2000000000\n",
+	}
+	if len(influx.batches) != len(want) {
+		t.Fatalf("Expected %d batches, got %d: %q", len(want), len(influx.batches), influx.batches)
+	}
+	for i := range want {
+		if influx.batches[i] != want[i] {
+			t.Errorf("Expected batch %d to be %q, got %q", i, want[i], influx.batches[i])
+		}
+	}
+	if influx.query != "bucket=b&org=o&precision=ns" {
+		t.Errorf("Expected the precision to be added to the query, got %q", influx.query)
+	}
+	if influx.auth != "Token secret" {
+		t.Errorf("Expected a token authorization header, got %q", influx.auth)
+	}
+}
+
+func TestWriterRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		status       int
+		wantRequests int
+		wantStatus   int
+	}{
+		{"recovers from 503", 2, http.StatusServiceUnavailable, 3, 0},
+		{"recovers from 429", 1, http.StatusTooManyRequests, 2, 0},
+		{"gives up after retries", 5, http.StatusInternalServerError, 4, http.StatusInternalServerError},
+		{"does not retry 400", 1, http.StatusBadRequest, 1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			influx := &influxServer{failures: tt.failures, status: tt.status}
+			server := httptest.NewServer(influx)
+			defer server.Close()
+
+			w, err := NewWriter(server.URL+"/write?db=power", WriterOptions{RetryBackoff: time.Millisecond})
+			if err != nil {
+				t.Fatalf("NewWriter failed: %v", err)
+			}
+			ctx := context.Background()
+			if err := w.Write(ctx, &types.ThermalSample{ThermalPressure: "Nominal"}); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			err = w.Flush(ctx)
+
+			var status *StatusError
+			switch {
+			case tt.wantStatus == 0 && err != nil:
+				t.Errorf("Expected flush to succeed, got %v", err)
+			case tt.wantStatus != 0 && (!errors.As(err, &status) || status.StatusCode != tt.wantStatus):
+				t.Errorf("Expected a %d status error, got %v", tt.wantStatus, err)
+			}
+			if influx.requests != tt.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tt.wantRequests, influx.requests)
+			}
+		})
+	}
+}
+
+func TestWriterRun(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read testdata: %v", err)
+	}
+	influx := &influxServer{}
+	server := httptest.NewServer(influx)
+	defer server.Close()
+
+	pm := powermetrics.NewWithRunner(&powermetrics.MockCommandRunner{Output: data})
+	stream, err := pm.Stream(context.Background(), &powermetrics.Config{
+		Samplers: []powermetrics.Sampler{powermetrics.GPUPower},
+		Format:   powermetrics.FormatPlist,
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	w, err := NewWriter(server.URL+"/write?db=power", WriterOptions{Encoding: Options{Fields: []string{"energy_joules"}}})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := w.Run(context.Background(), stream); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	body := strings.Join(influx.batches, "")
+	if got := strings.Count(body, "\n"); got != 5 {
+		t.Errorf("Expected 5 lines, got %d:\n%s", got, body)
+	}
+	if !strings.Contains(body, "energy_joules=0.788 ") {
+		t.Errorf("Expected the energy of the third sample, got:\n%s", body)
+	}
+}
@@ -0,0 +1,214 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/batch"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Writer defaults
+const (
+	DefaultBatchSize     = 5000
+	DefaultFlushInterval = 10 * time.Second
+	DefaultMaxRetries    = 3
+	DefaultRetryBackoff  = time.Second
+)
+
+// WriterOptions configures a Writer.
+type WriterOptions struct {
+	// Token is sent as "Authorization: Token <token>". For InfluxDB 1.x,
+	// use "user:password".
+	Token string
+	// Client sends the requests. Nil means http.DefaultClient.
+	Client *http.Client
+	// BatchSize is the number of lines that triggers a flush. Zero means
+	// DefaultBatchSize.
+	BatchSize int
+	// FlushInterval is how often Run flushes incomplete batches. Zero means
+	// DefaultFlushInterval.
+	FlushInterval time.Duration
+	// MaxRetries is the number of retries of a batch after a network error,
+	// a 429 or a 5xx response. Zero means DefaultMaxRetries, and a negative
+	// value disables retries.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled on every
+	// retry. A Retry-After header takes precedence. Zero means
+	// DefaultRetryBackoff.
+	RetryBackoff time.Duration
+	// Encoding selects the tags and fields that are written.
+	Encoding Options
+	// OnError, when set, is called by Run with the error of every failed
+	// flush.
+	OnError func(error)
+}
+
+// Writer batches samples and writes them to the InfluxDB HTTP API. It is
+// safe for concurrent use.
+type Writer struct {
+	url  string
+	opts WriterOptions
+
+	mu    sync.Mutex
+	buf   []byte
+	lines int
+}
+
+// NewWriter returns a writer posting to the write endpoint rawURL, such as
+// http://localhost:8086/api/v2/write?org=my-org&bucket=my-bucket for
+// InfluxDB 2.x and 3.x, or http://localhost:8086/write?db=my-db for
+// InfluxDB 1.x. The precision parameter is set to nanoseconds.
+func NewWriter(rawURL string, opts WriterOptions) (*Writer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid write URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid write URL %q: scheme must be http or https", rawURL)
+	}
+	query := u.Query()
+	query.Set("precision", "ns")
+	u.RawQuery = query.Encode()
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+	return &Writer{url: u.String(), opts: opts}, nil
+}
+
+// Write adds the lines of sample to the batch, and flushes the batch once
+// it holds BatchSize lines.
+func (w *Writer) Write(ctx context.Context, sample types.Sample) error {
+	w.mu.Lock()
+	n := len(w.buf)
+	w.buf = AppendSample(w.buf, sample, w.opts.Encoding)
+	w.lines += bytes.Count(w.buf[n:], []byte{'\n'})
+	full := w.lines >= w.opts.BatchSize
+	w.mu.Unlock()
+
+	if full {
+		return w.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush writes the batch, retrying as configured. A batch that cannot be
+// written is dropped.
+func (w *Writer) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	batch := w.buf
+	w.buf, w.lines = nil, 0
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return w.send(ctx, batch)
+}
+
+// Run writes every sample of stream until the stream ends, flushing at
+// least every FlushInterval, and flushes the last batch. Failed flushes are
+// reported to OnError; Run returns the error of the last flush. The stream's
+// samples must not be consumed elsewhere.
+func (w *Writer) Run(ctx context.Context, stream *powermetrics.Stream) error {
+	return batch.Run(ctx, stream.Samples(), w, w.opts.FlushInterval, w.opts.OnError)
+}
+
+// StatusError is returned for a request rejected by InfluxDB.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("influx write failed: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("influx write failed: %s: %s", http.StatusText(e.StatusCode), e.Body)
+}
+
+// temporary reports whether the request may succeed when retried.
+func (e *StatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func (w *Writer) send(ctx context.Context, batch []byte) error {
+	backoff := w.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := w.post(ctx, batch)
+		if err == nil {
+			return nil
+		}
+		var status *StatusError
+		if errors.As(err, &status) && !status.temporary() {
+			return err
+		}
+		if attempt >= w.opts.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		backoff *= 2
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// post sends one request. It returns the delay requested by a Retry-After
+// header, if any.
+func (w *Writer) post(ctx context.Context, batch []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(batch))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.opts.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.opts.Token)
+	}
+
+	resp, err := w.opts.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("influx write failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return 0, nil
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return retryAfter, &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+}
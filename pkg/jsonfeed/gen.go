@@ -0,0 +1,21 @@
+//go:build ignore
+
+// gen writes schema.json from the Record type.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/jsonfeed"
+)
+
+func main() {
+	schema, err := jsonfeed.Schema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("schema.json", append(schema, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,353 @@
+// Package jsonfeed defines a versioned JSON representation of powermetrics
+// samples and streams it as newline-delimited JSON.
+//
+// Every sample is a Record: an object with snake_case keys whose names carry
+// their unit, such as elapsed_ns, frequency_mhz or energy_mj. The sampler key
+// tells which of the gpu, all_tasks and tasks, battery or thermal keys is
+// present. Records carry the schema_version they follow; fields may be added
+// within a version, while renamed or removed fields bump it. Schema returns
+// the JSON Schema of the current version, also published as schema.json.
+package jsonfeed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+//go:generate go run gen.go
+
+// Version is the version of the representation.
+const Version = 1
+
+// ContentType is the media type of a newline-delimited JSON stream.
+const ContentType = "application/x-ndjson"
+
+// Sampler names
+const (
+	SamplerGPUPower = "gpu_power"
+	SamplerTasks    = "tasks"
+	SamplerBattery  = "battery"
+	SamplerThermal  = "thermal"
+)
+
+// ErrUnsupportedVersion is returned for records of another schema version.
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// Record is the JSON representation of a sample.
+type Record struct {
+	SchemaVersion int        `json:"schema_version" doc:"Version of the representation this record follows." enum:"1"`
+	Sampler       string     `json:"sampler" doc:"Sampler that produced the record; tells which sampler object is present." enum:"gpu_power,tasks,battery,thermal"`
+	Timestamp     *time.Time `json:"timestamp,omitempty" doc:"Time of the sample reported by powermetrics, in RFC 3339 format."`
+	ElapsedNS     int64      `json:"elapsed_ns" doc:"Length of the sample interval in nanoseconds."`
+	HWModel       string     `json:"hw_model" doc:"Hardware model, e.g. Mac16,8."`
+	KernOSVersion string     `json:"kern_osversion" doc:"Operating system build, e.g. 24F74."`
+	KernBootArgs  string     `json:"kern_bootargs" doc:"Kernel boot arguments."`
+	KernBootTime  int64      `json:"kern_boottime_unix_s" doc:"Boot time in seconds since the Unix epoch."`
+	IsDelta       bool       `json:"is_delta" doc:"Whether counters cover the interval rather than the time since boot."`
+	GPU           *GPU       `json:"gpu,omitempty" doc:"GPU metrics of gpu_power records."`
+	AllTasks      *Task      `json:"all_tasks,omitempty" doc:"Totals over every task of tasks records."`
+	Tasks         []Task     `json:"tasks,omitempty" doc:"Individual tasks of tasks records."`
+	Battery       *Battery   `json:"battery,omitempty" doc:"Battery state of battery records."`
+	Thermal       *Thermal   `json:"thermal,omitempty" doc:"Thermal state of thermal records."`
+}
+
+// GPU holds the metrics of a gpu_power sample.
+type GPU struct {
+	FrequencyMHz      float64     `json:"frequency_mhz" doc:"Average active frequency in MHz."`
+	IdleNS            int64       `json:"idle_ns" doc:"Time the GPU was idle in nanoseconds."`
+	IdleRatio         float64     `json:"idle_ratio" doc:"Fraction of the interval the GPU was idle, from 0 to 1."`
+	EnergyMJ          *int64      `json:"energy_mj,omitempty" doc:"Energy used over the interval in millijoules, when reported."`
+	DVFMStates        []DVFMState `json:"dvfm_states" doc:"Residency of the hardware frequency states. Several states may share a frequency."`
+	SWRequestedStates []SWState   `json:"sw_requested_states" doc:"Residency of the software-requested performance states."`
+	SWStates          []SWState   `json:"sw_states" doc:"Residency of the software performance states."`
+}
+
+// DVFMState is the residency of a GPU frequency state.
+type DVFMState struct {
+	FrequencyMHz int64   `json:"frequency_mhz" doc:"Frequency of the state in MHz."`
+	UsedNS       int64   `json:"used_ns" doc:"Time spent in the state in nanoseconds."`
+	UsedRatio    float64 `json:"used_ratio" doc:"Fraction of the interval spent in the state, from 0 to 1."`
+}
+
+// SWState is the residency of a GPU software performance state.
+type SWState struct {
+	State     string  `json:"state" doc:"Name of the state, e.g. SW_P1."`
+	UsedNS    int64   `json:"used_ns" doc:"Time spent in the state in nanoseconds."`
+	UsedRatio float64 `json:"used_ratio" doc:"Fraction of the interval spent in the state, from 0 to 1."`
+}
+
+// Task holds the metrics of a task, or the totals of every task.
+type Task struct {
+	PID                  int     `json:"pid" doc:"Process ID."`
+	Name                 string  `json:"name" doc:"Process name."`
+	IntervalNS           int64   `json:"interval_ns" doc:"Length of the interval the task was observed in nanoseconds."`
+	CPUTimeNS            int64   `json:"cpu_time_ns" doc:"CPU time used in nanoseconds."`
+	CPUTimeMSPerS        float64 `json:"cpu_time_ms_per_s" doc:"CPU time used in milliseconds per second."`
+	CPUTimeUserlandRatio float64 `json:"cpu_time_userland_ratio" doc:"Fraction of the CPU time spent in userland, from 0 to 1."`
+	InterruptWakeups     int64   `json:"interrupt_wakeups" doc:"Interrupt wakeups."`
+	InterruptWakeupsPerS float64 `json:"interrupt_wakeups_per_s" doc:"Interrupt wakeups per second."`
+	IdleWakeups          int64   `json:"idle_wakeups" doc:"Package idle exits."`
+	IdleWakeupsPerS      float64 `json:"idle_wakeups_per_s" doc:"Package idle exits per second."`
+	DiskReadBytes        int64   `json:"disk_read_bytes" doc:"Bytes read from disk."`
+	DiskWrittenBytes     int64   `json:"disk_written_bytes" doc:"Bytes written to disk."`
+	PacketsReceived      int64   `json:"packets_received" doc:"Network packets received."`
+	PacketsSent          int64   `json:"packets_sent" doc:"Network packets sent."`
+	BytesReceived        int64   `json:"bytes_received" doc:"Network bytes received."`
+	BytesSent            int64   `json:"bytes_sent" doc:"Network bytes sent."`
+	EnergyImpact         float64 `json:"energy_impact" doc:"Energy impact over the interval, a unitless score."`
+	EnergyImpactPerS     float64 `json:"energy_impact_per_s" doc:"Energy impact per second, a unitless score."`
+}
+
+// Battery holds the state of a battery sample.
+type Battery struct {
+	PercentCharge int `json:"percent_charge" doc:"Charge level in percent, from 0 to 100."`
+}
+
+// Thermal holds the state of a thermal sample.
+type Thermal struct {
+	Pressure string `json:"pressure" doc:"Thermal pressure level: Nominal, Moderate, Heavy, Trapping or Sleeping."`
+}
+
+// FromSample returns the record of sample.
+func FromSample(sample types.Sample) (*Record, error) {
+	r := &Record{
+		SchemaVersion: Version,
+		ElapsedNS:     sample.GetElapsedNS(),
+		HWModel:       sample.GetHWModel(),
+		KernOSVersion: sample.GetKernOSVer(),
+		KernBootArgs:  sample.GetKernBootArgs(),
+		KernBootTime:  sample.GetKernBootTime(),
+		IsDelta:       sample.GetIsDelta(),
+	}
+	if ts := sample.GetTimestamp(); !ts.IsZero() {
+		r.Timestamp = &ts
+	}
+
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		r.Sampler = SamplerGPUPower
+		gpu := &GPU{
+			FrequencyMHz:      s.GPU.FreqHz,
+			IdleNS:            s.GPU.IdleNS,
+			IdleRatio:         s.GPU.IdleRatio,
+			DVFMStates:        make([]DVFMState, len(s.GPU.DVFMStates)),
+			SWRequestedStates: make([]SWState, len(s.GPU.SWRequestedState)),
+			SWStates:          make([]SWState, len(s.GPU.SWState)),
+		}
+		if s.GPU.GPUEnergy != nil {
+			e := *s.GPU.GPUEnergy
+			gpu.EnergyMJ = &e
+		}
+		for i, state := range s.GPU.DVFMStates {
+			gpu.DVFMStates[i] = DVFMState{FrequencyMHz: state.Freq, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio}
+		}
+		for i, state := range s.GPU.SWRequestedState {
+			gpu.SWRequestedStates[i] = SWState{State: state.SWReqState, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio}
+		}
+		for i, state := range s.GPU.SWState {
+			gpu.SWStates[i] = SWState{State: state.SWState, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio}
+		}
+		r.GPU = gpu
+	case *types.TasksSample:
+		r.Sampler = SamplerTasks
+		all := fromTask(&s.AllTasks)
+		r.AllTasks = &all
+		r.Tasks = make([]Task, len(s.Tasks))
+		for i := range s.Tasks {
+			r.Tasks[i] = fromTask(&s.Tasks[i])
+		}
+	case *types.BatterySample:
+		r.Sampler = SamplerBattery
+		r.Battery = &Battery{PercentCharge: s.Battery.PercentCharge}
+	case *types.ThermalSample:
+		r.Sampler = SamplerThermal
+		r.Thermal = &Thermal{Pressure: s.ThermalPressure}
+	default:
+		return nil, fmt.Errorf("unsupported sample type %T", sample)
+	}
+	return r, nil
+}
+
+func fromTask(t *types.TaskInfo) Task {
+	return Task{
+		PID:                  t.PID,
+		Name:                 t.Name,
+		IntervalNS:           t.IntervalNS,
+		CPUTimeNS:            t.CPUTimeNS,
+		CPUTimeMSPerS:        t.CPUTimeMSPerS,
+		CPUTimeUserlandRatio: t.CPUTimeUserlandRatio,
+		InterruptWakeups:     t.IntrWakeups,
+		InterruptWakeupsPerS: t.IntrWakeupsPerS,
+		IdleWakeups:          t.IdleWakeups,
+		IdleWakeupsPerS:      t.IdleWakeupsPerS,
+		DiskReadBytes:        t.DiskIOBytesRead,
+		DiskWrittenBytes:     t.DiskIOBytesWritten,
+		PacketsReceived:      t.PacketsReceived,
+		PacketsSent:          t.PacketsSent,
+		BytesReceived:        t.BytesReceived,
+		BytesSent:            t.BytesSent,
+		EnergyImpact:         t.EnergyImpact,
+		EnergyImpactPerS:     t.EnergyImpactPerS,
+	}
+}
+
+func (t *Task) info() types.TaskInfo {
+	return types.TaskInfo{
+		PID:                  t.PID,
+		Name:                 t.Name,
+		IntervalNS:           t.IntervalNS,
+		CPUTimeNS:            t.CPUTimeNS,
+		CPUTimeMSPerS:        t.CPUTimeMSPerS,
+		CPUTimeUserlandRatio: t.CPUTimeUserlandRatio,
+		IntrWakeups:          t.InterruptWakeups,
+		IntrWakeupsPerS:      t.InterruptWakeupsPerS,
+		IdleWakeups:          t.IdleWakeups,
+		IdleWakeupsPerS:      t.IdleWakeupsPerS,
+		DiskIOBytesRead:      t.DiskReadBytes,
+		DiskIOBytesWritten:   t.DiskWrittenBytes,
+		PacketsReceived:      t.PacketsReceived,
+		PacketsSent:          t.PacketsSent,
+		BytesReceived:        t.BytesReceived,
+		BytesSent:            t.BytesSent,
+		EnergyImpact:         t.EnergyImpact,
+		EnergyImpactPerS:     t.EnergyImpactPerS,
+	}
+}
+
+// Sample returns the sample the record represents.
+func (r *Record) Sample() (types.Sample, error) {
+	if r.SchemaVersion != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, r.SchemaVersion)
+	}
+	base := types.BaseSample{
+		IsDelta:      r.IsDelta,
+		ElapsedNS:    r.ElapsedNS,
+		HWModel:      r.HWModel,
+		KernOSVer:    r.KernOSVersion,
+		KernBootArgs: r.KernBootArgs,
+		KernBootTime: r.KernBootTime,
+	}
+	if r.Timestamp != nil {
+		base.Timestamp = *r.Timestamp
+	}
+
+	switch r.Sampler {
+	case SamplerGPUPower:
+		if r.GPU == nil {
+			return nil, fmt.Errorf("gpu_power record without gpu")
+		}
+		s := &types.GPUPowerSample{BaseSample: base}
+		s.GPU.FreqHz = r.GPU.FrequencyMHz
+		s.GPU.IdleNS = r.GPU.IdleNS
+		s.GPU.IdleRatio = r.GPU.IdleRatio
+		if r.GPU.EnergyMJ != nil {
+			e := *r.GPU.EnergyMJ
+			s.GPU.GPUEnergy = &e
+		}
+		for _, state := range r.GPU.DVFMStates {
+			s.GPU.DVFMStates = append(s.GPU.DVFMStates, types.DVFMState{Freq: state.FrequencyMHz, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio})
+		}
+		for _, state := range r.GPU.SWRequestedStates {
+			s.GPU.SWRequestedState = append(s.GPU.SWRequestedState, types.SWReqState{SWReqState: state.State, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio})
+		}
+		for _, state := range r.GPU.SWStates {
+			s.GPU.SWState = append(s.GPU.SWState, types.SWState{SWState: state.State, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio})
+		}
+		return s, nil
+	case SamplerTasks:
+		if r.AllTasks == nil {
+			return nil, fmt.Errorf("tasks record without all_tasks")
+		}
+		s := &types.TasksSample{BaseSample: base, AllTasks: r.AllTasks.info()}
+		for i := range r.Tasks {
+			s.Tasks = append(s.Tasks, r.Tasks[i].info())
+		}
+		return s, nil
+	case SamplerBattery:
+		if r.Battery == nil {
+			return nil, fmt.Errorf("battery record without battery")
+		}
+		return &types.BatterySample{BaseSample: base, Battery: types.BatteryInfo{PercentCharge: r.Battery.PercentCharge}}, nil
+	case SamplerThermal:
+		if r.Thermal == nil {
+			return nil, fmt.Errorf("thermal record without thermal")
+		}
+		return &types.ThermalSample{BaseSample: base, ThermalPressure: r.Thermal.Pressure}, nil
+	}
+	return nil, fmt.Errorf("unsupported sampler %q", r.Sampler)
+}
+
+// Encoder writes samples as newline-delimited JSON, one record per line.
+type Encoder struct {
+	enc *json.Encoder
+}
+
+// NewEncoder returns an encoder writing to w. Each record is written with a
+// single call to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{enc: json.NewEncoder(w)}
+}
+
+// Encode writes the record of sample.
+func (e *Encoder) Encode(sample types.Sample) error {
+	r, err := FromSample(sample)
+	if err != nil {
+		return err
+	}
+	return e.enc.Encode(r)
+}
+
+// Decoder reads samples from newline-delimited JSON.
+type Decoder struct {
+	r    *bufio.Reader
+	line int
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode returns the next sample, or io.EOF at the end of the stream. Blank
+// lines are skipped.
+func (d *Decoder) Decode() (types.Sample, error) {
+	r, err := d.DecodeRecord()
+	if err != nil {
+		return nil, err
+	}
+	sample, err := r.Sample()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", d.line, err)
+	}
+	return sample, nil
+}
+
+// DecodeRecord returns the next record without converting it, or io.EOF at
+// the end of the stream.
+func (d *Decoder) DecodeRecord() (*Record, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		d.line++
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var r Record
+		if jsonErr := json.Unmarshal(line, &r); jsonErr != nil {
+			return nil, fmt.Errorf("line %d: %w", d.line, jsonErr)
+		}
+		return &r, nil
+	}
+}
@@ -0,0 +1,215 @@
+package jsonfeed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestRoundTrip(t *testing.T) {
+	samples := sampletest.Samples(t)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	encoded := buf.String()
+	if lines := strings.Count(encoded, "\n"); lines != len(samples) {
+		t.Fatalf("Expected %d lines, got %d", len(samples), lines)
+	}
+	for _, want := range []string{
+		`{"schema_version":1,"sampler":"gpu_power","timestamp":"2025-07-06T05:15:15Z","elapsed_ns":5004758375,"hw_model":"Mac16,8"`,
+		`"energy_mj":19,`,
+		`{"frequency_mhz":338,"used_ns":58088833,"used_ratio":0.0149429}`,
+		`{"state":"SW_P1","used_ns":`,
+		`"sampler":"tasks"`,
+		`"thermal":{"pressure":"Moderate"}`,
+		`"battery":{"percent_charge":87}`,
+	} {
+		if !strings.Contains(encoded, want) {
+			t.Errorf("Expected output to contain %s", want)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(encoded))
+	var reencoded bytes.Buffer
+	enc = NewEncoder(&reencoded)
+	for {
+		sample, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if err := enc.Encode(sample); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	if reencoded.String() != encoded {
+		t.Error("Expected decoded samples to encode identically")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	input := "\n" + `{"schema_version":1,"sampler":"thermal","elapsed_ns":1,"thermal":{"pressure":"Heavy"},"future_field":true}` + "\n\n" +
+		`{"schema_version":1,"sampler":"battery","battery":{"percent_charge":5}}`
+	dec := NewDecoder(strings.NewReader(input))
+	sample, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if thermal, ok := sample.(*types.ThermalSample); !ok || thermal.ThermalPressure != "Heavy" || thermal.ElapsedNS != 1 {
+		t.Errorf("Expected a Heavy thermal sample, got %#v", sample)
+	}
+	sample, err = dec.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if battery, ok := sample.(*types.BatterySample); !ok || battery.Battery.PercentCharge != 5 {
+		t.Errorf("Expected a battery sample without trailing newline, got %#v", sample)
+	}
+	if _, err := dec.Decode(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+		wantMsg string
+	}{
+		{"future version", `{"schema_version":2,"sampler":"thermal"}`, ErrUnsupportedVersion, "line 1"},
+		{"missing version", `{"sampler":"thermal","thermal":{}}`, ErrUnsupportedVersion, "line 1"},
+		{"invalid json", "\n{\"schema_version\":1,", nil, "line 2"},
+		{"missing object", `{"schema_version":1,"sampler":"gpu_power"}`, nil, "without gpu"},
+		{"unknown sampler", `{"schema_version":1,"sampler":"cpu_power"}`, nil, "unsupported sampler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDecoder(strings.NewReader(tt.input)).Decode()
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Expected error to contain %q, got %v", tt.wantMsg, err)
+			}
+		})
+	}
+}
+
+func TestSchemaFile(t *testing.T) {
+	schema, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema failed: %v", err)
+	}
+	published, err := os.ReadFile("schema.json")
+	if err != nil {
+		t.Fatalf("Failed to read schema.json: %v", err)
+	}
+	if string(published) != string(schema)+"\n" {
+		t.Error("Expected schema.json to be up to date, run go generate ./pkg/jsonfeed")
+	}
+}
+
+func TestRecordsMatchSchema(t *testing.T) {
+	raw, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema failed: %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	defs := schema["$defs"].(map[string]any)
+
+	for _, sample := range sampletest.Samples(t) {
+		record, err := FromSample(sample)
+		if err != nil {
+			t.Fatalf("FromSample failed: %v", err)
+		}
+		data, _ := json.Marshal(record)
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			t.Fatalf("Failed to parse record: %v", err)
+		}
+		validate(t, record.Sampler, schema, defs, value)
+	}
+}
+
+// validate checks value against the subset of JSON Schema used by Schema.
+func validate(t *testing.T, path string, schema, defs map[string]any, value any) {
+	t.Helper()
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, v := range enum {
+			found = found || v == value
+		}
+		if !found {
+			t.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			t.Errorf("%s: expected an object, got %T", path, value)
+			return
+		}
+		properties := schema["properties"].(map[string]any)
+		for _, key := range schema["required"].([]any) {
+			if _, ok := object[key.(string)]; !ok {
+				t.Errorf("%s: missing required %s", path, key)
+			}
+		}
+		for key, v := range object {
+			property, ok := properties[key].(map[string]any)
+			if !ok {
+				t.Errorf("%s: %s is not in the schema", path, key)
+				continue
+			}
+			validate(t, path+"."+key, property, defs, v)
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			t.Errorf("%s: expected an array, got %T", path, value)
+			return
+		}
+		for _, v := range array {
+			validate(t, path+"[]", schema["items"].(map[string]any), defs, v)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			t.Errorf("%s: expected a string, got %T", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			t.Errorf("%s: expected a boolean, got %T", path, value)
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok {
+			t.Errorf("%s: expected a number, got %T", path, value)
+		} else if schema["type"] == "integer" && n != float64(int64(n)) {
+			t.Errorf("%s: expected an integer, got %v", path, n)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package jsonfeed
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaDialect is the JSON Schema draft the schema follows.
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema returns the JSON Schema of a record, derived from the Record type.
+// Keys without omitempty are required; unknown keys are allowed, so that
+// consumers accept fields added within a version.
+func Schema() ([]byte, error) {
+	defs := make(map[string]any)
+	schema := objectSchema(reflect.TypeOf(Record{}), defs)
+	schema["$defs"] = defs
+	// Each sampler requires its objects.
+	var rules []any
+	for _, rule := range []struct {
+		sampler  string
+		required []string
+	}{
+		{SamplerGPUPower, []string{"gpu"}},
+		{SamplerTasks, []string{"all_tasks"}},
+		{SamplerBattery, []string{"battery"}},
+		{SamplerThermal, []string{"thermal"}},
+	} {
+		rules = append(rules, map[string]any{
+			"if":   map[string]any{"properties": map[string]any{"sampler": map[string]any{"const": rule.sampler}}},
+			"then": map[string]any{"required": rule.required},
+		})
+	}
+	schema["allOf"] = rules
+	schema["$schema"] = SchemaDialect
+	schema["title"] = "powermetrics sample"
+	schema["description"] = "A powermetrics sample, version " + strconv.Itoa(Version) +
+		". Newline-delimited JSON streams hold one record per line."
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of t as a map, which encoding/json writes with
+// sorted keys for a stable output. Structs are added to defs and referenced.
+func schemaOf(t reflect.Type, defs map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // guards against recursive types
+			defs[t.Name()] = objectSchema(t, defs)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{"type": "integer"}
+	}
+}
+
+// objectSchema returns the schema of the struct type t.
+func objectSchema(t reflect.Type, defs map[string]any) map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		property := schemaOf(field.Type, defs)
+		if doc := field.Tag.Get("doc"); doc != "" {
+			property["description"] = doc
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			property["enum"] = enumValues(field.Type, enum)
+		}
+		properties[name] = property
+		if opts != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// enumValues returns the comma-separated values of an enum tag, as numbers
+// for numeric fields.
+func enumValues(t reflect.Type, enum string) []any {
+	var values []any
+	for _, v := range strings.Split(enum, ",") {
+		if n, err := strconv.Atoi(v); err == nil && t.Kind() != reflect.String {
+			values = append(values, n)
+		} else {
+			values = append(values, v)
+		}
+	}
+	return values
+}
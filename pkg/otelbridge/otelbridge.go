@@ -0,0 +1,345 @@
+// Package otelbridge publishes powermetrics samples as OpenTelemetry
+// metrics.
+//
+// A Bridge registers observable instruments on a meter and reports the
+// values accumulated from a sampling stream whenever the SDK collects:
+// energy, residency, CPU time and wakeups as counters, and the values of
+// the most recent interval, such as frequency, power and ratios, as gauges.
+// DVFM frequencies and software states are reported as attributes.
+package otelbridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// ScopeName is the instrumentation scope name to obtain a meter with.
+const ScopeName = "github.com/matiasinsaurralde/powermetrics/pkg/otelbridge"
+
+// Attribute keys of the reported data points.
+const (
+	SamplerKey              = attribute.Key("powermetrics.sampler")
+	DVFMFrequencyKey        = attribute.Key("gpu.dvfm.frequency_mhz")
+	SWStateKey              = attribute.Key("gpu.sw_state")
+	SWRequestedStateKey     = attribute.Key("gpu.sw_requested_state")
+	WakeupKindKey           = attribute.Key("wakeup.kind")
+	DirectionKey            = attribute.Key("direction")
+	ThermalPressureLevelKey = attribute.Key("thermal.pressure.level")
+)
+
+// Bridge reports the samples it observes through observable instruments. It
+// is safe for concurrent use.
+type Bridge struct {
+	registration metric.Registration
+
+	samples         metric.Int64ObservableCounter
+	gpuEnergy       metric.Float64ObservableCounter
+	gpuPower        metric.Float64ObservableGauge
+	gpuFrequency    metric.Float64ObservableGauge
+	gpuIdleRatio    metric.Float64ObservableGauge
+	gpuIdleTime     metric.Float64ObservableCounter
+	dvfmResidency   metric.Float64ObservableCounter
+	dvfmRatio       metric.Float64ObservableGauge
+	swResidency     metric.Float64ObservableCounter
+	swRatio         metric.Float64ObservableGauge
+	swReqResidency  metric.Float64ObservableCounter
+	swReqRatio      metric.Float64ObservableGauge
+	cpuTime         metric.Float64ObservableCounter
+	cpuUsage        metric.Float64ObservableGauge
+	wakeups         metric.Int64ObservableCounter
+	diskIO          metric.Int64ObservableCounter
+	networkIO       metric.Int64ObservableCounter
+	networkPackets  metric.Int64ObservableCounter
+	energyImpact    metric.Float64ObservableGauge
+	thermalPressure metric.Int64ObservableGauge
+	thermalLevel    metric.Int64ObservableGauge
+
+	mu    sync.Mutex
+	state state
+}
+
+// state holds the values accumulated from the observed samples.
+type state struct {
+	samples map[string]int64
+
+	hasGPU         bool
+	gpuEnergy      float64
+	hasPower       bool
+	gpuPower       float64
+	gpuFrequency   float64
+	gpuIdleRatio   float64
+	gpuIdleTime    float64
+	dvfmResidency  map[int64]float64
+	dvfmRatio      map[int64]float64
+	swResidency    map[string]float64
+	swRatio        map[string]float64
+	swReqResidency map[string]float64
+	swReqRatio     map[string]float64
+
+	hasTasks       bool
+	cpuTime        float64
+	cpuUsage       float64
+	wakeups        map[string]int64
+	diskIO         map[string]int64
+	networkIO      map[string]int64
+	networkPackets map[string]int64
+	energyImpact   float64
+
+	thermalPressure string
+}
+
+// New registers the instruments of the bridge on meter.
+func New(meter metric.Meter) (*Bridge, error) {
+	b := &Bridge{state: state{
+		samples:        make(map[string]int64),
+		dvfmResidency:  make(map[int64]float64),
+		dvfmRatio:      make(map[int64]float64),
+		swResidency:    make(map[string]float64),
+		swRatio:        make(map[string]float64),
+		swReqResidency: make(map[string]float64),
+		swReqRatio:     make(map[string]float64),
+		wakeups:        make(map[string]int64),
+		diskIO:         make(map[string]int64),
+		networkIO:      make(map[string]int64),
+		networkPackets: make(map[string]int64),
+	}}
+
+	var errs []error
+	check := func(err error) {
+		errs = append(errs, err)
+	}
+	var err error
+	b.samples, err = meter.Int64ObservableCounter("powermetrics.samples",
+		metric.WithUnit("{sample}"), metric.WithDescription("Number of samples received, by sampler."))
+	check(err)
+	b.gpuEnergy, err = meter.Float64ObservableCounter("powermetrics.gpu.energy",
+		metric.WithUnit("J"), metric.WithDescription("Energy used by the GPU."))
+	check(err)
+	b.gpuPower, err = meter.Float64ObservableGauge("powermetrics.gpu.power",
+		metric.WithUnit("W"), metric.WithDescription("Average GPU power over the most recent interval."))
+	check(err)
+	b.gpuFrequency, err = meter.Float64ObservableGauge("powermetrics.gpu.frequency",
+		metric.WithUnit("Hz"), metric.WithDescription("Average active GPU frequency over the most recent interval."))
+	check(err)
+	b.gpuIdleRatio, err = meter.Float64ObservableGauge("powermetrics.gpu.idle_ratio",
+		metric.WithUnit("1"), metric.WithDescription("Fraction of the most recent interval the GPU was idle."))
+	check(err)
+	b.gpuIdleTime, err = meter.Float64ObservableCounter("powermetrics.gpu.idle_time",
+		metric.WithUnit("s"), metric.WithDescription("Time the GPU was idle."))
+	check(err)
+	b.dvfmResidency, err = meter.Float64ObservableCounter("powermetrics.gpu.dvfm.residency",
+		metric.WithUnit("s"), metric.WithDescription("Time spent in a GPU DVFM state."))
+	check(err)
+	b.dvfmRatio, err = meter.Float64ObservableGauge("powermetrics.gpu.dvfm.residency_ratio",
+		metric.WithUnit("1"), metric.WithDescription("Fraction of the most recent interval spent in a GPU DVFM state."))
+	check(err)
+	b.swResidency, err = meter.Float64ObservableCounter("powermetrics.gpu.sw_state.residency",
+		metric.WithUnit("s"), metric.WithDescription("Time spent in a GPU software state."))
+	check(err)
+	b.swRatio, err = meter.Float64ObservableGauge("powermetrics.gpu.sw_state.residency_ratio",
+		metric.WithUnit("1"), metric.WithDescription("Fraction of the most recent interval spent in a GPU software state."))
+	check(err)
+	b.swReqResidency, err = meter.Float64ObservableCounter("powermetrics.gpu.sw_requested_state.residency",
+		metric.WithUnit("s"), metric.WithDescription("Time a GPU software state was requested."))
+	check(err)
+	b.swReqRatio, err = meter.Float64ObservableGauge("powermetrics.gpu.sw_requested_state.residency_ratio",
+		metric.WithUnit("1"), metric.WithDescription("Fraction of the most recent interval a GPU software state was requested."))
+	check(err)
+	b.cpuTime, err = meter.Float64ObservableCounter("powermetrics.tasks.cpu_time",
+		metric.WithUnit("s"), metric.WithDescription("CPU time used by all tasks."))
+	check(err)
+	b.cpuUsage, err = meter.Float64ObservableGauge("powermetrics.tasks.cpu_usage",
+		metric.WithUnit("{cpu}"), metric.WithDescription("CPU time used by all tasks per second over the most recent interval."))
+	check(err)
+	b.wakeups, err = meter.Int64ObservableCounter("powermetrics.tasks.wakeups",
+		metric.WithUnit("{wakeup}"), metric.WithDescription("Wakeups of all tasks, by kind."))
+	check(err)
+	b.diskIO, err = meter.Int64ObservableCounter("powermetrics.tasks.disk.io",
+		metric.WithUnit("By"), metric.WithDescription("Disk I/O of all tasks, by direction."))
+	check(err)
+	b.networkIO, err = meter.Int64ObservableCounter("powermetrics.tasks.network.io",
+		metric.WithUnit("By"), metric.WithDescription("Network traffic of all tasks, by direction."))
+	check(err)
+	b.networkPackets, err = meter.Int64ObservableCounter("powermetrics.tasks.network.packets",
+		metric.WithUnit("{packet}"), metric.WithDescription("Network packets of all tasks, by direction."))
+	check(err)
+	b.energyImpact, err = meter.Float64ObservableGauge("powermetrics.tasks.energy_impact_per_second",
+		metric.WithUnit("1"), metric.WithDescription("Energy impact of all tasks per second over the most recent interval."))
+	check(err)
+	b.thermalPressure, err = meter.Int64ObservableGauge("powermetrics.thermal.pressure",
+		metric.WithUnit("1"), metric.WithDescription("Whether the thermal pressure is at a level."))
+	check(err)
+	b.thermalLevel, err = meter.Int64ObservableGauge("powermetrics.thermal.pressure_level",
+		metric.WithUnit("1"), metric.WithDescription("Thermal pressure level, from 0 for Nominal to 4 for Sleeping."))
+	check(err)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	b.registration, err = meter.RegisterCallback(b.callback,
+		b.samples, b.gpuEnergy, b.gpuPower, b.gpuFrequency, b.gpuIdleRatio, b.gpuIdleTime,
+		b.dvfmResidency, b.dvfmRatio, b.swResidency, b.swRatio, b.swReqResidency, b.swReqRatio,
+		b.cpuTime, b.cpuUsage, b.wakeups, b.diskIO, b.networkIO, b.networkPackets, b.energyImpact,
+		b.thermalPressure, b.thermalLevel)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Watch observes every sample of stream in a new goroutine, which ends with
+// the stream. The stream's samples must not be consumed elsewhere; call
+// Observe to combine the bridge with other processing.
+func (b *Bridge) Watch(stream *powermetrics.Stream) {
+	go func() {
+		for sample := range stream.Samples() {
+			b.Observe(sample)
+		}
+	}()
+}
+
+// Unregister stops reporting to the meter.
+func (b *Bridge) Unregister() error {
+	return b.registration.Unregister()
+}
+
+// Observe adds a sample.
+func (b *Bridge) Observe(sample types.Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &b.state
+	elapsed := time.Duration(sample.GetElapsedNS()).Seconds()
+
+	switch sample := sample.(type) {
+	case *types.GPUPowerSample:
+		s.samples[string(powermetrics.GPUPower)]++
+		s.hasGPU = true
+		if e, ok := sample.GPU.Energy(); ok {
+			s.gpuEnergy += e.Joules()
+		}
+		if p, ok := sample.Power(); ok {
+			s.hasPower = true
+			s.gpuPower = p.Watts()
+		}
+		s.gpuFrequency = sample.GPU.Frequency().Hertz()
+		s.gpuIdleRatio = sample.GPU.IdleRatio
+		s.gpuIdleTime += sample.GPU.Idle().Seconds()
+
+		// Ratios describe the most recent interval only.
+		clear(s.dvfmRatio)
+		clear(s.swRatio)
+		clear(s.swReqRatio)
+		for _, state := range sample.GPU.DVFMStates {
+			s.dvfmResidency[state.Freq] += residency(state.Used(), state.UsedRatio, elapsed)
+			s.dvfmRatio[state.Freq] += state.UsedRatio
+		}
+		for _, state := range sample.GPU.SWState {
+			s.swResidency[state.SWState] += residency(state.Used(), state.UsedRatio, elapsed)
+			s.swRatio[state.SWState] += state.UsedRatio
+		}
+		for _, state := range sample.GPU.SWRequestedState {
+			s.swReqResidency[state.SWReqState] += residency(state.Used(), state.UsedRatio, elapsed)
+			s.swReqRatio[state.SWReqState] += state.UsedRatio
+		}
+	case *types.TasksSample:
+		s.samples[string(powermetrics.Tasks)]++
+		s.hasTasks = true
+		all := sample.AllTasks
+		s.cpuTime += all.CPUTime().Seconds()
+		s.cpuUsage = all.CPUTimeMSPerS / 1000
+		s.wakeups["interrupt"] += all.IntrWakeups
+		s.wakeups["idle"] += all.IdleWakeups
+		s.diskIO["read"] += all.DiskIOBytesRead
+		s.diskIO["write"] += all.DiskIOBytesWritten
+		s.networkIO["receive"] += all.BytesReceived
+		s.networkIO["transmit"] += all.BytesSent
+		s.networkPackets["receive"] += all.PacketsReceived
+		s.networkPackets["transmit"] += all.PacketsSent
+		s.energyImpact = all.EnergyImpactPerS
+	case *types.ThermalSample:
+		s.samples[string(powermetrics.Thermal)]++
+		s.thermalPressure = sample.ThermalPressure
+	}
+}
+
+// residency returns the time spent in a state in seconds, falling back to
+// the ratio when powermetrics did not report the time.
+func residency(used time.Duration, ratio, elapsed float64) float64 {
+	if used > 0 {
+		return used.Seconds()
+	}
+	return ratio * elapsed
+}
+
+func (b *Bridge) callback(_ context.Context, o metric.Observer) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &b.state
+
+	for sampler, n := range s.samples {
+		o.ObserveInt64(b.samples, n, metric.WithAttributes(SamplerKey.String(sampler)))
+	}
+
+	if s.hasGPU {
+		o.ObserveFloat64(b.gpuEnergy, s.gpuEnergy)
+		if s.hasPower {
+			o.ObserveFloat64(b.gpuPower, s.gpuPower)
+		}
+		o.ObserveFloat64(b.gpuFrequency, s.gpuFrequency)
+		o.ObserveFloat64(b.gpuIdleRatio, s.gpuIdleRatio)
+		o.ObserveFloat64(b.gpuIdleTime, s.gpuIdleTime)
+		for freq, v := range s.dvfmResidency {
+			attrs := metric.WithAttributes(DVFMFrequencyKey.Int64(freq))
+			o.ObserveFloat64(b.dvfmResidency, v, attrs)
+			o.ObserveFloat64(b.dvfmRatio, s.dvfmRatio[freq], attrs)
+		}
+		for state, v := range s.swResidency {
+			attrs := metric.WithAttributes(SWStateKey.String(state))
+			o.ObserveFloat64(b.swResidency, v, attrs)
+			o.ObserveFloat64(b.swRatio, s.swRatio[state], attrs)
+		}
+		for state, v := range s.swReqResidency {
+			attrs := metric.WithAttributes(SWRequestedStateKey.String(state))
+			o.ObserveFloat64(b.swReqResidency, v, attrs)
+			o.ObserveFloat64(b.swReqRatio, s.swReqRatio[state], attrs)
+		}
+	}
+
+	if s.hasTasks {
+		o.ObserveFloat64(b.cpuTime, s.cpuTime)
+		o.ObserveFloat64(b.cpuUsage, s.cpuUsage)
+		for kind, n := range s.wakeups {
+			o.ObserveInt64(b.wakeups, n, metric.WithAttributes(WakeupKindKey.String(kind)))
+		}
+		for direction, n := range s.diskIO {
+			o.ObserveInt64(b.diskIO, n, metric.WithAttributes(DirectionKey.String(direction)))
+		}
+		for direction, n := range s.networkIO {
+			o.ObserveInt64(b.networkIO, n, metric.WithAttributes(DirectionKey.String(direction)))
+		}
+		for direction, n := range s.networkPackets {
+			o.ObserveInt64(b.networkPackets, n, metric.WithAttributes(DirectionKey.String(direction)))
+		}
+		o.ObserveFloat64(b.energyImpact, s.energyImpact)
+	}
+
+	if s.thermalPressure != "" {
+		for _, level := range types.ThermalPressureLevels {
+			var at int64
+			if level == s.thermalPressure {
+				at = 1
+			}
+			o.ObserveInt64(b.thermalPressure, at, metric.WithAttributes(ThermalPressureLevelKey.String(level)))
+		}
+		o.ObserveInt64(b.thermalLevel, int64(types.ThermalPressureRank(s.thermalPressure)))
+	}
+	return nil
+}
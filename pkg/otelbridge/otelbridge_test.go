@@ -0,0 +1,148 @@
+package otelbridge
+
+import (
+	"context"
+	"math"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func collect(t *testing.T, reader sdkmetric.Reader) (metricdata.ResourceMetrics, map[string]metricdata.Metrics) {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	metrics := make(map[string]metricdata.Metrics)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+	return rm, metrics
+}
+
+func TestBridge(t *testing.T) {
+	gpuSamples := sampletest.Read(t, sampletest.GPUPower)
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(Resource(gpuSamples[0], ResourceOptions{
+			Attributes: []attribute.KeyValue{attribute.String("service.name", "test")},
+		})),
+	)
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+
+	bridge, err := New(provider.Meter(ScopeName))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// Nothing is reported before the first sample.
+	if _, metrics := collect(t, reader); len(metrics) != 0 {
+		t.Errorf("Expected no metrics before the first sample, got %d", len(metrics))
+	}
+
+	for _, sample := range gpuSamples {
+		bridge.Observe(sample)
+	}
+	for _, sample := range sampletest.Read(t, sampletest.Tasks) {
+		bridge.Observe(sample)
+	}
+	bridge.Observe(&types.ThermalSample{ThermalPressure: "Heavy"})
+
+	rm, metrics := collect(t, reader)
+	for key, want := range map[attribute.Key]string{
+		DefaultHWModelKey: "Mac16,8",
+		DefaultOSBuildKey: "24F74",
+		"service.name":    "test",
+	} {
+		if got, ok := rm.Resource.Set().Value(key); !ok || got.AsString() != want {
+			t.Errorf("Expected resource attribute %s=%s, got %v", key, want, got.Emit())
+		}
+	}
+
+	energy, ok := metrics["powermetrics.gpu.energy"].Data.(metricdata.Sum[float64])
+	if !ok || !energy.IsMonotonic || energy.Temporality != metricdata.CumulativeTemporality {
+		t.Fatalf("Expected a cumulative monotonic energy sum, got %T", metrics["powermetrics.gpu.energy"].Data)
+	}
+	if got := energy.DataPoints[0].Value; math.Abs(got-1.186) > 1e-9 {
+		t.Errorf("Expected 1.186 J, got %v", got)
+	}
+	if unit := metrics["powermetrics.gpu.energy"].Unit; unit != "J" {
+		t.Errorf("Expected unit J, got %q", unit)
+	}
+
+	if _, ok := metrics["powermetrics.gpu.frequency"].Data.(metricdata.Gauge[float64]); !ok {
+		t.Errorf("Expected a frequency gauge, got %T", metrics["powermetrics.gpu.frequency"].Data)
+	}
+
+	residency, ok := metrics["powermetrics.gpu.dvfm.residency"].Data.(metricdata.Sum[float64])
+	if !ok {
+		t.Fatalf("Expected a DVFM residency sum, got %T", metrics["powermetrics.gpu.dvfm.residency"].Data)
+	}
+	var found bool
+	for _, point := range residency.DataPoints {
+		if freq, ok := point.Attributes.Value(DVFMFrequencyKey); ok && freq.AsInt64() == 338 {
+			found = point.Value > 0
+		}
+	}
+	if !found {
+		t.Errorf("Expected residency at 338 MHz, got %+v", residency.DataPoints)
+	}
+
+	samples := metrics["powermetrics.samples"].Data.(metricdata.Sum[int64])
+	counts := make(map[string]int64)
+	for _, point := range samples.DataPoints {
+		sampler, _ := point.Attributes.Value(SamplerKey)
+		counts[sampler.AsString()] = point.Value
+	}
+	if counts["gpu_power"] != 5 || counts["tasks"] != 3 || counts["thermal"] != 1 {
+		t.Errorf("Expected 5 GPU, 3 tasks and 1 thermal samples, got %v", counts)
+	}
+
+	if cpu := metrics["powermetrics.tasks.cpu_time"].Data.(metricdata.Sum[float64]); math.Abs(cpu.DataPoints[0].Value-1.2095) > 1e-9 {
+		t.Errorf("Expected 1.2095 s of CPU time, got %v", cpu.DataPoints[0].Value)
+	}
+	impact := metrics["powermetrics.tasks.energy_impact_per_second"].Data.(metricdata.Gauge[float64])
+	if impact.DataPoints[0].Value != 46.75 {
+		t.Errorf("Expected an energy impact of 46.75 per second, got %v", impact.DataPoints[0].Value)
+	}
+	level := metrics["powermetrics.thermal.pressure_level"].Data.(metricdata.Gauge[int64])
+	if level.DataPoints[0].Value != 2 {
+		t.Errorf("Expected thermal pressure level 2, got %d", level.DataPoints[0].Value)
+	}
+
+	if err := bridge.Unregister(); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+}
+
+func TestDetector(t *testing.T) {
+	xmlData, err := os.ReadFile("../../testdata/gpu_power.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+	detector := Detector{
+		ResourceOptions: ResourceOptions{HWModelKey: "hw.model"},
+		Powermetrics:    powermetrics.NewWithRunner(&powermetrics.MockCommandRunner{Output: xmlData}),
+	}
+	res, err := detector.Detect(context.Background())
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	if hw, ok := res.Set().Value("hw.model"); !ok || hw.AsString() == "" {
+		t.Errorf("Expected the hw.model attribute, got %v", res.Attributes())
+	}
+	if _, ok := res.Set().Value(DefaultOSBuildKey); !ok {
+		t.Errorf("Expected the %s attribute, got %v", DefaultOSBuildKey, res.Attributes())
+	}
+}
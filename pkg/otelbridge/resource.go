@@ -0,0 +1,79 @@
+package otelbridge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Default resource attribute keys, from the OpenTelemetry semantic
+// conventions.
+const (
+	DefaultHWModelKey = attribute.Key("host.type")
+	DefaultOSBuildKey = attribute.Key("os.build_id")
+)
+
+// ResourceOptions selects the resource attributes derived from a sample.
+type ResourceOptions struct {
+	// HWModelKey is the key of the hw_model value. Empty means
+	// DefaultHWModelKey.
+	HWModelKey attribute.Key
+	// OSBuildKey is the key of the kern_osversion value. Empty means
+	// DefaultOSBuildKey.
+	OSBuildKey attribute.Key
+	// Attributes are added to the derived attributes.
+	Attributes []attribute.KeyValue
+}
+
+// Resource returns a resource describing the machine sample was taken on.
+// Empty values are omitted.
+func Resource(sample types.Sample, opts ResourceOptions) *resource.Resource {
+	if opts.HWModelKey == "" {
+		opts.HWModelKey = DefaultHWModelKey
+	}
+	if opts.OSBuildKey == "" {
+		opts.OSBuildKey = DefaultOSBuildKey
+	}
+
+	attrs := append([]attribute.KeyValue(nil), opts.Attributes...)
+	if hw := sample.GetHWModel(); hw != "" {
+		attrs = append(attrs, opts.HWModelKey.String(hw))
+	}
+	if build := sample.GetKernOSVer(); build != "" {
+		attrs = append(attrs, opts.OSBuildKey.String(build))
+	}
+	return resource.NewSchemaless(attrs...)
+}
+
+// Detector is a resource.Detector that takes a single powermetrics sample
+// to describe the machine.
+type Detector struct {
+	ResourceOptions
+	// Powermetrics is the instance used for sampling. Nil means
+	// powermetrics.New().
+	Powermetrics *powermetrics.Powermetrics
+}
+
+// Detect implements resource.Detector.
+func (d Detector) Detect(ctx context.Context) (*resource.Resource, error) {
+	pm := d.Powermetrics
+	if pm == nil {
+		pm = powermetrics.New()
+	}
+	config := powermetrics.DefaultConfig().GPU()
+	config.SampleRate = 100 * time.Millisecond
+	result, err := pm.Collect(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect the hardware model: %w", err)
+	}
+	if result.PlistData == nil {
+		return nil, fmt.Errorf("failed to detect the hardware model: no sample")
+	}
+	return Resource(result.PlistData, d.ResourceOptions), nil
+}
@@ -0,0 +1,202 @@
+package parquetfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/jsonfeed"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// encodeJSON returns the records of samples, as a comparable form.
+func encodeJSON(t *testing.T, samples []types.Sample) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := jsonfeed.NewEncoder(&buf)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	return buf.String()
+}
+
+func readFile(t *testing.T, data []byte) []types.Sample {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	var samples []types.Sample
+	for {
+		sample, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return samples
+		}
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		samples = append(samples, sample)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	samples := sampletest.Samples(t)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Options{RowGroupRows: 2})
+	for _, sample := range samples {
+		if err := w.Write(sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got := readFile(t, buf.Bytes())
+	if len(got) != len(samples) {
+		t.Fatalf("Expected %d samples, got %d", len(samples), len(got))
+	}
+	if encodeJSON(t, got) != encodeJSON(t, samples) {
+		t.Error("Expected samples to read back identically")
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if want := (len(samples) + 1) / 2; len(f.RowGroups()) != want {
+		t.Errorf("Expected %d row groups, got %d", want, len(f.RowGroups()))
+	}
+	for _, tt := range []struct {
+		path []string
+		want string
+	}{
+		{[]string{"timestamp"}, "TIMESTAMP(isAdjustedToUTC=true,unit=NANOS)"},
+		{[]string{"kern_boottime"}, "TIMESTAMP(isAdjustedToUTC=true,unit=MILLIS)"},
+		{[]string{"gpu", "dvfm_states"}, "LIST"},
+	} {
+		node := lookup(f.Schema(), tt.path)
+		if node == nil {
+			t.Errorf("Expected a %v column", tt.path)
+			continue
+		}
+		if got := node.Type().LogicalType().String(); got != tt.want {
+			t.Errorf("Expected %v to be %s, got %s", tt.path, tt.want, got)
+		}
+	}
+}
+
+// lookup returns the node at path, which unlike Schema.Lookup may be a group.
+func lookup(node parquet.Node, path []string) parquet.Node {
+	for _, name := range path {
+		var next parquet.Node
+		for _, field := range node.Fields() {
+			if field.Name() == name {
+				next = field
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+func TestFlushInterval(t *testing.T) {
+	samples := sampletest.Read(t, sampletest.GPUPower)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Options{FlushInterval: time.Minute})
+	now := time.Date(2025, 7, 6, 5, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	for _, sample := range samples {
+		if err := w.Write(sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		now = now.Add(40 * time.Second)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	// Row groups end on their third row, 80 seconds after the first.
+	if want := (len(samples) + 2) / 3; len(f.RowGroups()) != want {
+		t.Errorf("Expected %d row groups, got %d", want, len(f.RowGroups()))
+	}
+}
+
+func TestRowGroupBytes(t *testing.T) {
+	samples := sampletest.Read(t, sampletest.GPUPower)
+	tasks := &types.TasksSample{}
+	for pid := range 100 {
+		tasks.Tasks = append(tasks.Tasks, types.TaskInfo{PID: pid, Name: "worker"})
+	}
+	samples = append(samples, tasks)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Options{RowGroupBytes: 2000})
+	for _, sample := range samples {
+		if err := w.Write(sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	// GPU rows hold about 1 kB of values, so row groups end on their second
+	// row, and the row of 100 tasks ends the last one.
+	var rows []int64
+	for _, group := range f.RowGroups() {
+		rows = append(rows, group.NumRows())
+	}
+	if want := []int64{2, 2, 2}; !slices.Equal(rows, want) {
+		t.Errorf("Expected row groups of %v rows, got %v", want, rows)
+	}
+	if got := readFile(t, buf.Bytes()); len(got) != len(samples) {
+		t.Errorf("Expected %d samples, got %d", len(samples), len(got))
+	}
+}
+
+func TestRun(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	pm := powermetrics.NewWithRunner(&powermetrics.MockCommandRunner{Output: data})
+	stream, err := pm.Stream(t.Context(), &powermetrics.Config{Samplers: []powermetrics.Sampler{powermetrics.GPUPower}})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Options{})
+	if err := w.Run(stream); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := sampletest.Read(t, sampletest.GPUPower)
+	if got := readFile(t, buf.Bytes()); len(got) != len(want) {
+		t.Errorf("Expected %d samples, got %d", len(want), len(got))
+	}
+	if err := w.Write(want[0]); err == nil {
+		t.Error("Expected Write after Run to fail")
+	}
+}
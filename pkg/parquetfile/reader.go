@@ -0,0 +1,65 @@
+package parquetfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Reader reads samples from a Parquet file written by Writer. Files written
+// by other tools are read as long as their columns match Row by name;
+// missing columns read as zero values.
+type Reader struct {
+	r    *parquet.GenericReader[Row]
+	rows []Row
+	next int
+	n    int
+	read int64
+}
+
+// NewReader returns a reader of the Parquet file of size bytes in r.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	f, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: parquet.NewGenericReader[Row](f), rows: make([]Row, 256)}, nil
+}
+
+// NumRows returns the number of rows of the file.
+func (r *Reader) NumRows() int64 {
+	return r.r.NumRows()
+}
+
+// Read returns the next sample, or io.EOF after the last one.
+func (r *Reader) Read() (types.Sample, error) {
+	if r.next == r.n {
+		clear(r.rows)
+		n, err := r.r.Read(r.rows)
+		if n == 0 {
+			if err == nil || errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		r.next, r.n = 0, n
+	}
+	row := &r.rows[r.next]
+	r.next++
+	r.read++
+	sample, err := row.Sample()
+	if err != nil {
+		return nil, fmt.Errorf("row %d: %w", r.read-1, err)
+	}
+	return sample, nil
+}
+
+// Close releases the resources of the reader. It does not close the
+// underlying file.
+func (r *Reader) Close() error {
+	return r.r.Close()
+}
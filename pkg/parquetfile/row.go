@@ -0,0 +1,258 @@
+// Package parquetfile writes powermetrics samples to Apache Parquet files and
+// reads them back.
+//
+// Every sample is a row of the schema derived from Row: the header columns
+// are shared by all samplers, and each sampler fills its own optional group,
+// as in the records of pkg/jsonfeed. DVFM and software states are nested
+// lists, so that they survive as arrays of structs in DuckDB and pandas:
+//
+//	SELECT timestamp, s.frequency_mhz, s.used_ratio
+//	FROM (SELECT timestamp, unnest(gpu.dvfm_states) AS s FROM 'gpu.parquet');
+package parquetfile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Sampler names of the sampler column
+const (
+	SamplerGPUPower = "gpu_power"
+	SamplerTasks    = "tasks"
+	SamplerBattery  = "battery"
+	SamplerThermal  = "thermal"
+)
+
+// Row is a sample as stored in a Parquet file. Times are Unix times in the
+// unit of their TIMESTAMP column, and zero is written as null.
+type Row struct {
+	Sampler        string `parquet:"sampler,dict"`
+	TimestampNS    int64  `parquet:"timestamp,optional,timestamp(nanosecond)"`
+	ElapsedNS      int64  `parquet:"elapsed_ns"`
+	HWModel        string `parquet:"hw_model,dict"`
+	KernOSVersion  string `parquet:"kern_osversion,dict"`
+	KernBootArgs   string `parquet:"kern_bootargs,dict"`
+	KernBootTimeMS int64  `parquet:"kern_boottime,optional,timestamp(millisecond)"`
+	IsDelta        bool   `parquet:"is_delta"`
+
+	GPU      *GPU     `parquet:"gpu,optional"`
+	AllTasks *Task    `parquet:"all_tasks,optional"`
+	Tasks    []Task   `parquet:"tasks,list"`
+	Battery  *Battery `parquet:"battery,optional"`
+	Thermal  *Thermal `parquet:"thermal,optional"`
+}
+
+// GPU holds the columns of gpu_power samples.
+type GPU struct {
+	FrequencyMHz      float64     `parquet:"frequency_mhz"`
+	IdleNS            int64       `parquet:"idle_ns"`
+	IdleRatio         float64     `parquet:"idle_ratio"`
+	EnergyMJ          *int64      `parquet:"energy_mj,optional"`
+	DVFMStates        []DVFMState `parquet:"dvfm_states,list"`
+	SWRequestedStates []SWState   `parquet:"sw_requested_states,list"`
+	SWStates          []SWState   `parquet:"sw_states,list"`
+}
+
+// DVFMState is an element of the dvfm_states list.
+type DVFMState struct {
+	FrequencyMHz int64   `parquet:"frequency_mhz"`
+	UsedNS       int64   `parquet:"used_ns"`
+	UsedRatio    float64 `parquet:"used_ratio"`
+}
+
+// SWState is an element of the sw_requested_states and sw_states lists.
+type SWState struct {
+	State     string  `parquet:"state,dict"`
+	UsedNS    int64   `parquet:"used_ns"`
+	UsedRatio float64 `parquet:"used_ratio"`
+}
+
+// Task holds the columns of a task of tasks samples.
+type Task struct {
+	PID                  int32   `parquet:"pid"`
+	Name                 string  `parquet:"name,dict"`
+	IntervalNS           int64   `parquet:"interval_ns"`
+	CPUTimeNS            int64   `parquet:"cpu_time_ns"`
+	CPUTimeMSPerS        float64 `parquet:"cpu_time_ms_per_s"`
+	CPUTimeUserlandRatio float64 `parquet:"cpu_time_userland_ratio"`
+	IntrWakeups          int64   `parquet:"interrupt_wakeups"`
+	IntrWakeupsPerS      float64 `parquet:"interrupt_wakeups_per_s"`
+	IdleWakeups          int64   `parquet:"idle_wakeups"`
+	IdleWakeupsPerS      float64 `parquet:"idle_wakeups_per_s"`
+	DiskIOBytesRead      int64   `parquet:"disk_read_bytes"`
+	DiskIOBytesWritten   int64   `parquet:"disk_written_bytes"`
+	PacketsReceived      int64   `parquet:"packets_received"`
+	PacketsSent          int64   `parquet:"packets_sent"`
+	BytesReceived        int64   `parquet:"bytes_received"`
+	BytesSent            int64   `parquet:"bytes_sent"`
+	EnergyImpact         float64 `parquet:"energy_impact"`
+	EnergyImpactPerS     float64 `parquet:"energy_impact_per_s"`
+}
+
+// Battery holds the columns of battery samples.
+type Battery struct {
+	PercentCharge int32 `parquet:"percent_charge"`
+}
+
+// Thermal holds the columns of thermal samples.
+type Thermal struct {
+	Pressure string `parquet:"pressure,dict"`
+}
+
+// FromSample returns the row of sample.
+func FromSample(sample types.Sample) (*Row, error) {
+	r := &Row{
+		ElapsedNS:      sample.GetElapsedNS(),
+		HWModel:        sample.GetHWModel(),
+		KernOSVersion:  sample.GetKernOSVer(),
+		KernBootArgs:   sample.GetKernBootArgs(),
+		KernBootTimeMS: sample.GetKernBootTime() * 1000,
+		IsDelta:        sample.GetIsDelta(),
+	}
+	if ts := sample.GetTimestamp(); !ts.IsZero() {
+		r.TimestampNS = ts.UnixNano()
+	}
+
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		r.Sampler = SamplerGPUPower
+		gpu := &GPU{
+			FrequencyMHz: s.GPU.FreqHz,
+			IdleNS:       s.GPU.IdleNS,
+			IdleRatio:    s.GPU.IdleRatio,
+			EnergyMJ:     s.GPU.GPUEnergy,
+		}
+		for _, state := range s.GPU.DVFMStates {
+			gpu.DVFMStates = append(gpu.DVFMStates, DVFMState{state.Freq, state.UsedNS, state.UsedRatio})
+		}
+		for _, state := range s.GPU.SWRequestedState {
+			gpu.SWRequestedStates = append(gpu.SWRequestedStates, SWState{state.SWReqState, state.UsedNS, state.UsedRatio})
+		}
+		for _, state := range s.GPU.SWState {
+			gpu.SWStates = append(gpu.SWStates, SWState{state.SWState, state.UsedNS, state.UsedRatio})
+		}
+		r.GPU = gpu
+	case *types.TasksSample:
+		r.Sampler = SamplerTasks
+		all := fromTask(&s.AllTasks)
+		r.AllTasks = &all
+		for i := range s.Tasks {
+			r.Tasks = append(r.Tasks, fromTask(&s.Tasks[i]))
+		}
+	case *types.BatterySample:
+		r.Sampler = SamplerBattery
+		r.Battery = &Battery{PercentCharge: int32(s.Battery.PercentCharge)}
+	case *types.ThermalSample:
+		r.Sampler = SamplerThermal
+		r.Thermal = &Thermal{Pressure: s.ThermalPressure}
+	default:
+		return nil, fmt.Errorf("unsupported sample type %T", sample)
+	}
+	return r, nil
+}
+
+func fromTask(t *types.TaskInfo) Task {
+	return Task{
+		PID:                  int32(t.PID),
+		Name:                 t.Name,
+		IntervalNS:           t.IntervalNS,
+		CPUTimeNS:            t.CPUTimeNS,
+		CPUTimeMSPerS:        t.CPUTimeMSPerS,
+		CPUTimeUserlandRatio: t.CPUTimeUserlandRatio,
+		IntrWakeups:          t.IntrWakeups,
+		IntrWakeupsPerS:      t.IntrWakeupsPerS,
+		IdleWakeups:          t.IdleWakeups,
+		IdleWakeupsPerS:      t.IdleWakeupsPerS,
+		DiskIOBytesRead:      t.DiskIOBytesRead,
+		DiskIOBytesWritten:   t.DiskIOBytesWritten,
+		PacketsReceived:      t.PacketsReceived,
+		PacketsSent:          t.PacketsSent,
+		BytesReceived:        t.BytesReceived,
+		BytesSent:            t.BytesSent,
+		EnergyImpact:         t.EnergyImpact,
+		EnergyImpactPerS:     t.EnergyImpactPerS,
+	}
+}
+
+func (t *Task) info() types.TaskInfo {
+	return types.TaskInfo{
+		PID:                  int(t.PID),
+		Name:                 t.Name,
+		IntervalNS:           t.IntervalNS,
+		CPUTimeNS:            t.CPUTimeNS,
+		CPUTimeMSPerS:        t.CPUTimeMSPerS,
+		CPUTimeUserlandRatio: t.CPUTimeUserlandRatio,
+		IntrWakeups:          t.IntrWakeups,
+		IntrWakeupsPerS:      t.IntrWakeupsPerS,
+		IdleWakeups:          t.IdleWakeups,
+		IdleWakeupsPerS:      t.IdleWakeupsPerS,
+		DiskIOBytesRead:      t.DiskIOBytesRead,
+		DiskIOBytesWritten:   t.DiskIOBytesWritten,
+		PacketsReceived:      t.PacketsReceived,
+		PacketsSent:          t.PacketsSent,
+		BytesReceived:        t.BytesReceived,
+		BytesSent:            t.BytesSent,
+		EnergyImpact:         t.EnergyImpact,
+		EnergyImpactPerS:     t.EnergyImpactPerS,
+	}
+}
+
+// Sample converts the row back to a sample.
+func (r *Row) Sample() (types.Sample, error) {
+	base := types.BaseSample{
+		IsDelta:      r.IsDelta,
+		ElapsedNS:    r.ElapsedNS,
+		HWModel:      r.HWModel,
+		KernOSVer:    r.KernOSVersion,
+		KernBootArgs: r.KernBootArgs,
+		KernBootTime: r.KernBootTimeMS / 1000,
+	}
+	if r.TimestampNS != 0 {
+		base.Timestamp = time.Unix(0, r.TimestampNS).UTC()
+	}
+
+	switch r.Sampler {
+	case SamplerGPUPower:
+		if r.GPU == nil {
+			return nil, fmt.Errorf("gpu_power row without gpu")
+		}
+		s := &types.GPUPowerSample{BaseSample: base}
+		s.GPU.FreqHz = r.GPU.FrequencyMHz
+		s.GPU.IdleNS = r.GPU.IdleNS
+		s.GPU.IdleRatio = r.GPU.IdleRatio
+		s.GPU.GPUEnergy = r.GPU.EnergyMJ
+		for _, state := range r.GPU.DVFMStates {
+			s.GPU.DVFMStates = append(s.GPU.DVFMStates, types.DVFMState{Freq: state.FrequencyMHz, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio})
+		}
+		for _, state := range r.GPU.SWRequestedStates {
+			s.GPU.SWRequestedState = append(s.GPU.SWRequestedState, types.SWReqState{SWReqState: state.State, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio})
+		}
+		for _, state := range r.GPU.SWStates {
+			s.GPU.SWState = append(s.GPU.SWState, types.SWState{SWState: state.State, UsedNS: state.UsedNS, UsedRatio: state.UsedRatio})
+		}
+		return s, nil
+	case SamplerTasks:
+		if r.AllTasks == nil {
+			return nil, fmt.Errorf("tasks row without all_tasks")
+		}
+		s := &types.TasksSample{BaseSample: base, AllTasks: r.AllTasks.info()}
+		for i := range r.Tasks {
+			s.Tasks = append(s.Tasks, r.Tasks[i].info())
+		}
+		return s, nil
+	case SamplerBattery:
+		if r.Battery == nil {
+			return nil, fmt.Errorf("battery row without battery")
+		}
+		return &types.BatterySample{BaseSample: base, Battery: types.BatteryInfo{PercentCharge: int(r.Battery.PercentCharge)}}, nil
+	case SamplerThermal:
+		if r.Thermal == nil {
+			return nil, fmt.Errorf("thermal row without thermal")
+		}
+		return &types.ThermalSample{BaseSample: base, ThermalPressure: r.Thermal.Pressure}, nil
+	default:
+		return nil, fmt.Errorf("unsupported sampler %q", r.Sampler)
+	}
+}
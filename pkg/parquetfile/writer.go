@@ -0,0 +1,194 @@
+package parquetfile
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Writer defaults
+const (
+	DefaultRowGroupRows  = 10000
+	DefaultRowGroupBytes = 64 << 20
+	DefaultFlushInterval = 10 * time.Minute
+)
+
+// Options configures a Writer.
+type Options struct {
+	// RowGroupRows is the number of rows that ends a row group. Zero means
+	// DefaultRowGroupRows.
+	RowGroupRows int
+	// RowGroupBytes is the size of the values of a row group, before
+	// encoding and compression, that ends it. Rows of tasks samples hold
+	// every process, so a row count alone does not bound the memory a row
+	// group takes. Zero means DefaultRowGroupBytes.
+	RowGroupBytes int64
+	// FlushInterval is the age of the first row of a row group that ends
+	// it, so that slow streams do not hold rows in memory for hours. Zero
+	// means DefaultFlushInterval, and a negative value disables it.
+	FlushInterval time.Duration
+	// Compression is the codec of the column chunks. Nil means Snappy.
+	Compression compress.Codec
+}
+
+// Writer writes samples as rows of a Parquet file. The file is only
+// readable once the writer is closed, which writes its footer. It is safe
+// for concurrent use.
+type Writer struct {
+	opts Options
+	now  func() time.Time
+
+	mu         sync.Mutex
+	w          *parquet.GenericWriter[Row]
+	rows       int
+	bytes      int64
+	groupStart time.Time
+	closed     bool
+}
+
+// NewWriter returns a writer writing a Parquet file to w.
+func NewWriter(w io.Writer, opts Options) *Writer {
+	if opts.RowGroupRows <= 0 {
+		opts.RowGroupRows = DefaultRowGroupRows
+	}
+	if opts.RowGroupBytes <= 0 {
+		opts.RowGroupBytes = DefaultRowGroupBytes
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.Compression == nil {
+		opts.Compression = &parquet.Snappy
+	}
+	pw := parquet.NewGenericWriter[Row](w,
+		parquet.Compression(opts.Compression),
+		parquet.CreatedBy("powermetrics", "", ""),
+	)
+	return &Writer{opts: opts, now: time.Now, w: pw}
+}
+
+// Write adds the row of sample to the current row group, and ends the row
+// group once it holds RowGroupRows rows or RowGroupBytes bytes, or its
+// first row is FlushInterval old.
+func (w *Writer) Write(sample types.Sample) error {
+	row, err := FromSample(sample)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fmt.Errorf("parquet writer is closed")
+	}
+	if _, err := w.w.Write([]Row{*row}); err != nil {
+		return err
+	}
+	w.rows++
+	w.bytes += rowSize(w.w.Schema().Deconstruct(nil, row))
+	if w.rows == 1 {
+		w.groupStart = w.now()
+	}
+	if w.rows >= w.opts.RowGroupRows || w.bytes >= w.opts.RowGroupBytes || w.due() {
+		return w.flush()
+	}
+	return nil
+}
+
+// Flush ends the current row group, if it holds rows.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	return w.flush()
+}
+
+// Close ends the last row group and writes the footer. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.w.Close()
+}
+
+// Run writes every sample of stream until the stream ends, ending row
+// groups that reach FlushInterval even while no samples arrive, and closes
+// the writer. The stream's samples must not be consumed elsewhere.
+func (w *Writer) Run(stream *powermetrics.Stream) error {
+	var tick <-chan time.Time
+	if w.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(w.opts.FlushInterval / 4)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	samples := stream.Samples()
+	for {
+		select {
+		case sample, ok := <-samples:
+			if !ok {
+				return w.Close()
+			}
+			if err := w.Write(sample); err != nil {
+				return err
+			}
+		case <-tick:
+			w.mu.Lock()
+			var err error
+			if !w.closed && w.due() {
+				err = w.flush()
+			}
+			w.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// due reports whether the current row group has reached FlushInterval.
+func (w *Writer) due() bool {
+	return w.rows > 0 && w.opts.FlushInterval > 0 && w.now().Sub(w.groupStart) >= w.opts.FlushInterval
+}
+
+func (w *Writer) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	w.rows, w.bytes = 0, 0
+	return w.w.Flush()
+}
+
+// rowSize returns the size of the values of a row, before encoding.
+func rowSize(row parquet.Row) int64 {
+	var size int64
+	for _, v := range row {
+		switch {
+		case v.IsNull():
+		case v.Kind() == parquet.Boolean:
+			size++
+		case v.Kind() == parquet.Int32 || v.Kind() == parquet.Float:
+			size += 4
+		case v.Kind() == parquet.Int64 || v.Kind() == parquet.Double:
+			size += 8
+		case v.Kind() == parquet.Int96:
+			size += 12
+		default:
+			size += int64(len(v.ByteArray()))
+		}
+	}
+	return size
+}
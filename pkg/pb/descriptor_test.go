@@ -0,0 +1,208 @@
+package pb
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+)
+
+var protoTokens = regexp.MustCompile(`"[^"]*"|[{};=]|[^\s{};="]+`)
+
+var scalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+}
+
+// parseProto builds the descriptor of a .proto file using the subset of the
+// language powermetrics.proto is written in: messages of scalar and message
+// fields, optional and repeated labels and oneofs.
+func parseProto(t *testing.T, path string) *descriptorpb.FileDescriptorProto {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		tokens = append(tokens, protoTokens.FindAllString(line, -1)...)
+	}
+	next := func(want ...string) string {
+		t.Helper()
+		if len(tokens) == 0 {
+			t.Fatalf("Unexpected end of %s", path)
+		}
+		tok := tokens[0]
+		tokens = tokens[1:]
+		if len(want) > 0 && tok != want[0] {
+			t.Fatalf("Expected %q in %s, got %q", want[0], path, tok)
+		}
+		return tok
+	}
+
+	file := &descriptorpb.FileDescriptorProto{Name: proto.String("powermetrics.proto")}
+	for len(tokens) > 0 {
+		switch tok := next(); tok {
+		case "syntax":
+			next("=")
+			file.Syntax = proto.String(strings.Trim(next(), `"`))
+			next(";")
+		case "package":
+			file.Package = proto.String(next())
+			next(";")
+		case "option":
+			next()
+			next("=")
+			next()
+			next(";")
+		case "message":
+			file.MessageType = append(file.MessageType, parseMessage(t, file.GetPackage(), next))
+		default:
+			t.Fatalf("Unexpected %q in %s", tok, path)
+		}
+	}
+	return file
+}
+
+func parseMessage(t *testing.T, pkg string, next func(...string) string) *descriptorpb.DescriptorProto {
+	t.Helper()
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(next())}
+	var synthetic []*descriptorpb.OneofDescriptorProto
+	next("{")
+	oneof := -1
+	for {
+		tok := next()
+		switch tok {
+		case "}":
+			if oneof < 0 {
+				for _, field := range msg.Field {
+					if field.GetProto3Optional() {
+						*field.OneofIndex += int32(len(msg.OneofDecl))
+					}
+				}
+				msg.OneofDecl = append(msg.OneofDecl, synthetic...)
+				return msg
+			}
+			oneof = -1
+			continue
+		case "oneof":
+			msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(next())})
+			oneof = len(msg.OneofDecl) - 1
+			next("{")
+			continue
+		}
+
+		field := &descriptorpb.FieldDescriptorProto{Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+		switch tok {
+		case "optional":
+			field.Proto3Optional = proto.Bool(true)
+			tok = next()
+		case "repeated":
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			tok = next()
+		}
+		if typ, ok := scalarTypes[tok]; ok {
+			field.Type = typ.Enum()
+		} else {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String("." + pkg + "." + tok)
+		}
+		field.Name = proto.String(next())
+		next("=")
+		number, err := strconv.Atoi(next())
+		if err != nil {
+			t.Fatalf("Invalid number of field %s.%s: %v", msg.GetName(), field.GetName(), err)
+		}
+		field.Number = proto.Int32(int32(number))
+		next(";")
+		switch {
+		case oneof >= 0:
+			field.OneofIndex = proto.Int32(int32(oneof))
+		case field.GetProto3Optional():
+			// Optional proto3 fields are in a oneof of their own, declared
+			// after the real oneofs and numbered once they are known.
+			synthetic = append(synthetic, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + field.GetName())})
+			field.OneofIndex = proto.Int32(int32(len(synthetic) - 1))
+		}
+		msg.Field = append(msg.Field, field)
+	}
+}
+
+func TestDynamicMessage(t *testing.T) {
+	file, err := protodesc.NewFile(parseProto(t, "powermetrics.proto"), nil)
+	if err != nil {
+		t.Fatalf("Invalid descriptor of powermetrics.proto: %v", err)
+	}
+	desc := file.Messages().ByName("Sample")
+	if desc == nil {
+		t.Fatal("Expected powermetrics.proto to define Sample")
+	}
+
+	for i, sample := range sampletest.Samples(t) {
+		want, err := Marshal(sample)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		dynamic := dynamicpb.NewMessage(desc)
+		if err := proto.Unmarshal(want, dynamic); err != nil {
+			t.Fatalf("Expected sample %d to unmarshal into Sample: %v", i, err)
+		}
+		if path := unknownFields(dynamic, string(desc.Name())); path != "" {
+			t.Errorf("Expected sample %d to only use fields of powermetrics.proto, got unknown fields in %s", i, path)
+		}
+		if dynamic.WhichOneof(desc.Oneofs().ByName("sampler")) == nil {
+			t.Errorf("Expected sample %d to set a sampler", i)
+		}
+
+		msg, err := proto.MarshalOptions{Deterministic: true}.Marshal(dynamic)
+		if err != nil {
+			t.Fatalf("Marshal of dynamic sample %d failed: %v", i, err)
+		}
+		decoded, err := Unmarshal(msg)
+		if err != nil {
+			t.Fatalf("Unmarshal of dynamic sample %d failed: %v", i, err)
+		}
+		got, _ := Marshal(decoded)
+		if !bytes.Equal(got, want) {
+			t.Errorf("Expected sample %d to survive a round trip through Sample", i)
+		}
+	}
+}
+
+// unknownFields returns the path of the first message holding unknown
+// fields, or "" when there are none.
+func unknownFields(msg protoreflect.Message, path string) string {
+	if len(msg.GetUnknown()) > 0 {
+		return path
+	}
+	var found string
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind {
+			return true
+		}
+		name := path + "." + string(fd.Name())
+		if fd.IsList() {
+			for i := 0; i < v.List().Len() && found == ""; i++ {
+				found = unknownFields(v.List().Get(i).Message(), name+"["+strconv.Itoa(i)+"]")
+			}
+		} else {
+			found = unknownFields(v.Message(), name)
+		}
+		return found == ""
+	})
+	return found
+}
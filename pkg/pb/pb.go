@@ -0,0 +1,448 @@
+// Package pb encodes powermetrics samples as protocol buffers, following
+// powermetrics.proto, and streams them length-delimited.
+//
+// The messages are encoded and decoded by hand with protowire, so that no
+// generated code or protoc is needed; any implementation generated from
+// powermetrics.proto reads and writes the same bytes.
+package pb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Field numbers of Sample
+const (
+	sampleTimestamp     protowire.Number = 1
+	sampleElapsedNS     protowire.Number = 2
+	sampleHWModel       protowire.Number = 3
+	sampleKernOSVersion protowire.Number = 4
+	sampleKernBootArgs  protowire.Number = 5
+	sampleKernBootTime  protowire.Number = 6
+	sampleIsDelta       protowire.Number = 7
+	sampleGPUPower      protowire.Number = 10
+	sampleTasks         protowire.Number = 11
+	sampleBattery       protowire.Number = 12
+	sampleThermal       protowire.Number = 13
+)
+
+// Field numbers of GPUPowerSample
+const (
+	gpuFreqMHz           protowire.Number = 1
+	gpuIdleNS            protowire.Number = 2
+	gpuIdleRatio         protowire.Number = 3
+	gpuEnergyMJ          protowire.Number = 4
+	gpuDVFMStates        protowire.Number = 5
+	gpuSWRequestedStates protowire.Number = 6
+	gpuSWStates          protowire.Number = 7
+)
+
+// Field numbers of DVFMState and SWState
+const (
+	stateName      protowire.Number = 1 // freq_mhz in DVFMState
+	stateUsedNS    protowire.Number = 2
+	stateUsedRatio protowire.Number = 3
+)
+
+// Field numbers of TasksSample
+const (
+	tasksAllTasks protowire.Number = 1
+	tasksTasks    protowire.Number = 2
+)
+
+// ErrUnsupportedSample is returned when marshaling a sample type that has no
+// message.
+var ErrUnsupportedSample = errors.New("unsupported sample type")
+
+// Marshal returns the Sample message of sample.
+func Marshal(sample types.Sample) ([]byte, error) {
+	return AppendSample(nil, sample)
+}
+
+// AppendSample appends the Sample message of sample to b.
+func AppendSample(b []byte, sample types.Sample) ([]byte, error) {
+	switch sample.(type) {
+	case *types.GPUPowerSample, *types.TasksSample, *types.BatterySample, *types.ThermalSample:
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedSample, sample)
+	}
+
+	if ts := sample.GetTimestamp(); !ts.IsZero() {
+		// Optional fields are written even when zero.
+		b = protowire.AppendTag(b, sampleTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(ts.UnixNano()))
+	}
+	b = appendInt(b, sampleElapsedNS, sample.GetElapsedNS())
+	b = appendString(b, sampleHWModel, sample.GetHWModel())
+	b = appendString(b, sampleKernOSVersion, sample.GetKernOSVer())
+	b = appendString(b, sampleKernBootArgs, sample.GetKernBootArgs())
+	b = appendInt(b, sampleKernBootTime, sample.GetKernBootTime())
+	if sample.GetIsDelta() {
+		b = appendInt(b, sampleIsDelta, 1)
+	}
+
+	// The sampler message is written even when empty, as it tells the
+	// sample type.
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		b = appendMessage(b, sampleGPUPower, func(b []byte) []byte { return appendGPU(b, &s.GPU) })
+	case *types.TasksSample:
+		b = appendMessage(b, sampleTasks, func(b []byte) []byte {
+			b = appendMessage(b, tasksAllTasks, func(b []byte) []byte { return appendTask(b, &s.AllTasks) })
+			for i := range s.Tasks {
+				b = appendMessage(b, tasksTasks, func(b []byte) []byte { return appendTask(b, &s.Tasks[i]) })
+			}
+			return b
+		})
+	case *types.BatterySample:
+		b = appendMessage(b, sampleBattery, func(b []byte) []byte {
+			return appendInt(b, 1, int64(s.Battery.PercentCharge))
+		})
+	case *types.ThermalSample:
+		b = appendMessage(b, sampleThermal, func(b []byte) []byte {
+			return appendString(b, 1, s.ThermalPressure)
+		})
+	}
+	return b, nil
+}
+
+func appendGPU(b []byte, g *types.GPUInfo) []byte {
+	b = appendDouble(b, gpuFreqMHz, g.FreqHz)
+	b = appendInt(b, gpuIdleNS, g.IdleNS)
+	b = appendDouble(b, gpuIdleRatio, g.IdleRatio)
+	if g.GPUEnergy != nil {
+		b = protowire.AppendTag(b, gpuEnergyMJ, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*g.GPUEnergy))
+	}
+	for _, state := range g.DVFMStates {
+		b = appendMessage(b, gpuDVFMStates, func(b []byte) []byte {
+			b = appendInt(b, stateName, state.Freq)
+			b = appendInt(b, stateUsedNS, state.UsedNS)
+			return appendDouble(b, stateUsedRatio, state.UsedRatio)
+		})
+	}
+	for _, state := range g.SWRequestedState {
+		b = appendMessage(b, gpuSWRequestedStates, func(b []byte) []byte {
+			return appendSWState(b, state.SWReqState, state.UsedNS, state.UsedRatio)
+		})
+	}
+	for _, state := range g.SWState {
+		b = appendMessage(b, gpuSWStates, func(b []byte) []byte {
+			return appendSWState(b, state.SWState, state.UsedNS, state.UsedRatio)
+		})
+	}
+	return b
+}
+
+func appendSWState(b []byte, name string, usedNS int64, usedRatio float64) []byte {
+	b = appendString(b, stateName, name)
+	b = appendInt(b, stateUsedNS, usedNS)
+	return appendDouble(b, stateUsedRatio, usedRatio)
+}
+
+func appendTask(b []byte, t *types.TaskInfo) []byte {
+	b = appendInt(b, 1, int64(t.PID))
+	b = appendString(b, 2, t.Name)
+	b = appendInt(b, 3, t.IntervalNS)
+	b = appendInt(b, 4, t.CPUTimeNS)
+	b = appendDouble(b, 5, t.CPUTimeMSPerS)
+	b = appendDouble(b, 6, t.CPUTimeUserlandRatio)
+	b = appendInt(b, 7, t.IntrWakeups)
+	b = appendDouble(b, 8, t.IntrWakeupsPerS)
+	b = appendInt(b, 9, t.IdleWakeups)
+	b = appendDouble(b, 10, t.IdleWakeupsPerS)
+	b = appendInt(b, 11, t.DiskIOBytesRead)
+	b = appendInt(b, 12, t.DiskIOBytesWritten)
+	b = appendInt(b, 13, t.PacketsReceived)
+	b = appendInt(b, 14, t.PacketsSent)
+	b = appendInt(b, 15, t.BytesReceived)
+	b = appendInt(b, 16, t.BytesSent)
+	b = appendDouble(b, 17, t.EnergyImpact)
+	return appendDouble(b, 18, t.EnergyImpactPerS)
+}
+
+// appendInt appends an int32, int64 or bool field. Zero values are omitted,
+// as in proto3.
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 && !math.Signbit(v) {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendMessage appends an embedded message written by fn.
+func appendMessage(b []byte, num protowire.Number, fn func([]byte) []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	// Reserve one byte for the length, the common case, and shift the
+	// message if the length needs more.
+	start := len(b)
+	b = append(b, 0)
+	b = fn(b)
+	size := len(b) - start - 1
+	if n := protowire.SizeVarint(uint64(size)); n > 1 {
+		b = append(b, make([]byte, n-1)...)
+		copy(b[start+n:], b[start+1:len(b)-(n-1)])
+	}
+	protowire.AppendVarint(b[start:start], uint64(size))
+	return b
+}
+
+// Unmarshal decodes a Sample message. Unknown fields are skipped.
+func Unmarshal(b []byte) (types.Sample, error) {
+	var (
+		base   types.BaseSample
+		sample types.Sample
+	)
+	err := walk(b, func(num protowire.Number, f field) error {
+		var err error
+		switch num {
+		case sampleTimestamp:
+			var ns int64
+			if ns, err = f.int(); err == nil {
+				base.Timestamp = time.Unix(0, ns).UTC()
+			}
+		case sampleElapsedNS:
+			base.ElapsedNS, err = f.int()
+		case sampleHWModel:
+			base.HWModel, err = f.string()
+		case sampleKernOSVersion:
+			base.KernOSVer, err = f.string()
+		case sampleKernBootArgs:
+			base.KernBootArgs, err = f.string()
+		case sampleKernBootTime:
+			base.KernBootTime, err = f.int()
+		case sampleIsDelta:
+			var v int64
+			v, err = f.int()
+			base.IsDelta = v != 0
+		case sampleGPUPower:
+			s := &types.GPUPowerSample{}
+			sample, err = s, f.message(func(num protowire.Number, f field) error { return decodeGPU(&s.GPU, num, f) })
+		case sampleTasks:
+			s := &types.TasksSample{}
+			sample, err = s, f.message(func(num protowire.Number, f field) error { return decodeTasks(s, num, f) })
+		case sampleBattery:
+			s := &types.BatterySample{}
+			sample, err = s, f.message(func(num protowire.Number, f field) (err error) {
+				if num == 1 {
+					var v int64
+					v, err = f.int()
+					s.Battery.PercentCharge = int(v)
+				}
+				return err
+			})
+		case sampleThermal:
+			s := &types.ThermalSample{}
+			sample, err = s, f.message(func(num protowire.Number, f field) (err error) {
+				if num == 1 {
+					s.ThermalPressure, err = f.string()
+				}
+				return err
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		s.BaseSample = base
+	case *types.TasksSample:
+		s.BaseSample = base
+	case *types.BatterySample:
+		s.BaseSample = base
+	case *types.ThermalSample:
+		s.BaseSample = base
+	default:
+		return nil, fmt.Errorf("sample has no sampler message")
+	}
+	return sample, nil
+}
+
+func decodeGPU(g *types.GPUInfo, num protowire.Number, f field) (err error) {
+	switch num {
+	case gpuFreqMHz:
+		g.FreqHz, err = f.double()
+	case gpuIdleNS:
+		g.IdleNS, err = f.int()
+	case gpuIdleRatio:
+		g.IdleRatio, err = f.double()
+	case gpuEnergyMJ:
+		var e int64
+		if e, err = f.int(); err == nil {
+			g.GPUEnergy = &e
+		}
+	case gpuDVFMStates:
+		var state types.DVFMState
+		err = f.message(func(num protowire.Number, f field) (err error) {
+			switch num {
+			case stateName:
+				state.Freq, err = f.int()
+			case stateUsedNS:
+				state.UsedNS, err = f.int()
+			case stateUsedRatio:
+				state.UsedRatio, err = f.double()
+			}
+			return err
+		})
+		g.DVFMStates = append(g.DVFMStates, state)
+	case gpuSWRequestedStates:
+		var state types.SWReqState
+		err = f.message(swStateDecoder(&state.SWReqState, &state.UsedNS, &state.UsedRatio))
+		g.SWRequestedState = append(g.SWRequestedState, state)
+	case gpuSWStates:
+		var state types.SWState
+		err = f.message(swStateDecoder(&state.SWState, &state.UsedNS, &state.UsedRatio))
+		g.SWState = append(g.SWState, state)
+	}
+	return err
+}
+
+func swStateDecoder(name *string, usedNS *int64, usedRatio *float64) func(protowire.Number, field) error {
+	return func(num protowire.Number, f field) (err error) {
+		switch num {
+		case stateName:
+			*name, err = f.string()
+		case stateUsedNS:
+			*usedNS, err = f.int()
+		case stateUsedRatio:
+			*usedRatio, err = f.double()
+		}
+		return err
+	}
+}
+
+func decodeTasks(s *types.TasksSample, num protowire.Number, f field) error {
+	switch num {
+	case tasksAllTasks:
+		return f.message(taskDecoder(&s.AllTasks))
+	case tasksTasks:
+		var task types.TaskInfo
+		err := f.message(taskDecoder(&task))
+		s.Tasks = append(s.Tasks, task)
+		return err
+	}
+	return nil
+}
+
+func taskDecoder(t *types.TaskInfo) func(protowire.Number, field) error {
+	ints := map[protowire.Number]*int64{
+		3: &t.IntervalNS, 4: &t.CPUTimeNS, 7: &t.IntrWakeups, 9: &t.IdleWakeups,
+		11: &t.DiskIOBytesRead, 12: &t.DiskIOBytesWritten, 13: &t.PacketsReceived,
+		14: &t.PacketsSent, 15: &t.BytesReceived, 16: &t.BytesSent,
+	}
+	doubles := map[protowire.Number]*float64{
+		5: &t.CPUTimeMSPerS, 6: &t.CPUTimeUserlandRatio, 8: &t.IntrWakeupsPerS,
+		10: &t.IdleWakeupsPerS, 17: &t.EnergyImpact, 18: &t.EnergyImpactPerS,
+	}
+	return func(num protowire.Number, f field) (err error) {
+		if p, ok := ints[num]; ok {
+			*p, err = f.int()
+			return err
+		}
+		if p, ok := doubles[num]; ok {
+			*p, err = f.double()
+			return err
+		}
+		switch num {
+		case 1:
+			var pid int64
+			pid, err = f.int()
+			t.PID = int(int32(pid))
+		case 2:
+			t.Name, err = f.string()
+		}
+		return err
+	}
+}
+
+// field is an encoded field value of a known wire type.
+type field struct {
+	num protowire.Number
+	typ protowire.Type
+	v   uint64 // varint and fixed64 values
+	b   []byte // bytes values
+}
+
+func (f field) check(typ protowire.Type) error {
+	if f.typ != typ {
+		return fmt.Errorf("field %d: unexpected wire type %d", f.num, f.typ)
+	}
+	return nil
+}
+
+func (f field) int() (int64, error) {
+	return int64(f.v), f.check(protowire.VarintType)
+}
+
+func (f field) double() (float64, error) {
+	return math.Float64frombits(f.v), f.check(protowire.Fixed64Type)
+}
+
+func (f field) string() (string, error) {
+	return string(f.b), f.check(protowire.BytesType)
+}
+
+func (f field) message(fn func(protowire.Number, field) error) error {
+	if err := f.check(protowire.BytesType); err != nil {
+		return err
+	}
+	return walk(f.b, fn)
+}
+
+// walk calls fn for every field of the message b.
+func walk(b []byte, fn func(protowire.Number, field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ == protowire.VarintType || typ == protowire.Fixed64Type || typ == protowire.BytesType {
+			if err := fn(num, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package pb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestRoundTrip(t *testing.T) {
+	samples := sampletest.Samples(t)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	for i, want := range samples {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("Decode of sample %d failed: %v", i, err)
+		}
+		wantMsg, _ := Marshal(want)
+		gotMsg, err := Marshal(got)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !bytes.Equal(gotMsg, wantMsg) {
+			t.Errorf("Expected sample %d to encode identically after decoding", i)
+		}
+		if !got.GetTimestamp().Equal(want.GetTimestamp()) {
+			t.Errorf("Expected timestamp %v, got %v", want.GetTimestamp(), got.GetTimestamp())
+		}
+	}
+	if _, err := dec.Decode(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	msg, _ := Marshal(samples[0])
+	sample, err := Unmarshal(msg)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	gpu, ok := sample.(*types.GPUPowerSample)
+	if !ok {
+		t.Fatalf("Expected a GPU power sample, got %T", sample)
+	}
+	if gpu.ElapsedNS != 5004758375 || gpu.HWModel != "Mac16,8" || !gpu.IsDelta {
+		t.Errorf("Unexpected header %+v", gpu.BaseSample)
+	}
+	if gpu.GPU.GPUEnergy == nil || *gpu.GPU.GPUEnergy != 19 {
+		t.Errorf("Expected 19 mJ of GPU energy, got %v", gpu.GPU.GPUEnergy)
+	}
+	if want := (types.DVFMState{Freq: 338, UsedNS: 58088833, UsedRatio: 0.0149429}); gpu.GPU.DVFMStates[0] != want {
+		t.Errorf("Expected first DVFM state %+v, got %+v", want, gpu.GPU.DVFMStates[0])
+	}
+	if len(gpu.GPU.DVFMStates) != len(samples[0].(*types.GPUPowerSample).GPU.DVFMStates) {
+		t.Error("Expected DVFM states sharing a frequency to be kept")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	sample := &types.ThermalSample{BaseSample: types.BaseSample{ElapsedNS: 1}, ThermalPressure: "Heavy"}
+	got, err := Marshal(sample)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := []byte{0x10, 0x01, 0x6a, 0x07, 0x0a, 0x05, 'H', 'e', 'a', 'v', 'y'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected % x, got % x", want, got)
+	}
+
+	// Empty sampler messages still tell the sample type.
+	got, _ = Marshal(&types.BatterySample{})
+	if !bytes.Equal(got, []byte{0x62, 0x00}) {
+		t.Errorf("Expected an empty battery message, got % x", got)
+	}
+
+	if _, err := Marshal(nil); !errors.Is(err, ErrUnsupportedSample) {
+		t.Errorf("Expected ErrUnsupportedSample, got %v", err)
+	}
+}
+
+func TestUnmarshalUnknownFields(t *testing.T) {
+	msg, _ := Marshal(&types.ThermalSample{ThermalPressure: "Heavy"})
+	msg = protowire.AppendTag(msg, 99, protowire.BytesType)
+	msg = protowire.AppendString(msg, "future")
+	msg = protowire.AppendTag(msg, 100, protowire.Fixed32Type)
+	msg = protowire.AppendFixed32(msg, 1)
+	sample, err := Unmarshal(msg)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if thermal, ok := sample.(*types.ThermalSample); !ok || thermal.ThermalPressure != "Heavy" {
+		t.Errorf("Expected a Heavy thermal sample, got %#v", sample)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		wantErr error
+		wantMsg string
+	}{
+		{"truncated prefix", []byte{0x80}, io.ErrUnexpectedEOF, ""},
+		{"truncated message", []byte{0x05, 0x10}, io.ErrUnexpectedEOF, "message 1"},
+		{"too large", protowire.AppendVarint(nil, DefaultMaxSize+1), ErrMessageTooLarge, "message 1"},
+		{"no sampler", []byte{0x02, 0x10, 0x01}, nil, "no sampler"},
+		{"wrong wire type", []byte{0x02, 0x12, 0x00}, nil, "field 2: unexpected wire type"},
+		{"invalid field", []byte{0x01, 0x10}, nil, "field 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDecoder(bytes.NewReader(tt.input)).Decode()
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Expected error to contain %q, got %v", tt.wantMsg, err)
+			}
+		})
+	}
+}
+
+func TestSize(t *testing.T) {
+	plist, err := os.ReadFile("../../testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, sample := range sampletest.Read(t, sampletest.GPUPower) {
+		if err := enc.Encode(sample); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	if buf.Len()*10 > len(plist) {
+		t.Errorf("Expected protobuf to be a tenth of the plist size, got %d bytes for %d", buf.Len(), len(plist))
+	}
+}
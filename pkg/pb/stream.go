@@ -0,0 +1,113 @@
+package pb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// DefaultMaxSize is the default limit on the size of a decoded message.
+const DefaultMaxSize = 4 << 20
+
+// ErrMessageTooLarge is returned when a length prefix exceeds the decoder's
+// limit, which usually means the stream is not length-delimited Samples.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Encoder writes samples as length-delimited Sample messages.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEncoder returns an encoder writing to w. Each message is written with a
+// single call to w, so that a datagram or a stream write holds a whole
+// message.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the size of the message of sample as a varint, followed by
+// the message.
+func (e *Encoder) Encode(sample types.Sample) error {
+	msg, err := Marshal(sample)
+	if err != nil {
+		return err
+	}
+	e.buf = protowire.AppendVarint(e.buf[:0], uint64(len(msg)))
+	e.buf = append(e.buf, msg...)
+	_, err = e.w.Write(e.buf)
+	return err
+}
+
+// Decoder reads samples from length-delimited Sample messages.
+type Decoder struct {
+	// MaxSize limits the size of a message. Zero means DefaultMaxSize.
+	MaxSize int
+
+	r     *bufio.Reader
+	buf   []byte
+	count int
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode returns the next sample, or io.EOF at the end of the stream. A
+// stream ending within a message returns io.ErrUnexpectedEOF.
+func (d *Decoder) Decode() (types.Sample, error) {
+	size, err := d.readSize()
+	if err != nil {
+		return nil, err
+	}
+	d.count++
+	maxSize := d.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if size > uint64(maxSize) {
+		return nil, fmt.Errorf("message %d: %w: %d bytes", d.count, ErrMessageTooLarge, size)
+	}
+	if uint64(cap(d.buf)) < size {
+		d.buf = make([]byte, size)
+	}
+	d.buf = d.buf[:size]
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("message %d: %w", d.count, err)
+	}
+	sample, err := Unmarshal(d.buf)
+	if err != nil {
+		return nil, fmt.Errorf("message %d: %w", d.count, err)
+	}
+	return sample, nil
+}
+
+// readSize reads a varint length prefix.
+func (d *Decoder) readSize() (uint64, error) {
+	var size uint64
+	for i := 0; ; i++ {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			if i > 0 && errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if i == 9 && c > 1 {
+			return 0, fmt.Errorf("message %d: invalid length prefix", d.count+1)
+		}
+		size |= uint64(c&0x7f) << (7 * i)
+		if c < 0x80 {
+			return size, nil
+		}
+	}
+}
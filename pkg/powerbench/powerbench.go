@@ -0,0 +1,92 @@
+// Package powerbench reports the energy used by Go benchmarks.
+//
+// Run samples power around the benchmark loop and reports J/op and the
+// average W through testing.B.ReportMetric, so benchstat can compare energy
+// between commits:
+//
+//	func BenchmarkEncode(b *testing.B) {
+//		powerbench.Run(b, func(b *testing.B) {
+//			for i := 0; i < b.N; i++ {
+//				encode()
+//			}
+//		})
+//	}
+//
+// powermetrics must be installed and the benchmark must run as root (for
+// example with go test -exec sudo). Otherwise the benchmark is skipped.
+package powerbench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics"
+)
+
+// Config controls how Run samples power.
+type Config struct {
+	// Powermetrics is the instance used for sampling. Nil means
+	// powermetrics.New(), in which case Run first checks that powermetrics is
+	// installed and that the process is privileged.
+	Powermetrics *powermetrics.Powermetrics
+	// Sampling is passed to Powermetrics.Measure. Nil uses its defaults.
+	Sampling *powermetrics.Config
+}
+
+// ErrUnavailable is reported when powermetrics cannot be used.
+var ErrUnavailable = errors.New("powermetrics unavailable")
+
+// checkAvailable reports whether the real powermetrics can be run.
+var checkAvailable = func() error {
+	if _, err := exec.LookPath("powermetrics"); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("%w: powermetrics must be run as root (try go test -exec sudo)", ErrUnavailable)
+	}
+	return nil
+}
+
+// Run runs body with the default configuration. See RunWithConfig.
+func Run(b *testing.B, body func(b *testing.B)) {
+	RunWithConfig(b, nil, body)
+}
+
+// RunWithConfig samples power while body runs the benchmark loop and
+// reports the energy per operation as J/op and the average power as W. The
+// benchmark timer is reset right before body is called. The benchmark is
+// skipped with an explanation when powermetrics is unavailable or fails.
+func RunWithConfig(b *testing.B, config *Config, body func(b *testing.B)) {
+	b.Helper()
+	if config == nil {
+		config = &Config{}
+	}
+
+	pm := config.Powermetrics
+	if pm == nil {
+		if err := checkAvailable(); err != nil {
+			b.Skipf("skipping energy measurement: %v", err)
+		}
+		pm = powermetrics.New()
+	}
+
+	m, err := pm.Measure(context.Background(), config.Sampling, func(context.Context) error {
+		b.ResetTimer()
+		body(b)
+		b.StopTimer()
+		return nil
+	})
+	if err != nil {
+		b.Skipf("skipping energy measurement: %v", err)
+	}
+	if m.Covered == 0 {
+		b.Skipf("skipping energy measurement: no samples covered the benchmark")
+	}
+
+	b.ReportMetric(m.Energy.Joules()/float64(b.N), "J/op")
+	b.ReportMetric(m.AveragePower.Watts(), "W")
+}
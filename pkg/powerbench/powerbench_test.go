@@ -0,0 +1,52 @@
+package powerbench
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+)
+
+func TestRunWithMock(t *testing.T) {
+	xmlData, err := os.ReadFile("../../testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	config := &Config{
+		Powermetrics: powermetrics.NewWithRunner(&powermetrics.MockCommandRunner{Output: xmlData}),
+	}
+	result := testing.Benchmark(func(b *testing.B) {
+		RunWithConfig(b, config, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				time.Sleep(time.Microsecond)
+			}
+		})
+	})
+
+	if result.N == 0 {
+		t.Fatal("Expected the benchmark to run")
+	}
+	if result.Extra["J/op"] <= 0 {
+		t.Errorf("Expected positive J/op, got %v", result.Extra)
+	}
+	if result.Extra["W"] <= 0 {
+		t.Errorf("Expected positive W, got %v", result.Extra)
+	}
+}
+
+func TestRunSkipsWhenUnavailable(t *testing.T) {
+	original := checkAvailable
+	defer func() { checkAvailable = original }()
+	checkAvailable = func() error { return ErrUnavailable }
+
+	ran := false
+	result := testing.Benchmark(func(b *testing.B) {
+		Run(b, func(b *testing.B) { ran = true })
+	})
+
+	if ran || result.N != 0 {
+		t.Errorf("Expected the benchmark to be skipped, got N=%d", result.N)
+	}
+}
@@ -0,0 +1,274 @@
+// Package prom converts powermetrics samples into Prometheus metrics.
+//
+// A Collector accumulates the samples of a long-lived stream: energy,
+// sampled time, state residency, CPU time and wakeups become counters, and
+// the values of the most recent interval, such as power, frequency and
+// ratios, become gauges. Every series carries the hw_model and os_build
+// labels of the most recent sample.
+package prom
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Namespace prefixes every metric name.
+const Namespace = "powermetrics"
+
+// Type is the type of a metric family.
+type Type string
+
+// Metric types
+const (
+	Counter Type = "counter"
+	Gauge   Type = "gauge"
+)
+
+// Label is a label of a series.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Series is a single time series of a family.
+type Series struct {
+	Labels []Label
+	Value  float64
+}
+
+// Family is a metric family: a name, its help text and type, and its series.
+type Family struct {
+	Name   string
+	Help   string
+	Type   Type
+	Series []Series
+}
+
+// metricInfo describes a family known to the collector.
+type metricInfo struct {
+	help string
+	typ  Type
+}
+
+var metrics = map[string]metricInfo{
+	"samples_total":                                  {"Number of samples received, by sampler.", Counter},
+	"sampled_seconds_total":                          {"Time covered by samples, by sampler.", Counter},
+	"last_sample_timestamp_seconds":                  {"Unix time of the most recent sample, by sampler.", Gauge},
+	"gpu_energy_joules_total":                        {"Energy used by the GPU.", Counter},
+	"gpu_power_watts":                                {"Average GPU power over the most recent interval.", Gauge},
+	"gpu_frequency_hertz":                            {"Average active GPU frequency over the most recent interval.", Gauge},
+	"gpu_idle_ratio":                                 {"Fraction of the most recent interval the GPU was idle.", Gauge},
+	"gpu_idle_seconds_total":                         {"Time the GPU was idle.", Counter},
+	"gpu_dvfm_residency_ratio":                       {"Fraction of the most recent interval spent in a GPU DVFM state.", Gauge},
+	"gpu_dvfm_residency_seconds_total":               {"Time spent in a GPU DVFM state.", Counter},
+	"gpu_sw_state_residency_ratio":                   {"Fraction of the most recent interval spent in a GPU software state.", Gauge},
+	"gpu_sw_state_residency_seconds_total":           {"Time spent in a GPU software state.", Counter},
+	"gpu_sw_requested_state_residency_ratio":         {"Fraction of the most recent interval a GPU software state was requested.", Gauge},
+	"gpu_sw_requested_state_residency_seconds_total": {"Time a GPU software state was requested.", Counter},
+	"tasks_cpu_seconds_total":                        {"CPU time used by all tasks.", Counter},
+	"tasks_cpu_usage_cores":                          {"CPU time used by all tasks per second over the most recent interval.", Gauge},
+	"tasks_userland_ratio":                           {"Fraction of the CPU time of all tasks spent in userland over the most recent interval.", Gauge},
+	"tasks_wakeups_total":                            {"Wakeups of all tasks, by kind.", Counter},
+	"tasks_disk_io_bytes_total":                      {"Disk I/O of all tasks, by direction.", Counter},
+	"tasks_network_packets_total":                    {"Network packets of all tasks, by direction.", Counter},
+	"tasks_network_bytes_total":                      {"Network traffic of all tasks, by direction.", Counter},
+	"tasks_energy_impact_per_second":                 {"Energy impact of all tasks per second over the most recent interval.", Gauge},
+	"thermal_pressure":                               {"Whether the thermal pressure is at a level.", Gauge},
+	"thermal_pressure_level":                         {"Thermal pressure level, from 0 for Nominal to 4 for Sleeping.", Gauge},
+}
+
+// Collector accumulates samples into metric families. It is safe for
+// concurrent use.
+type Collector struct {
+	mu       sync.Mutex
+	hwModel  string
+	osBuild  string
+	counters map[seriesKey]float64
+	gauges   map[seriesKey]float64
+}
+
+// seriesKey identifies a series by metric name and at most one label.
+type seriesKey struct {
+	name  string
+	label Label
+}
+
+// NewCollector returns an empty collector.
+func NewCollector() *Collector {
+	return &Collector{
+		counters: make(map[seriesKey]float64),
+		gauges:   make(map[seriesKey]float64),
+	}
+}
+
+// Observe adds a sample. Samples of unsupported types only update the
+// sample counters.
+func (c *Collector) Observe(sample types.Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hwModel = sample.GetHWModel()
+	c.osBuild = sample.GetKernOSVer()
+	elapsed := time.Duration(sample.GetElapsedNS()).Seconds()
+
+	var sampler string
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		sampler = "gpu_power"
+		c.observeGPU(s, elapsed)
+	case *types.TasksSample:
+		sampler = "tasks"
+		c.observeTasks(s)
+	case *types.ThermalSample:
+		sampler = "thermal"
+		c.observeThermal(s)
+	default:
+		sampler = "unknown"
+	}
+
+	label := Label{"sampler", sampler}
+	c.counters[seriesKey{"samples_total", label}]++
+	c.counters[seriesKey{"sampled_seconds_total", label}] += elapsed
+	if ts := sample.GetTimestamp(); !ts.IsZero() {
+		c.gauges[seriesKey{"last_sample_timestamp_seconds", label}] = float64(ts.UnixNano()) / 1e9
+	}
+}
+
+func (c *Collector) observeGPU(s *types.GPUPowerSample, elapsed float64) {
+	if e, ok := s.GPU.Energy(); ok {
+		c.counters[seriesKey{name: "gpu_energy_joules_total"}] += e.Joules()
+	}
+	if p, ok := s.Power(); ok {
+		c.gauges[seriesKey{name: "gpu_power_watts"}] = p.Watts()
+	}
+	c.gauges[seriesKey{name: "gpu_frequency_hertz"}] = s.GPU.Frequency().Hertz()
+	c.gauges[seriesKey{name: "gpu_idle_ratio"}] = s.GPU.IdleRatio
+	c.counters[seriesKey{name: "gpu_idle_seconds_total"}] += s.GPU.Idle().Seconds()
+
+	// Residency ratios describe the most recent interval only, so states
+	// missing from it are reset to zero.
+	c.resetGauges("gpu_dvfm_residency_ratio", "gpu_sw_state_residency_ratio", "gpu_sw_requested_state_residency_ratio")
+	for _, state := range s.GPU.DVFMStates {
+		label := Label{"frequency_mhz", strconv.FormatInt(state.Freq, 10)}
+		c.gauges[seriesKey{"gpu_dvfm_residency_ratio", label}] += state.UsedRatio
+		c.counters[seriesKey{"gpu_dvfm_residency_seconds_total", label}] += residency(state.Used(), state.UsedRatio, elapsed)
+	}
+	for _, state := range s.GPU.SWState {
+		label := Label{"state", state.SWState}
+		c.gauges[seriesKey{"gpu_sw_state_residency_ratio", label}] += state.UsedRatio
+		c.counters[seriesKey{"gpu_sw_state_residency_seconds_total", label}] += residency(state.Used(), state.UsedRatio, elapsed)
+	}
+	for _, state := range s.GPU.SWRequestedState {
+		label := Label{"state", state.SWReqState}
+		c.gauges[seriesKey{"gpu_sw_requested_state_residency_ratio", label}] += state.UsedRatio
+		c.counters[seriesKey{"gpu_sw_requested_state_residency_seconds_total", label}] += residency(state.Used(), state.UsedRatio, elapsed)
+	}
+}
+
+// residency returns the time spent in a state in seconds, falling back to
+// the ratio when powermetrics did not report the time.
+func residency(used time.Duration, ratio, elapsed float64) float64 {
+	if used > 0 {
+		return used.Seconds()
+	}
+	return ratio * elapsed
+}
+
+func (c *Collector) observeTasks(s *types.TasksSample) {
+	all := s.AllTasks
+	c.counters[seriesKey{name: "tasks_cpu_seconds_total"}] += all.CPUTime().Seconds()
+	c.gauges[seriesKey{name: "tasks_cpu_usage_cores"}] = all.CPUTimeMSPerS / 1000
+	c.gauges[seriesKey{name: "tasks_userland_ratio"}] = all.CPUTimeUserlandRatio
+	c.counters[seriesKey{"tasks_wakeups_total", Label{"kind", "interrupt"}}] += float64(all.IntrWakeups)
+	c.counters[seriesKey{"tasks_wakeups_total", Label{"kind", "idle"}}] += float64(all.IdleWakeups)
+	c.counters[seriesKey{"tasks_disk_io_bytes_total", Label{"direction", "read"}}] += float64(all.DiskIOBytesRead)
+	c.counters[seriesKey{"tasks_disk_io_bytes_total", Label{"direction", "write"}}] += float64(all.DiskIOBytesWritten)
+	c.counters[seriesKey{"tasks_network_packets_total", Label{"direction", "receive"}}] += float64(all.PacketsReceived)
+	c.counters[seriesKey{"tasks_network_packets_total", Label{"direction", "transmit"}}] += float64(all.PacketsSent)
+	c.counters[seriesKey{"tasks_network_bytes_total", Label{"direction", "receive"}}] += float64(all.BytesReceived)
+	c.counters[seriesKey{"tasks_network_bytes_total", Label{"direction", "transmit"}}] += float64(all.BytesSent)
+	c.gauges[seriesKey{name: "tasks_energy_impact_per_second"}] = all.EnergyImpactPerS
+}
+
+func (c *Collector) observeThermal(s *types.ThermalSample) {
+	// Every known level is reported, so the series exist before the level
+	// is first reached.
+	c.resetGauges("thermal_pressure")
+	for _, level := range types.ThermalPressureLevels {
+		c.gauges[seriesKey{"thermal_pressure", Label{"level", level}}] = 0
+	}
+	c.gauges[seriesKey{"thermal_pressure", Label{"level", s.ThermalPressure}}] = 1
+	c.gauges[seriesKey{name: "thermal_pressure_level"}] = float64(types.ThermalPressureRank(s.ThermalPressure))
+}
+
+func (c *Collector) resetGauges(names ...string) {
+	for key := range c.gauges {
+		for _, name := range names {
+			if key.name == name {
+				c.gauges[key] = 0
+			}
+		}
+	}
+}
+
+// Families returns the metric families collected so far, sorted by name,
+// with their series sorted by label value.
+func (c *Collector) Families() []Family {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byName := make(map[string]*Family)
+	add := func(values map[seriesKey]float64) {
+		for key, value := range values {
+			info := metrics[key.name]
+			family, ok := byName[key.name]
+			if !ok {
+				family = &Family{Name: Namespace + "_" + key.name, Help: info.help, Type: info.typ}
+				byName[key.name] = family
+			}
+			labels := []Label{{"hw_model", c.hwModel}, {"os_build", c.osBuild}}
+			if key.label.Name != "" {
+				labels = append(labels, key.label)
+			}
+			family.Series = append(family.Series, Series{Labels: labels, Value: value})
+		}
+	}
+	add(c.counters)
+	add(c.gauges)
+
+	families := make([]Family, 0, len(byName))
+	for _, family := range byName {
+		sort.Slice(family.Series, func(i, j int) bool {
+			return labelLess(lastLabel(family.Series[i]), lastLabel(family.Series[j]))
+		})
+		families = append(families, *family)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+func lastLabel(s Series) string {
+	return s.Labels[len(s.Labels)-1].Value
+}
+
+// labelLess orders numeric label values, such as frequencies, numerically
+// and other values alphabetically.
+func labelLess(a, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// ServeHTTP writes the collected families in the text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_ = WriteText(w, c.Families())
+}
@@ -0,0 +1,102 @@
+package prom
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	for _, name := range []string{sampletest.GPUPower, sampletest.Tasks} {
+		for _, sample := range sampletest.Read(t, name) {
+			c.Observe(sample)
+		}
+	}
+	c.Observe(&types.ThermalSample{BaseSample: types.BaseSample{HWModel: "Mac16,8", KernOSVer: "24F74"}, ThermalPressure: "Moderate"})
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, c.Families()); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	text := buf.String()
+
+	for _, want := range []string{
+		"# HELP powermetrics_gpu_energy_joules_total Energy used by the GPU.\n",
+		"# TYPE powermetrics_gpu_energy_joules_total counter\n",
+		`powermetrics_gpu_energy_joules_total{hw_model="Mac16,8",os_build="24F74"} 1.186` + "\n",
+		"# TYPE powermetrics_gpu_idle_ratio gauge\n",
+		`powermetrics_gpu_dvfm_residency_seconds_total{hw_model="Mac16,8",os_build="24F74",frequency_mhz="338"} `,
+		`powermetrics_gpu_sw_state_residency_ratio{hw_model="Mac16,8",os_build="24F74",state="SW_P1"} `,
+		`powermetrics_samples_total{hw_model="Mac16,8",os_build="24F74",sampler="gpu_power"} `,
+		`powermetrics_samples_total{hw_model="Mac16,8",os_build="24F74",sampler="tasks"} 3` + "\n",
+		`powermetrics_tasks_cpu_seconds_total{hw_model="Mac16,8",os_build="24F74"} 1.2095` + "\n",
+		`powermetrics_tasks_wakeups_total{hw_model="Mac16,8",os_build="24F74",kind="interrupt"} `,
+		"# TYPE powermetrics_tasks_energy_impact_per_second gauge\n",
+		`powermetrics_tasks_energy_impact_per_second{hw_model="Mac16,8",os_build="24F74"} 46.75` + "\n",
+		`powermetrics_thermal_pressure{hw_model="Mac16,8",os_build="24F74",level="Moderate"} 1` + "\n",
+		`powermetrics_thermal_pressure{hw_model="Mac16,8",os_build="24F74",level="Nominal"} 0` + "\n",
+		`powermetrics_thermal_pressure_level{hw_model="Mac16,8",os_build="24F74"} 1` + "\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected output to contain %q", want)
+		}
+	}
+
+	// Every family has HELP and TYPE lines, and DVFM states are ordered by
+	// frequency.
+	for _, family := range c.Families() {
+		if family.Help == "" || (family.Type != Counter && family.Type != Gauge) {
+			t.Errorf("Expected %s to have help and a type, got %q and %q", family.Name, family.Help, family.Type)
+		}
+		if family.Name == "powermetrics_gpu_dvfm_residency_ratio" {
+			if first := lastLabel(family.Series[0]); first != "338" {
+				t.Errorf("Expected the lowest frequency first, got %s", first)
+			}
+		}
+	}
+}
+
+func TestWriteTextEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteText(&buf, []Family{{
+		Name: "test_metric",
+		Help: "Help with \\ and\nnewline.",
+		Type: Gauge,
+		Series: []Series{
+			{Labels: []Label{{"value", "a \"quoted\" \\ value\n"}}, Value: 0.5},
+			{Value: 1e21},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	want := "# HELP test_metric Help with \\\\ and\\nnewline.\n" +
+		"# TYPE test_metric gauge\n" +
+		"test_metric{value=\"a \\\"quoted\\\" \\\\ value\\n\"} 0.5\n" +
+		"test_metric 1e+21\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	c := NewCollector()
+	for _, sample := range sampletest.Read(t, sampletest.GPUPower) {
+		c.Observe(sample)
+	}
+
+	recorder := httptest.NewRecorder()
+	c.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected content type %q, got %q", ContentType, ct)
+	}
+	if !strings.Contains(recorder.Body.String(), "powermetrics_gpu_power_watts{") {
+		t.Errorf("Expected the GPU power gauge, got:\n%s", recorder.Body.String())
+	}
+}
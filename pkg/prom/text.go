@@ -0,0 +1,54 @@
+package prom
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// WriteText writes families in the Prometheus text exposition format, with
+// a HELP and TYPE line before the series of every family.
+func WriteText(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		bw.WriteString("# HELP " + family.Name + " " + helpEscaper.Replace(family.Help) + "\n")
+		bw.WriteString("# TYPE " + family.Name + " " + string(family.Type) + "\n")
+		for _, series := range family.Series {
+			bw.WriteString(family.Name)
+			if len(series.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range series.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(label.Name + `="` + labelEscaper.Replace(label.Value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatValue(series.Value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,311 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/batch"
+	"github.com/matiasinsaurralde/powermetrics/pkg/prom"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Client defaults
+const (
+	DefaultMaxSamplesPerSend = 2000
+	DefaultFlushInterval     = 15 * time.Second
+	DefaultMaxRetries        = 3
+	DefaultRetryBackoff      = time.Second
+	DefaultMaxQueuedRequests = 10000
+)
+
+// Options configures a Client.
+type Options struct {
+	// Client sends the requests. Nil means http.DefaultClient.
+	Client *http.Client
+	// Headers are added to every request, e.g. Authorization or
+	// X-Scope-OrgID.
+	Headers map[string]string
+	// ExtraLabels are added to every series, e.g. instance.
+	ExtraLabels []prom.Label
+	// MaxSamplesPerSend is the number of samples that triggers a flush.
+	// Zero means DefaultMaxSamplesPerSend.
+	MaxSamplesPerSend int
+	// FlushInterval is how often Run flushes incomplete batches. Zero means
+	// DefaultFlushInterval.
+	FlushInterval time.Duration
+	// MaxRetries is the number of retries of a request after a network
+	// error, a 429 or a 5xx response. Zero means DefaultMaxRetries, and a
+	// negative value disables retries. A request that still fails stays
+	// queued for the next flush.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled on every
+	// retry. A Retry-After header takes precedence. Zero means
+	// DefaultRetryBackoff.
+	RetryBackoff time.Duration
+	// QueueDir, when set, is a directory where requests are queued until
+	// they are sent, so that they survive restarts. Requests left by a
+	// previous process are sent first.
+	QueueDir string
+	// MaxQueuedRequests is the number of queued requests beyond which the
+	// oldest are dropped. Zero means DefaultMaxQueuedRequests.
+	MaxQueuedRequests int
+	// OnError, when set, is called by Run with the error of every failed
+	// flush.
+	OnError func(error)
+}
+
+// Client batches samples into remote-write requests and sends them. It is
+// safe for concurrent use.
+type Client struct {
+	url       string
+	opts      Options
+	collector *prom.Collector
+	queue     *queue
+
+	mu      sync.Mutex
+	pending []TimeSeries
+	// open is the timestamp in milliseconds of the samples observed since
+	// series were last appended to pending, or zero. closed is the
+	// timestamp of the series appended last.
+	open, closed int64
+
+	// sendMu serializes the draining of the queue.
+	sendMu sync.Mutex
+}
+
+// NewClient returns a client sending to the remote-write endpoint rawURL,
+// such as http://localhost:9090/api/v1/write.
+func NewClient(rawURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote-write URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid remote-write URL %q: scheme must be http or https", rawURL)
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.MaxSamplesPerSend <= 0 {
+		opts.MaxSamplesPerSend = DefaultMaxSamplesPerSend
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+	if opts.MaxQueuedRequests <= 0 {
+		opts.MaxQueuedRequests = DefaultMaxQueuedRequests
+	}
+	q, err := openQueue(opts.QueueDir, opts.MaxQueuedRequests)
+	if err != nil {
+		return nil, fmt.Errorf("remote-write queue: %w", err)
+	}
+	return &Client{url: u.String(), opts: opts, collector: prom.NewCollector(), queue: q}, nil
+}
+
+// Write adds sample to the collector, at the timestamp of the sample or the
+// current time when it has none, and flushes the batch once it holds
+// MaxSamplesPerSend samples.
+//
+// Every sampler of a powermetrics sample yields a sample with the same
+// timestamp, and a series may only have one value per timestamp, so the
+// collector's series are added to the batch once per timestamp: when a
+// sample with another timestamp is written, or on Flush. Samples with the
+// timestamp of series already added are reflected in the series of the
+// next timestamp.
+func (c *Client) Write(ctx context.Context, sample types.Sample) error {
+	ts := sample.GetTimestamp()
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	ms := ts.UnixMilli()
+
+	c.mu.Lock()
+	if c.open != 0 && c.open != ms {
+		c.closeTimestamp()
+	}
+	c.collector.Observe(sample)
+	if ms != c.closed {
+		c.open = ms
+	}
+	full := len(c.pending) >= c.opts.MaxSamplesPerSend
+	c.mu.Unlock()
+
+	if full {
+		return c.Flush(ctx)
+	}
+	return nil
+}
+
+// closeTimestamp appends the collector's series at the open timestamp to
+// the batch. c.mu must be held.
+func (c *Client) closeTimestamp() {
+	c.pending = append(c.pending, FromFamilies(c.collector.Families(), time.UnixMilli(c.open), c.opts.ExtraLabels)...)
+	c.closed, c.open = c.open, 0
+}
+
+// Flush queues the batch as a request and sends the queued requests in
+// order. Requests rejected with a 4xx status other than 429 are dropped;
+// other failures leave the request and those after it queued.
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	if c.open != 0 {
+		c.closeTimestamp()
+	}
+	batch := c.pending
+	c.pending = nil
+	var metadata []Metadata
+	if len(batch) > 0 {
+		metadata = FamilyMetadata(c.collector.Families())
+	}
+	c.mu.Unlock()
+
+	if len(batch) > 0 {
+		data := snappy.Encode(nil, AppendWriteRequest(nil, batch, metadata))
+		if err := c.queue.push(data); err != nil {
+			return fmt.Errorf("remote-write queue: %w", err)
+		}
+	}
+	return c.drain(ctx)
+}
+
+// Queued returns the number of requests waiting to be sent.
+func (c *Client) Queued() int {
+	return c.queue.len()
+}
+
+// Dropped returns the number of requests dropped because the queue was
+// full or a segment could not be read.
+func (c *Client) Dropped() int {
+	c.queue.mu.Lock()
+	defer c.queue.mu.Unlock()
+	return c.queue.dropped
+}
+
+func (c *Client) drain(ctx context.Context) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	var rejected error
+	for {
+		data, seq, ok := c.queue.peek()
+		if !ok {
+			return rejected
+		}
+		err := c.send(ctx, data)
+		var status *StatusError
+		if err != nil && !(errors.As(err, &status) && !status.temporary()) {
+			return err
+		}
+		// Sent, or rejected for good.
+		c.queue.remove(seq)
+		if err != nil {
+			rejected = err
+		}
+	}
+}
+
+// Run writes every sample of stream until the stream ends, flushing at
+// least every FlushInterval, and flushes the last batch. Failed flushes are
+// reported to OnError; Run returns the error of the last flush. Requests of
+// the last batch that cannot be sent within FlushInterval stay in QueueDir,
+// if set. The stream's samples must not be consumed elsewhere.
+func (c *Client) Run(ctx context.Context, stream *powermetrics.Stream) error {
+	return batch.Run(ctx, stream.Samples(), c, c.opts.FlushInterval, c.opts.OnError)
+}
+
+// StatusError is returned for a request rejected by the endpoint.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("remote write failed: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("remote write failed: %s: %s", http.StatusText(e.StatusCode), e.Body)
+}
+
+// temporary reports whether the request may succeed when retried.
+func (e *StatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func (c *Client) send(ctx context.Context, data []byte) error {
+	backoff := c.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.post(ctx, data)
+		if err == nil {
+			return nil
+		}
+		var status *StatusError
+		if errors.As(err, &status) && !status.temporary() {
+			return err
+		}
+		if attempt >= c.opts.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		backoff *= 2
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// post sends one request. It returns the delay requested by a Retry-After
+// header, if any.
+func (c *Client) post(ctx context.Context, data []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range c.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("remote write failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return 0, nil
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return retryAfter, &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+}
@@ -0,0 +1,161 @@
+package remotewrite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// segmentExt is the extension of queued requests in a queue directory.
+const segmentExt = ".snappy"
+
+// queue holds compressed requests in the order they are to be sent. A
+// directory queue stores each request in a segment file named after its
+// sequence number, so that requests survive restarts; a memory queue keeps
+// them in a slice. When the queue is full, the oldest request is dropped.
+type queue struct {
+	dir string
+	max int
+
+	mu       sync.Mutex
+	segments []uint64 // sequence numbers of the directory queue, oldest first
+	next     uint64   // sequence number of the next segment
+	memory   [][]byte
+	head     uint64 // sequence number of memory[0]
+	dropped  int
+}
+
+// openQueue returns a queue of at most max requests, stored in dir unless
+// it is empty. Requests queued in dir by a previous process are kept.
+func openQueue(dir string, max int) (*queue, error) {
+	q := &queue{dir: dir, max: max}
+	if dir == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, segmentExt+".tmp") {
+			// Left by a crash while writing.
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, seq)
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+	if n := len(q.segments); n > 0 {
+		q.next = q.segments[n-1] + 1
+	}
+	return q, nil
+}
+
+func (q *queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// push appends a request, dropping the oldest one when the queue is full.
+func (q *queue) push(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.dir == "" {
+		q.memory = append(q.memory, data)
+		if len(q.memory) > q.max {
+			q.memory = q.memory[1:]
+			q.head++
+			q.dropped++
+		}
+		return nil
+	}
+
+	// The segment is renamed into place once complete, so that a crash
+	// never leaves a partial request in the queue.
+	seq := q.next
+	tmp := q.path(seq) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path(seq)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	q.next++
+	q.segments = append(q.segments, seq)
+	for len(q.segments) > q.max {
+		_ = os.Remove(q.path(q.segments[0]))
+		q.segments = q.segments[1:]
+		q.dropped++
+	}
+	return nil
+}
+
+// peek returns the oldest request and its sequence number, or false when
+// the queue is empty. Segments that cannot be read are dropped.
+func (q *queue) peek() ([]byte, uint64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.dir == "" {
+		if len(q.memory) == 0 {
+			return nil, 0, false
+		}
+		return q.memory[0], q.head, true
+	}
+	for len(q.segments) > 0 {
+		seq := q.segments[0]
+		data, err := os.ReadFile(q.path(seq))
+		if err == nil {
+			return data, seq, true
+		}
+		_ = os.Remove(q.path(seq))
+		q.segments = q.segments[1:]
+		q.dropped++
+	}
+	return nil, 0, false
+}
+
+// remove removes the request returned by peek, unless it was already
+// dropped to make room.
+func (q *queue) remove(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.dir == "" {
+		if len(q.memory) > 0 && q.head == seq {
+			q.memory = q.memory[1:]
+			q.head++
+		}
+		return
+	}
+	if len(q.segments) > 0 && q.segments[0] == seq {
+		_ = os.Remove(q.path(seq))
+		q.segments = q.segments[1:]
+	}
+}
+
+// len returns the number of queued requests.
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.dir == "" {
+		return len(q.memory)
+	}
+	return len(q.segments)
+}
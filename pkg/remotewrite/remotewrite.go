@@ -0,0 +1,153 @@
+// Package remotewrite pushes powermetrics samples to a Prometheus
+// remote-write endpoint, such as Prometheus with the remote-write receiver
+// enabled, Mimir, Thanos or VictoriaMetrics.
+//
+// Samples are accumulated by a prom.Collector, and once the samples of a
+// timestamp have been observed, the collector's families are appended to the
+// batch at that timestamp, as if they had been scraped then. Batches are
+// encoded as remote-write 1.0 requests, protobuf compressed with snappy, and
+// queued before they are sent, in memory or in a directory that survives
+// restarts, so that samples taken during an outage are delivered once the
+// endpoint is back.
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/prom"
+)
+
+// TimeSeries is a series of a remote-write request.
+type TimeSeries struct {
+	// Labels include __name__ and are sorted by name.
+	Labels  []prom.Label
+	Samples []Sample
+}
+
+// Sample is a value of a series at a time.
+type Sample struct {
+	Value       float64
+	TimestampMS int64
+}
+
+// FromFamilies returns a series per series of families, with one sample at
+// ts. The extra labels, such as instance, are added to every series; labels
+// with empty values are omitted, as Prometheus does.
+func FromFamilies(families []prom.Family, ts time.Time, extra []prom.Label) []TimeSeries {
+	var series []TimeSeries
+	for _, family := range families {
+		for _, s := range family.Series {
+			labels := make([]prom.Label, 0, len(s.Labels)+len(extra)+1)
+			labels = append(labels, prom.Label{Name: "__name__", Value: family.Name})
+			for _, set := range [][]prom.Label{s.Labels, extra} {
+				for _, label := range set {
+					if label.Value != "" {
+						labels = append(labels, label)
+					}
+				}
+			}
+			sort.SliceStable(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+			series = append(series, TimeSeries{
+				Labels:  labels,
+				Samples: []Sample{{Value: s.Value, TimestampMS: ts.UnixMilli()}},
+			})
+		}
+	}
+	return series
+}
+
+// Metadata describes a metric family.
+type Metadata struct {
+	Name string
+	Type prom.Type
+	Help string
+}
+
+// FamilyMetadata returns the metadata of families.
+func FamilyMetadata(families []prom.Family) []Metadata {
+	metadata := make([]Metadata, 0, len(families))
+	for _, family := range families {
+		metadata = append(metadata, Metadata{Name: family.Name, Type: family.Type, Help: family.Help})
+	}
+	return metadata
+}
+
+// Field numbers of the remote-write 1.0 messages
+const (
+	writeRequestTimeseries protowire.Number = 1
+	writeRequestMetadata   protowire.Number = 3
+
+	timeSeriesLabels  protowire.Number = 1
+	timeSeriesSamples protowire.Number = 2
+
+	labelName  protowire.Number = 1
+	labelValue protowire.Number = 2
+
+	sampleValue     protowire.Number = 1
+	sampleTimestamp protowire.Number = 2
+
+	metadataType protowire.Number = 1
+	metadataName protowire.Number = 2
+	metadataHelp protowire.Number = 4
+)
+
+// MetricMetadata.MetricType values
+var metadataTypes = map[prom.Type]uint64{
+	prom.Counter: 1,
+	prom.Gauge:   2,
+}
+
+// AppendWriteRequest appends the protobuf encoding of a WriteRequest holding
+// series and metadata to b.
+func AppendWriteRequest(b []byte, series []TimeSeries, metadata []Metadata) []byte {
+	var msg []byte
+	for _, ts := range series {
+		msg = msg[:0]
+		for _, label := range ts.Labels {
+			var l []byte
+			l = appendString(l, labelName, label.Name)
+			l = appendString(l, labelValue, label.Value)
+			msg = protowire.AppendTag(msg, timeSeriesLabels, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, l)
+		}
+		for _, sample := range ts.Samples {
+			var s []byte
+			if sample.Value != 0 || math.Signbit(sample.Value) {
+				s = protowire.AppendTag(s, sampleValue, protowire.Fixed64Type)
+				s = protowire.AppendFixed64(s, math.Float64bits(sample.Value))
+			}
+			if sample.TimestampMS != 0 {
+				s = protowire.AppendTag(s, sampleTimestamp, protowire.VarintType)
+				s = protowire.AppendVarint(s, uint64(sample.TimestampMS))
+			}
+			msg = protowire.AppendTag(msg, timeSeriesSamples, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, s)
+		}
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	for _, m := range metadata {
+		msg = msg[:0]
+		if typ := metadataTypes[m.Type]; typ != 0 {
+			msg = protowire.AppendTag(msg, metadataType, protowire.VarintType)
+			msg = protowire.AppendVarint(msg, typ)
+		}
+		msg = appendString(msg, metadataName, m.Name)
+		msg = appendString(msg, metadataHelp, m.Help)
+		b = protowire.AppendTag(b, writeRequestMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, msg)
+	}
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
@@ -0,0 +1,457 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/prom"
+)
+
+// request is a decoded WriteRequest.
+type request struct {
+	series   []TimeSeries
+	metadata []Metadata
+}
+
+// receiver is a stand-in remote-write endpoint. It answers with the
+// queued statuses, then 204.
+type receiver struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	requests []request
+	headers  http.Header
+	// values holds the accepted value of every series at every timestamp.
+	values map[string]float64
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{t: t, statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) handle(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	r.headers = req.Header.Clone()
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		http.Error(w, "unavailable", status)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		r.t.Errorf("Failed to decompress request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	decoded := decodeWriteRequest(r.t, data)
+	// Like Prometheus, reject another value of a series at a timestamp.
+	values := make(map[string]float64)
+	for _, ts := range decoded.series {
+		for _, sample := range ts.Samples {
+			key := fmt.Sprintf("%v@%d", ts.Labels, sample.TimestampMS)
+			v, ok := values[key]
+			if !ok {
+				v, ok = r.values[key]
+			}
+			if ok && v != sample.Value {
+				http.Error(w, "duplicate sample for timestamp: "+key, http.StatusBadRequest)
+				return
+			}
+			values[key] = sample.Value
+		}
+	}
+	if r.values == nil {
+		r.values = make(map[string]float64)
+	}
+	for key, v := range values {
+		r.values[key] = v
+	}
+	r.requests = append(r.requests, decoded)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fields calls fn for every field of the message b.
+func fields(t *testing.T, b []byte, fn func(num protowire.Number, v uint64, b []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		var v uint64
+		var bytes []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("Unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatalf("Invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		fn(num, v, bytes)
+	}
+}
+
+func decodeWriteRequest(t *testing.T, data []byte) request {
+	var req request
+	fields(t, data, func(num protowire.Number, _ uint64, b []byte) {
+		switch num {
+		case writeRequestTimeseries:
+			var ts TimeSeries
+			fields(t, b, func(num protowire.Number, _ uint64, b []byte) {
+				switch num {
+				case timeSeriesLabels:
+					var label prom.Label
+					fields(t, b, func(num protowire.Number, _ uint64, b []byte) {
+						if num == labelName {
+							label.Name = string(b)
+						} else {
+							label.Value = string(b)
+						}
+					})
+					ts.Labels = append(ts.Labels, label)
+				case timeSeriesSamples:
+					var sample Sample
+					fields(t, b, func(num protowire.Number, v uint64, _ []byte) {
+						if num == sampleValue {
+							sample.Value = math.Float64frombits(v)
+						} else {
+							sample.TimestampMS = int64(v)
+						}
+					})
+					ts.Samples = append(ts.Samples, sample)
+				}
+			})
+			req.series = append(req.series, ts)
+		case writeRequestMetadata:
+			var m Metadata
+			fields(t, b, func(num protowire.Number, v uint64, b []byte) {
+				switch num {
+				case metadataType:
+					m.Type = map[uint64]prom.Type{1: prom.Counter, 2: prom.Gauge}[v]
+				case metadataName:
+					m.Name = string(b)
+				case metadataHelp:
+					m.Help = string(b)
+				}
+			})
+			req.metadata = append(req.metadata, m)
+		}
+	})
+	return req
+}
+
+// find returns the series named name whose labels include labels.
+func find(series []TimeSeries, name string, labels ...prom.Label) *TimeSeries {
+	for i := range series {
+		ok := true
+		for _, want := range append([]prom.Label{{Name: "__name__", Value: name}}, labels...) {
+			found := false
+			for _, label := range series[i].Labels {
+				found = found || label == want
+			}
+			ok = ok && found
+		}
+		if ok {
+			return &series[i]
+		}
+	}
+	return nil
+}
+
+func TestFromFamilies(t *testing.T) {
+	families := []prom.Family{{
+		Name: "powermetrics_gpu_idle_ratio",
+		Type: prom.Gauge,
+		Series: []prom.Series{
+			{Labels: []prom.Label{{Name: "hw_model", Value: "Mac16,8"}, {Name: "os_build", Value: ""}}, Value: 0.5},
+		},
+	}}
+	ts := time.Date(2025, 7, 6, 5, 15, 15, 0, time.UTC)
+	series := FromFamilies(families, ts, []prom.Label{{Name: "instance", Value: "mac-1"}})
+	if len(series) != 1 {
+		t.Fatalf("Expected 1 series, got %d", len(series))
+	}
+	want := []prom.Label{
+		{Name: "__name__", Value: "powermetrics_gpu_idle_ratio"},
+		{Name: "hw_model", Value: "Mac16,8"},
+		{Name: "instance", Value: "mac-1"},
+	}
+	if len(series[0].Labels) != len(want) {
+		t.Fatalf("Expected labels %v, got %v", want, series[0].Labels)
+	}
+	for i := range want {
+		if series[0].Labels[i] != want[i] {
+			t.Errorf("Expected labels %v, got %v", want, series[0].Labels)
+			break
+		}
+	}
+	if got := series[0].Samples; len(got) != 1 || got[0] != (Sample{0.5, ts.UnixMilli()}) {
+		t.Errorf("Expected one sample at %d, got %v", ts.UnixMilli(), got)
+	}
+}
+
+func TestClient(t *testing.T) {
+	recv := newReceiver(t)
+	client, err := NewClient(recv.URL, Options{
+		Headers:     map[string]string{"X-Scope-OrgID": "team"},
+		ExtraLabels: []prom.Label{{Name: "instance", Value: "mac-1"}},
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	samples := sampletest.Read(t, sampletest.GPUPower)
+	for _, sample := range samples {
+		if err := client.Write(t.Context(), sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := client.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if len(recv.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(recv.requests))
+	}
+	for header, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-Orgid":                     "team",
+	} {
+		if got := recv.headers.Get(header); got != want {
+			t.Errorf("Expected %s %q, got %q", header, want, got)
+		}
+	}
+
+	req := recv.requests[0]
+	first := samples[0].GetTimestamp().UnixMilli()
+	series := find(req.series, "powermetrics_gpu_energy_joules_total",
+		prom.Label{Name: "hw_model", Value: "Mac16,8"}, prom.Label{Name: "instance", Value: "mac-1"})
+	if series == nil {
+		t.Fatal("Expected a GPU energy series")
+	}
+	if got := series.Samples[0]; got.TimestampMS != first || math.Abs(got.Value-0.019) > 1e-9 {
+		t.Errorf("Expected 0.019 J at %d, got %v", first, got)
+	}
+	// Every sample yields one value per series.
+	var energy []Sample
+	for _, ts := range req.series {
+		if ts.Labels[0].Value == "powermetrics_gpu_energy_joules_total" {
+			energy = append(energy, ts.Samples...)
+		}
+	}
+	if len(energy) != len(samples) {
+		t.Errorf("Expected %d energy samples, got %d", len(samples), len(energy))
+	}
+	for i := 1; i < len(energy); i++ {
+		if energy[i].Value < energy[i-1].Value || energy[i].TimestampMS <= energy[i-1].TimestampMS {
+			t.Errorf("Expected a growing counter, got %v", energy)
+			break
+		}
+	}
+	found := false
+	for _, m := range req.metadata {
+		found = found || m == Metadata{"powermetrics_gpu_energy_joules_total", prom.Counter, "Energy used by the GPU."}
+	}
+	if !found {
+		t.Errorf("Expected counter metadata, got %v", req.metadata)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+		wantQueued   int
+	}{
+		{"success after retries", []int{503, 429}, 3, false, 0},
+		{"retries exhausted", []int{500, 500, 500, 500}, 4, true, 1},
+		{"rejected", []int{400}, 1, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recv := newReceiver(t, tt.statuses...)
+			client, err := NewClient(recv.URL, Options{RetryBackoff: time.Millisecond})
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+			sample := sampletest.Read(t, sampletest.GPUPower)[0]
+			if err := client.Write(t.Context(), sample); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			err = client.Flush(t.Context())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			var status *StatusError
+			if tt.wantErr && !errors.As(err, &status) {
+				t.Errorf("Expected a StatusError, got %v", err)
+			}
+			if recv.attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, recv.attempts)
+			}
+			if client.Queued() != tt.wantQueued {
+				t.Errorf("Expected %d queued requests, got %d", tt.wantQueued, client.Queued())
+			}
+		})
+	}
+}
+
+func TestClientQueueDir(t *testing.T) {
+	dir := t.TempDir()
+	samples := sampletest.Read(t, sampletest.GPUPower)
+
+	// The endpoint is down: requests stay on disk.
+	down := newReceiver(t, 503, 503)
+	client, err := NewClient(down.URL, Options{QueueDir: dir, MaxRetries: -1})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	for _, sample := range samples[:2] {
+		if err := client.Write(t.Context(), sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := client.Flush(t.Context()); err == nil {
+			t.Fatal("Expected Flush to fail")
+		}
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(segments) != 2 {
+		t.Fatalf("Expected 2 queued segments, got %v", segments)
+	}
+
+	// A new process sends them in order once the endpoint is back.
+	up := newReceiver(t)
+	client, err = NewClient(up.URL, Options{QueueDir: dir})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if client.Queued() != 2 {
+		t.Fatalf("Expected 2 queued requests, got %d", client.Queued())
+	}
+	if err := client.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if len(up.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(up.requests))
+	}
+	for i, req := range up.requests {
+		series := find(req.series, "powermetrics_gpu_idle_ratio")
+		if series == nil || series.Samples[0].TimestampMS != samples[i].GetTimestamp().UnixMilli() {
+			t.Errorf("Expected request %d to hold sample %d", i, i)
+		}
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*")); len(segments) != 0 {
+		t.Errorf("Expected an empty queue directory, got %v", segments)
+	}
+}
+
+func TestQueueDropsOldest(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		q, err := openQueue(dir, 2)
+		if err != nil {
+			t.Fatalf("openQueue failed: %v", err)
+		}
+		for _, data := range []string{"a", "b", "c"} {
+			if err := q.push([]byte(data)); err != nil {
+				t.Fatalf("push failed: %v", err)
+			}
+		}
+		if q.len() != 2 || q.dropped != 1 {
+			t.Errorf("Expected 2 requests and 1 dropped, got %d and %d", q.len(), q.dropped)
+		}
+		data, seq, ok := q.peek()
+		if !ok || string(data) != "b" {
+			t.Errorf("Expected b first, got %q", data)
+		}
+		// A request dropped while it was being sent is not removed twice.
+		if err := q.push([]byte("d")); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+		q.remove(seq)
+		if data, _, _ := q.peek(); string(data) != "c" || q.len() != 2 {
+			t.Errorf("Expected c first of 2, got %q of %d", data, q.len())
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	tests := []struct {
+		name     string
+		samplers []powermetrics.Sampler
+	}{
+		{"gpu_power", []powermetrics.Sampler{powermetrics.GPUPower}},
+		// Both samplers yield a sample per document, with one timestamp.
+		{"gpu_power and tasks", []powermetrics.Sampler{powermetrics.GPUPower, powermetrics.Tasks}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := powermetrics.NewWithRunner(&powermetrics.MockCommandRunner{Output: data})
+			stream, err := pm.Stream(t.Context(), &powermetrics.Config{Samplers: tt.samplers})
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+			recv := newReceiver(t)
+			client, err := NewClient(recv.URL, Options{MaxSamplesPerSend: 100})
+			if err != nil {
+				t.Fatalf("NewClient failed: %v", err)
+			}
+			if err := client.Run(context.Background(), stream); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if len(recv.requests) == 0 {
+				t.Fatal("Expected requests")
+			}
+			var series []TimeSeries
+			for _, req := range recv.requests {
+				series = append(series, req.series...)
+			}
+			for _, sampler := range tt.samplers {
+				samples := 0
+				for _, ts := range series {
+					if find([]TimeSeries{ts}, "powermetrics_samples_total", prom.Label{Name: "sampler", Value: string(sampler)}) != nil {
+						samples++
+					}
+				}
+				if samples != 5 {
+					t.Errorf("Expected 5 samples_total values of %s, got %d", sampler, samples)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Query returns the stored samples of all samplers taken from from up to,
+// but excluding, to, ordered by timestamp. Samples without a timestamp are
+// never returned. Batches not flushed yet are not included.
+//
+// The samples and their states and tasks are loaded in a read-only
+// transaction, so that batches committed meanwhile are either loaded whole
+// or not at all.
+func (s *Store) Query(ctx context.Context, from, to time.Time) ([]types.Sample, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("query samples: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	q := &query{ctx: ctx, tx: tx, store: s, from: from.UnixNano(), to: to.UnixNano()}
+	var samples []types.Sample
+	for _, load := range []func() ([]types.Sample, error){q.gpuPower, q.tasks, q.battery, q.thermal} {
+		loaded, err := load()
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, loaded...)
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].GetTimestamp().Before(samples[j].GetTimestamp())
+	})
+	return samples, nil
+}
+
+// query loads the rows of one time range.
+type query struct {
+	ctx      context.Context
+	tx       *sql.Tx
+	store    *Store
+	from, to int64
+}
+
+// rows queries the rows of the sampler table t in the time range, or with
+// child set, the rows of the child table of t whose samples are in the
+// time range. Parameters are the bounds of the range.
+func (q *query) rows(t, child table, orderBy string) (*sql.Rows, error) {
+	prefix, placeholder := q.store.opts.TablePrefix, q.store.opts.Dialect.Placeholder
+	where := fmt.Sprintf("s.timestamp_ns >= %s AND s.timestamp_ns < %s", placeholder(1), placeholder(2))
+	var query string
+	if child.name == "" {
+		query = fmt.Sprintf("SELECT %s FROM %s%s s WHERE %s ORDER BY %s",
+			t.columnList("s"), prefix, t.name, where, orderBy)
+	} else {
+		query = fmt.Sprintf("SELECT %s FROM %s%s c JOIN %s%s s ON c.sample_id = s.id WHERE %s ORDER BY %s",
+			child.columnList("c"), prefix, child.name, prefix, t.name, where, orderBy)
+		t = child
+	}
+	rows, err := q.tx.QueryContext(q.ctx, query, q.from, q.to)
+	if err != nil {
+		return nil, fmt.Errorf("query %s%s: %w", prefix, t.name, err)
+	}
+	return rows, nil
+}
+
+// each calls scan for every row, wrapping errors with the table name.
+func (q *query) each(t, child table, orderBy string, scan func(*sql.Rows) error) error {
+	rows, err := q.rows(t, child, orderBy)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	name := t.name
+	if child.name != "" {
+		name = child.name
+	}
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return fmt.Errorf("query %s%s: %w", q.store.opts.TablePrefix, name, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query %s%s: %w", q.store.opts.TablePrefix, name, err)
+	}
+	return nil
+}
+
+// header holds the header columns of a row.
+type header struct {
+	id        int64
+	timestamp sql.NullInt64
+	base      types.BaseSample
+}
+
+func (h *header) dest() []any {
+	return []any{
+		&h.id,
+		&h.timestamp,
+		&h.base.ElapsedNS,
+		&h.base.HWModel,
+		&h.base.KernOSVer,
+		&h.base.KernBootArgs,
+		&h.base.KernBootTime,
+		&h.base.IsDelta,
+	}
+}
+
+func (h *header) sample() types.BaseSample {
+	base := h.base
+	if h.timestamp.Valid {
+		base.Timestamp = time.Unix(0, h.timestamp.Int64).UTC()
+	}
+	return base
+}
+
+func taskDest(t *types.TaskInfo) []any {
+	return []any{
+		&t.PID,
+		&t.Name,
+		&t.IntervalNS,
+		&t.CPUTimeNS,
+		&t.CPUTimeMSPerS,
+		&t.CPUTimeUserlandRatio,
+		&t.IntrWakeups,
+		&t.IntrWakeupsPerS,
+		&t.IdleWakeups,
+		&t.IdleWakeupsPerS,
+		&t.DiskIOBytesRead,
+		&t.DiskIOBytesWritten,
+		&t.PacketsReceived,
+		&t.PacketsSent,
+		&t.BytesReceived,
+		&t.BytesSent,
+		&t.EnergyImpact,
+		&t.EnergyImpactPerS,
+	}
+}
+
+func (q *query) gpuPower() ([]types.Sample, error) {
+	var samples []types.Sample
+	byID := make(map[int64]*types.GPUPowerSample)
+	err := q.each(gpuPowerTable, table{}, "s.timestamp_ns, s.id", func(rows *sql.Rows) error {
+		var h header
+		var energy sql.NullInt64
+		s := &types.GPUPowerSample{}
+		if err := rows.Scan(append(h.dest(), &s.GPU.FreqHz, &s.GPU.IdleNS, &s.GPU.IdleRatio, &energy)...); err != nil {
+			return err
+		}
+		s.BaseSample = h.sample()
+		if energy.Valid {
+			s.GPU.GPUEnergy = &energy.Int64
+		}
+		samples = append(samples, s)
+		byID[h.id] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.each(gpuPowerTable, gpuStatesTable, "c.sample_id, c.kind, c.position", func(rows *sql.Rows) error {
+		var (
+			id, position, freq, usedNS int64
+			kind, state                string
+			usedRatio                  float64
+		)
+		if err := rows.Scan(&id, &kind, &position, &state, &freq, &usedNS, &usedRatio); err != nil {
+			return err
+		}
+		s := byID[id]
+		if s == nil {
+			return fmt.Errorf("state of unknown sample %d", id)
+		}
+		switch kind {
+		case StateDVFM:
+			s.GPU.DVFMStates = append(s.GPU.DVFMStates, types.DVFMState{Freq: freq, UsedNS: usedNS, UsedRatio: usedRatio})
+		case StateSWRequested:
+			s.GPU.SWRequestedState = append(s.GPU.SWRequestedState, types.SWReqState{SWReqState: state, UsedNS: usedNS, UsedRatio: usedRatio})
+		case StateSW:
+			s.GPU.SWState = append(s.GPU.SWState, types.SWState{SWState: state, UsedNS: usedNS, UsedRatio: usedRatio})
+		default:
+			return fmt.Errorf("unknown state kind %q", kind)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func (q *query) tasks() ([]types.Sample, error) {
+	var samples []types.Sample
+	byID := make(map[int64]*types.TasksSample)
+	err := q.each(tasksTable, table{}, "s.timestamp_ns, s.id", func(rows *sql.Rows) error {
+		var h header
+		s := &types.TasksSample{}
+		if err := rows.Scan(append(h.dest(), taskDest(&s.AllTasks)...)...); err != nil {
+			return err
+		}
+		s.BaseSample = h.sample()
+		samples = append(samples, s)
+		byID[h.id] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.each(tasksTable, taskProcessesTable, "c.sample_id, c.position", func(rows *sql.Rows) error {
+		var id, position int64
+		var task types.TaskInfo
+		if err := rows.Scan(append([]any{&id, &position}, taskDest(&task)...)...); err != nil {
+			return err
+		}
+		s := byID[id]
+		if s == nil {
+			return fmt.Errorf("task of unknown sample %d", id)
+		}
+		s.Tasks = append(s.Tasks, task)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func (q *query) battery() ([]types.Sample, error) {
+	var samples []types.Sample
+	err := q.each(batteryTable, table{}, "s.timestamp_ns, s.id", func(rows *sql.Rows) error {
+		var h header
+		s := &types.BatterySample{}
+		if err := rows.Scan(append(h.dest(), &s.Battery.PercentCharge)...); err != nil {
+			return err
+		}
+		s.BaseSample = h.sample()
+		samples = append(samples, s)
+		return nil
+	})
+	return samples, err
+}
+
+func (q *query) thermal() ([]types.Sample, error) {
+	var samples []types.Sample
+	err := q.each(thermalTable, table{}, "s.timestamp_ns, s.id", func(rows *sql.Rows) error {
+		var h header
+		s := &types.ThermalSample{}
+		if err := rows.Scan(append(h.dest(), &s.ThermalPressure)...); err != nil {
+			return err
+		}
+		s.BaseSample = h.sample()
+		samples = append(samples, s)
+		return nil
+	})
+	return samples, err
+}
@@ -0,0 +1,188 @@
+// Package sqlstore stores powermetrics samples in a relational database
+// through database/sql, and loads them back for a time range.
+//
+// Every sampler has a table, named after the sampler with a prefix,
+// powermetrics_ by default, whose rows hold the sample header and the
+// sampler's scalar values. The lists of a sample are child rows referencing
+// the id of its row: the DVFM and software state residencies of gpu_power
+// samples in gpu_states, and the tasks of tasks samples in task_processes:
+//
+//	SELECT g.timestamp_ns, s.frequency_mhz, s.used_ratio
+//	FROM powermetrics_gpu_power g
+//	JOIN powermetrics_gpu_states s ON s.sample_id = g.id AND s.kind = 'dvfm'
+//	ORDER BY g.timestamp_ns, s.position;
+//
+// The tables are created when missing, using column types understood by
+// SQLite, PostgreSQL and MySQL, except for the ids of the sampler tables,
+// which the database allocates with the column type of the Dialect.
+// Timestamps are Unix nanoseconds, NULL for samples without one.
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sampler names, which are also the suffixes of the sampler tables
+const (
+	SamplerGPUPower = "gpu_power"
+	SamplerTasks    = "tasks"
+	SamplerBattery  = "battery"
+	SamplerThermal  = "thermal"
+)
+
+// Kinds of the gpu_states rows
+const (
+	StateDVFM        = "dvfm"
+	StateSWRequested = "sw_requested"
+	StateSW          = "sw"
+)
+
+type column struct {
+	name string
+	typ  string
+}
+
+// table is a table definition. The first column of sampler tables is id,
+// allocated by the database, and child tables reference it with sample_id.
+type table struct {
+	name       string
+	columns    []column
+	primaryKey string
+	autoID     bool
+}
+
+var headerColumns = []column{
+	{"id", ""},
+	{"timestamp_ns", "BIGINT"},
+	{"elapsed_ns", "BIGINT NOT NULL"},
+	{"hw_model", "TEXT NOT NULL"},
+	{"kern_osversion", "TEXT NOT NULL"},
+	{"kern_bootargs", "TEXT NOT NULL"},
+	{"kern_boottime", "BIGINT NOT NULL"},
+	{"is_delta", "BOOLEAN NOT NULL"},
+}
+
+var taskColumns = []column{
+	{"pid", "INTEGER NOT NULL"},
+	{"name", "TEXT NOT NULL"},
+	{"interval_ns", "BIGINT NOT NULL"},
+	{"cpu_time_ns", "BIGINT NOT NULL"},
+	{"cpu_time_ms_per_s", "DOUBLE PRECISION NOT NULL"},
+	{"cpu_time_userland_ratio", "DOUBLE PRECISION NOT NULL"},
+	{"interrupt_wakeups", "BIGINT NOT NULL"},
+	{"interrupt_wakeups_per_s", "DOUBLE PRECISION NOT NULL"},
+	{"idle_wakeups", "BIGINT NOT NULL"},
+	{"idle_wakeups_per_s", "DOUBLE PRECISION NOT NULL"},
+	{"disk_read_bytes", "BIGINT NOT NULL"},
+	{"disk_written_bytes", "BIGINT NOT NULL"},
+	{"packets_received", "BIGINT NOT NULL"},
+	{"packets_sent", "BIGINT NOT NULL"},
+	{"bytes_received", "BIGINT NOT NULL"},
+	{"bytes_sent", "BIGINT NOT NULL"},
+	{"energy_impact", "DOUBLE PRECISION NOT NULL"},
+	{"energy_impact_per_s", "DOUBLE PRECISION NOT NULL"},
+}
+
+func samplerTable(name string, columns ...column) table {
+	return table{
+		name:       name,
+		columns:    append(append([]column{}, headerColumns...), columns...),
+		primaryKey: "id",
+		autoID:     true,
+	}
+}
+
+// The tables, in creation order
+var (
+	gpuPowerTable = samplerTable(SamplerGPUPower,
+		column{"frequency_mhz", "DOUBLE PRECISION NOT NULL"},
+		column{"idle_ns", "BIGINT NOT NULL"},
+		column{"idle_ratio", "DOUBLE PRECISION NOT NULL"},
+		column{"energy_mj", "BIGINT"},
+	)
+	gpuStatesTable = table{
+		name: "gpu_states",
+		columns: []column{
+			{"sample_id", "BIGINT NOT NULL"},
+			{"kind", "VARCHAR(16) NOT NULL"},
+			{"position", "INTEGER NOT NULL"},
+			{"state", "TEXT NOT NULL"},
+			{"frequency_mhz", "BIGINT NOT NULL"},
+			{"used_ns", "BIGINT NOT NULL"},
+			{"used_ratio", "DOUBLE PRECISION NOT NULL"},
+		},
+		primaryKey: "sample_id, kind, position",
+	}
+	tasksTable         = samplerTable(SamplerTasks, taskColumns...)
+	taskProcessesTable = table{
+		name: "task_processes",
+		columns: append([]column{
+			{"sample_id", "BIGINT NOT NULL"},
+			{"position", "INTEGER NOT NULL"},
+		}, taskColumns...),
+		primaryKey: "sample_id, position",
+	}
+	batteryTable = samplerTable(SamplerBattery, column{"percent_charge", "INTEGER NOT NULL"})
+	thermalTable = samplerTable(SamplerThermal, column{"pressure", "TEXT NOT NULL"})
+
+	tables = []table{gpuPowerTable, gpuStatesTable, tasksTable, taskProcessesTable, batteryTable, thermalTable}
+)
+
+// createSQL returns the statement creating the table when it is missing.
+func (t table) createSQL(prefix string, d Dialect) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s%s (", prefix, t.name)
+	for _, c := range t.columns {
+		typ := c.typ
+		if t.autoID && c.name == "id" {
+			typ = d.IDType
+		}
+		fmt.Fprintf(&b, "%s %s, ", c.name, typ)
+	}
+	fmt.Fprintf(&b, "PRIMARY KEY (%s))", t.primaryKey)
+	return b.String()
+}
+
+// insertSQL returns the statement inserting a row. The ids of sampler
+// tables are left to the database, and returned by the statement when the
+// dialect uses RETURNING.
+func (t table) insertSQL(prefix string, d Dialect) string {
+	var names, params []string
+	for _, c := range t.columns {
+		if t.autoID && c.name == "id" {
+			continue
+		}
+		names = append(names, c.name)
+		params = append(params, d.Placeholder(len(params)+1))
+	}
+	query := fmt.Sprintf("INSERT INTO %s%s (%s) VALUES (%s)",
+		prefix, t.name, strings.Join(names, ", "), strings.Join(params, ", "))
+	if t.autoID && d.Returning {
+		query += " RETURNING id"
+	}
+	return query
+}
+
+// columnList returns the column names, qualified with alias when it is not
+// empty.
+func (t table) columnList(alias string) string {
+	names := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		names[i] = c.name
+		if alias != "" {
+			names[i] = alias + "." + c.name
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// validPrefix reports whether prefix is safe to use in table names.
+func validPrefix(prefix string) bool {
+	for _, r := range prefix {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
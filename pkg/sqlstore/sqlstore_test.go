@@ -0,0 +1,363 @@
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/jsonfeed"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// testSamples returns samples of every sampler, ordered by timestamp.
+func testSamples(t *testing.T) []types.Sample {
+	samples := sampletest.Samples(t)
+	// Query needs timestamps, which the thermal and battery samples lack.
+	ts := samples[0].GetTimestamp()
+	for _, sample := range samples {
+		switch s := sample.(type) {
+		case *types.ThermalSample:
+			s.Timestamp = ts.Add(time.Millisecond)
+		case *types.BatterySample:
+			s.Timestamp = ts.Add(2 * time.Millisecond)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].GetTimestamp().Before(samples[j].GetTimestamp())
+	})
+	return samples
+}
+
+// encodeJSON returns the records of samples, as a comparable form.
+func encodeJSON(t *testing.T, samples []types.Sample) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := jsonfeed.NewEncoder(&buf)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	return buf.String()
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "samples.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func count(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatalf("Failed to count %s: %v", table, err)
+	}
+	return n
+}
+
+func writeAll(t *testing.T, store *Store, samples []types.Sample) {
+	t.Helper()
+	for _, sample := range samples {
+		if err := store.Write(t.Context(), sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := store.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	db := openDB(t)
+	store, err := Open(t.Context(), db, Options{BatchSize: 3})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	samples := testSamples(t)
+	writeAll(t, store, samples)
+
+	got, err := store.Query(t.Context(), time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != len(samples) {
+		t.Fatalf("Expected %d samples, got %d", len(samples), len(got))
+	}
+	if encodeJSON(t, got) != encodeJSON(t, samples) {
+		t.Error("Expected samples to read back identically")
+	}
+
+	perSampler := make(map[string]int)
+	var first *types.GPUPowerSample
+	for _, sample := range samples {
+		switch s := sample.(type) {
+		case *types.GPUPowerSample:
+			perSampler[SamplerGPUPower]++
+			if first == nil {
+				first = s
+			}
+		case *types.TasksSample:
+			perSampler[SamplerTasks]++
+		case *types.BatterySample:
+			perSampler[SamplerBattery]++
+		case *types.ThermalSample:
+			perSampler[SamplerThermal]++
+		}
+	}
+	for _, tt := range []struct {
+		table string
+		want  int
+	}{
+		{"powermetrics_gpu_power", perSampler[SamplerGPUPower]},
+		{"powermetrics_tasks", perSampler[SamplerTasks]},
+		{"powermetrics_battery", perSampler[SamplerBattery]},
+		{"powermetrics_thermal", perSampler[SamplerThermal]},
+		{"powermetrics_gpu_states WHERE sample_id = 1 AND kind = 'dvfm'", len(first.GPU.DVFMStates)},
+		{"powermetrics_gpu_states WHERE sample_id = 1 AND kind = 'sw'", len(first.GPU.SWState)},
+	} {
+		if got := count(t, db, tt.table); got != tt.want {
+			t.Errorf("Expected %d rows in %s, got %d", tt.want, tt.table, got)
+		}
+	}
+}
+
+func TestQueryRange(t *testing.T) {
+	store, err := Open(t.Context(), openDB(t), Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	gpu := sampletest.Read(t, sampletest.GPUPower)
+	writeAll(t, store, append(gpu, &types.ThermalSample{ThermalPressure: "Nominal"}))
+
+	from := gpu[1].GetTimestamp()
+	got, err := store.Query(t.Context(), from, gpu[2].GetTimestamp())
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if encodeJSON(t, got) != encodeJSON(t, gpu[1:2]) {
+		t.Errorf("Expected the second sample only, got %d samples", len(got))
+	}
+	got, err = store.Query(t.Context(), time.Time{}, from)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != 1 || !got[0].GetTimestamp().Equal(gpu[0].GetTimestamp()) {
+		t.Errorf("Expected the first sample only, got %d samples", len(got))
+	}
+}
+
+func TestReopen(t *testing.T) {
+	db := openDB(t)
+	samples := sampletest.Read(t, sampletest.GPUPower)
+	for _, batch := range [][]types.Sample{samples[:2], samples[2:]} {
+		// Ids continue after the rows of the previous store.
+		store, err := Open(t.Context(), db, Options{TablePrefix: "pm_"})
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		writeAll(t, store, batch)
+	}
+	store, err := Open(t.Context(), db, Options{TablePrefix: "pm_"})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, err := store.Query(t.Context(), time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if encodeJSON(t, got) != encodeJSON(t, samples) {
+		t.Error("Expected samples to read back identically")
+	}
+}
+
+func TestFlushRollback(t *testing.T) {
+	db := openDB(t)
+	store, err := Open(t.Context(), db, Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	// A stray state of the next sample makes its states conflict.
+	if _, err := db.Exec("INSERT INTO powermetrics_gpu_states VALUES (1, 'dvfm', 0, '', 338, 0, 0)"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	gpu := sampletest.Read(t, sampletest.GPUPower)
+	if err := store.Write(t.Context(), gpu[0]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := store.Flush(t.Context()); err == nil || !strings.HasPrefix(err.Error(), "insert samples:") {
+		t.Errorf("Expected an insert error, got %v", err)
+	}
+	if got := count(t, db, "powermetrics_gpu_power"); got != 0 {
+		t.Errorf("Expected the transaction to be rolled back, got %d rows", got)
+	}
+}
+
+func TestFlushRequeue(t *testing.T) {
+	db := openDB(t)
+	store, err := Open(t.Context(), db, Options{BatchSize: 10, MaxPendingSamples: 3})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO powermetrics_gpu_states VALUES (1, 'dvfm', 0, '', 338, 0, 0)"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	gpu := sampletest.Read(t, sampletest.GPUPower)
+	for _, sample := range gpu[:2] {
+		if err := store.Write(t.Context(), sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := store.Flush(t.Context()); err == nil {
+		t.Fatal("Expected an insert error")
+	}
+
+	// The failed batch is kept in front of later samples, up to
+	// MaxPendingSamples.
+	for _, sample := range gpu[2:4] {
+		if err := store.Write(t.Context(), sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if got := store.Dropped(); got != 0 {
+		t.Errorf("Expected no dropped samples before the next failure, got %d", got)
+	}
+	if err := store.Flush(t.Context()); err == nil {
+		t.Fatal("Expected an insert error")
+	}
+	if got := store.Dropped(); got != 1 {
+		t.Errorf("Expected 1 dropped sample, got %d", got)
+	}
+
+	if _, err := db.Exec("DELETE FROM powermetrics_gpu_states"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	got, err := store.Query(t.Context(), time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if encodeJSON(t, got) != encodeJSON(t, gpu[1:4]) {
+		t.Errorf("Expected the samples after the dropped one, got %d samples", len(got))
+	}
+}
+
+func TestConcurrentStores(t *testing.T) {
+	db := openDB(t)
+	gpu := sampletest.Read(t, sampletest.GPUPower)
+	var stores []*Store
+	for range 2 {
+		store, err := Open(t.Context(), db, Options{})
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		stores = append(stores, store)
+	}
+	// Both stores insert into the same tables without colliding on ids.
+	for i, sample := range gpu {
+		writeAll(t, stores[i%2], []types.Sample{sample})
+	}
+	got, err := stores[0].Query(t.Context(), time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if encodeJSON(t, got) != encodeJSON(t, gpu) {
+		t.Error("Expected samples to read back identically")
+	}
+}
+
+func TestQueryWhileWriting(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "samples.db")+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	store, err := Open(t.Context(), db, Options{BatchSize: 1})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	gpu := sampletest.Read(t, sampletest.GPUPower)
+
+	done := make(chan error)
+	go func() {
+		for i := range 500 {
+			if err := store.Write(t.Context(), gpu[i%len(gpu)]); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		// Every sample read back has all of its states.
+		samples, err := store.Query(t.Context(), time.Time{}, time.Now())
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		for _, sample := range samples {
+			if len(sample.(*types.GPUPowerSample).GPU.DVFMStates) == 0 {
+				t.Fatal("Expected samples to be loaded with their states")
+			}
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			return
+		default:
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	if _, err := Open(t.Context(), openDB(t), Options{TablePrefix: "pm; DROP TABLE x; --"}); err == nil {
+		t.Error("Expected an invalid prefix error")
+	}
+	store, err := Open(t.Context(), openDB(t), Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := store.Write(t.Context(), &types.BaseSample{}); err == nil {
+		t.Error("Expected an unsupported sample error")
+	}
+}
+
+func TestRun(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	pm := powermetrics.NewWithRunner(&powermetrics.MockCommandRunner{Output: data})
+	stream, err := pm.Stream(t.Context(), &powermetrics.Config{Samplers: []powermetrics.Sampler{powermetrics.GPUPower}})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	db := openDB(t)
+	store, err := Open(t.Context(), db, Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := store.Run(context.Background(), stream); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := count(t, db, "powermetrics_gpu_power"); got != 5 {
+		t.Errorf("Expected 5 rows, got %d", got)
+	}
+}
@@ -0,0 +1,351 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/internal/batch"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Store defaults
+const (
+	DefaultTablePrefix       = "powermetrics_"
+	DefaultBatchSize         = 100
+	DefaultFlushInterval     = 10 * time.Second
+	DefaultMaxPendingSamples = 10000
+)
+
+// Options configures a Store.
+type Options struct {
+	// TablePrefix is prepended to the table names. It may only contain
+	// letters, digits and underscores. Empty means DefaultTablePrefix.
+	TablePrefix string
+	// Dialect describes the database. The zero value means SQLite.
+	Dialect Dialect
+	// BatchSize is the number of samples that triggers a flush. Zero means
+	// DefaultBatchSize.
+	BatchSize int
+	// FlushInterval is how often Run flushes incomplete batches. Zero means
+	// DefaultFlushInterval.
+	FlushInterval time.Duration
+	// MaxPendingSamples is the number of samples kept for the next flush
+	// when inserts fail, beyond which the oldest are dropped. Zero means
+	// DefaultMaxPendingSamples.
+	MaxPendingSamples int
+	// OnError, when set, is called by Run with the error of every failed
+	// flush.
+	OnError func(error)
+}
+
+// Dialect describes how a database numbers query parameters and allocates
+// the ids of the sampler tables.
+type Dialect struct {
+	// Placeholder returns the query parameter placeholder of the nth
+	// parameter, starting at 1.
+	Placeholder func(n int) string
+	// IDType is the column type of the ids of the sampler tables, which the
+	// database allocates on insert.
+	IDType string
+	// Returning makes inserts return the ids with RETURNING id, for drivers
+	// that do not implement LastInsertId.
+	Returning bool
+}
+
+// Dialects of the supported databases
+var (
+	SQLite     = Dialect{Placeholder: questionPlaceholder, IDType: "INTEGER NOT NULL"}
+	PostgreSQL = Dialect{Placeholder: DollarPlaceholder, IDType: "BIGINT GENERATED BY DEFAULT AS IDENTITY", Returning: true}
+	MySQL      = Dialect{Placeholder: questionPlaceholder, IDType: "BIGINT NOT NULL AUTO_INCREMENT"}
+)
+
+// DollarPlaceholder returns the PostgreSQL placeholder $n.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func questionPlaceholder(int) string {
+	return "?"
+}
+
+// Store writes samples to a database in batches, each inserted in a
+// transaction, and queries them back. It is safe for concurrent use, and
+// since the database allocates the ids, several stores may write to the
+// same tables.
+type Store struct {
+	db   *sql.DB
+	opts Options
+
+	mu      sync.Mutex
+	pending []types.Sample
+	dropped int
+
+	// insertMu serializes the transactions of the store, so that its own
+	// batches do not wait on each other's locks.
+	insertMu sync.Mutex
+}
+
+// Open returns a store of the tables of db, creating the missing ones.
+// The store does not close db.
+func Open(ctx context.Context, db *sql.DB, opts Options) (*Store, error) {
+	if opts.TablePrefix == "" {
+		opts.TablePrefix = DefaultTablePrefix
+	}
+	if !validPrefix(opts.TablePrefix) {
+		return nil, fmt.Errorf("invalid table prefix %q", opts.TablePrefix)
+	}
+	if opts.Dialect.Placeholder == nil {
+		opts.Dialect = SQLite
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.MaxPendingSamples <= 0 {
+		opts.MaxPendingSamples = DefaultMaxPendingSamples
+	}
+	for _, t := range tables {
+		if _, err := db.ExecContext(ctx, t.createSQL(opts.TablePrefix, opts.Dialect)); err != nil {
+			return nil, fmt.Errorf("create table %s%s: %w", opts.TablePrefix, t.name, err)
+		}
+	}
+	return &Store{db: db, opts: opts}, nil
+}
+
+// Write adds sample to the batch, and flushes the batch once it holds
+// BatchSize samples.
+func (s *Store) Write(ctx context.Context, sample types.Sample) error {
+	switch sample.(type) {
+	case *types.GPUPowerSample, *types.TasksSample, *types.BatterySample, *types.ThermalSample:
+	default:
+		return fmt.Errorf("unsupported sample type %T", sample)
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, sample)
+	full := len(s.pending) >= s.opts.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush inserts the batch in a transaction. When the transaction fails,
+// the batch is kept for the next flush, and the oldest samples beyond
+// MaxPendingSamples are dropped.
+func (s *Store) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	s.insertMu.Lock()
+	err := s.insertBatch(ctx, batch)
+	s.insertMu.Unlock()
+	if err != nil {
+		s.requeue(batch)
+		return fmt.Errorf("insert samples: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) insertBatch(ctx context.Context, batch []types.Sample) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.insert(ctx, tx, batch); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// requeue puts a failed batch back in front of the samples written since.
+func (s *Store) requeue(batch []types.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(batch, s.pending...)
+	if over := len(s.pending) - s.opts.MaxPendingSamples; over > 0 {
+		s.pending = s.pending[over:]
+		s.dropped += over
+	}
+}
+
+// Dropped returns the number of samples dropped because inserts failed
+// while MaxPendingSamples samples were pending.
+func (s *Store) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// inserter inserts the rows of a transaction, preparing each statement once.
+type inserter struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	store *Store
+	stmts map[string]*sql.Stmt
+}
+
+func (s *Store) insert(ctx context.Context, tx *sql.Tx, batch []types.Sample) error {
+	in := &inserter{ctx: ctx, tx: tx, store: s, stmts: make(map[string]*sql.Stmt)}
+	defer func() {
+		for _, stmt := range in.stmts {
+			_ = stmt.Close()
+		}
+	}()
+	for _, sample := range batch {
+		if err := in.sample(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *inserter) stmt(t table) (*sql.Stmt, error) {
+	if stmt, ok := in.stmts[t.name]; ok {
+		return stmt, nil
+	}
+	stmt, err := in.tx.PrepareContext(in.ctx, t.insertSQL(in.store.opts.TablePrefix, in.store.opts.Dialect))
+	if err != nil {
+		return nil, err
+	}
+	in.stmts[t.name] = stmt
+	return stmt, nil
+}
+
+func (in *inserter) exec(t table, args ...any) error {
+	stmt, err := in.stmt(t)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(in.ctx, args...)
+	return err
+}
+
+// insertSample inserts a row of the sampler table t and returns the id the
+// database allocated.
+func (in *inserter) insertSample(t table, args ...any) (int64, error) {
+	stmt, err := in.stmt(t)
+	if err != nil {
+		return 0, err
+	}
+	if in.store.opts.Dialect.Returning {
+		var id int64
+		err := stmt.QueryRowContext(in.ctx, args...).Scan(&id)
+		return id, err
+	}
+	res, err := stmt.ExecContext(in.ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// headerArgs returns the header columns of sample, but the id.
+func headerArgs(sample types.Sample) []any {
+	var ts any
+	if t := sample.GetTimestamp(); !t.IsZero() {
+		ts = t.UnixNano()
+	}
+	return []any{
+		ts,
+		sample.GetElapsedNS(),
+		sample.GetHWModel(),
+		sample.GetKernOSVer(),
+		sample.GetKernBootArgs(),
+		sample.GetKernBootTime(),
+		sample.GetIsDelta(),
+	}
+}
+
+func taskArgs(t *types.TaskInfo) []any {
+	return []any{
+		t.PID,
+		t.Name,
+		t.IntervalNS,
+		t.CPUTimeNS,
+		t.CPUTimeMSPerS,
+		t.CPUTimeUserlandRatio,
+		t.IntrWakeups,
+		t.IntrWakeupsPerS,
+		t.IdleWakeups,
+		t.IdleWakeupsPerS,
+		t.DiskIOBytesRead,
+		t.DiskIOBytesWritten,
+		t.PacketsReceived,
+		t.PacketsSent,
+		t.BytesReceived,
+		t.BytesSent,
+		t.EnergyImpact,
+		t.EnergyImpactPerS,
+	}
+}
+
+func (in *inserter) sample(sample types.Sample) error {
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		var energy any
+		if s.GPU.GPUEnergy != nil {
+			energy = *s.GPU.GPUEnergy
+		}
+		id, err := in.insertSample(gpuPowerTable, append(headerArgs(s), s.GPU.FreqHz, s.GPU.IdleNS, s.GPU.IdleRatio, energy)...)
+		if err != nil {
+			return err
+		}
+		for i, state := range s.GPU.DVFMStates {
+			if err := in.exec(gpuStatesTable, id, StateDVFM, i, "", state.Freq, state.UsedNS, state.UsedRatio); err != nil {
+				return err
+			}
+		}
+		for i, state := range s.GPU.SWRequestedState {
+			if err := in.exec(gpuStatesTable, id, StateSWRequested, i, state.SWReqState, int64(0), state.UsedNS, state.UsedRatio); err != nil {
+				return err
+			}
+		}
+		for i, state := range s.GPU.SWState {
+			if err := in.exec(gpuStatesTable, id, StateSW, i, state.SWState, int64(0), state.UsedNS, state.UsedRatio); err != nil {
+				return err
+			}
+		}
+	case *types.TasksSample:
+		id, err := in.insertSample(tasksTable, append(headerArgs(s), taskArgs(&s.AllTasks)...)...)
+		if err != nil {
+			return err
+		}
+		for i := range s.Tasks {
+			if err := in.exec(taskProcessesTable, append([]any{id, i}, taskArgs(&s.Tasks[i])...)...); err != nil {
+				return err
+			}
+		}
+	case *types.BatterySample:
+		_, err := in.insertSample(batteryTable, append(headerArgs(s), s.Battery.PercentCharge)...)
+		return err
+	case *types.ThermalSample:
+		_, err := in.insertSample(thermalTable, append(headerArgs(s), s.ThermalPressure)...)
+		return err
+	}
+	return nil
+}
+
+// Run writes every sample of stream until the stream ends, flushing at
+// least every FlushInterval, and flushes the last batch. Failed flushes are
+// reported to OnError; Run returns the error of the last flush. The stream's
+// samples must not be consumed elsewhere.
+func (s *Store) Run(ctx context.Context, stream *powermetrics.Stream) error {
+	return batch.Run(ctx, stream.Samples(), s, s.opts.FlushInterval, s.opts.OnError)
+}
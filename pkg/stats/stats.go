@@ -0,0 +1,220 @@
+// Package stats computes statistical summaries over powermetrics sample
+// series.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Summary describes the distribution of a single metric over a series.
+//
+// Min, Max, Mean, Median, the percentiles and StdDev treat every sample as
+// one observation. TimeWeightedMean weights each observation by the
+// sample's ElapsedNS, which is the right average for rates such as power
+// or idle ratio when intervals differ in length.
+type Summary struct {
+	Count            int
+	Min              float64
+	Max              float64
+	Mean             float64
+	Median           float64
+	P90              float64
+	P95              float64
+	P99              float64
+	StdDev           float64
+	TimeWeightedMean float64
+}
+
+// Report maps metric names to their summaries.
+type Report map[string]Summary
+
+// Names returns the metric names of the report in sorted order.
+func (r Report) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Summarize returns a summary of every numeric metric found in samples.
+func Summarize(samples []types.Sample) Report {
+	values := make(map[string][]float64)
+	weights := make(map[string][]float64)
+	for _, sample := range samples {
+		if sample == nil {
+			continue
+		}
+		weight := float64(sample.GetElapsedNS())
+		for name, v := range Values(sample) {
+			values[name] = append(values[name], v)
+			weights[name] = append(weights[name], weight)
+		}
+	}
+
+	report := make(Report, len(values))
+	for name, v := range values {
+		report[name] = Describe(v, weights[name])
+	}
+	return report
+}
+
+// SummarizeGPU is a convenience wrapper around Summarize for GPU series such
+// as powermetrics.Result.Samples.
+func SummarizeGPU(series types.GPUSeries) Report {
+	samples := make([]types.Sample, 0, len(series))
+	for _, s := range series {
+		if s != nil {
+			samples = append(samples, s)
+		}
+	}
+	return Summarize(samples)
+}
+
+// SummarizeCollection is a convenience wrapper around Summarize for a
+// ResultCollection.
+func SummarizeCollection(rc *types.ResultCollection) Report {
+	if rc == nil {
+		return Report{}
+	}
+	return Summarize(rc.Samples)
+}
+
+// Values returns the numeric metrics of a sample keyed by metric name. Rates
+// are reported in base SI units: hertz, watts, joules and seconds.
+//
+// GPU state residencies are keyed by state, as in
+// gpu.dvfm.338mhz.residency_ratio and gpu.sw_state.SW_P1.residency_ratio,
+// with duplicated states summed. Tasks metrics are those of all tasks, and
+// the thermal pressure is its rank in types.ThermalPressureLevels.
+func Values(sample types.Sample) map[string]float64 {
+	values := make(map[string]float64)
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		m := s.Metrics()
+		values["gpu.frequency_hz"] = s.GPU.Frequency().Hertz()
+		values["gpu.effective_frequency_hz"] = m.EffectiveFrequency.Hertz()
+		values["gpu.idle_ratio"] = s.GPU.IdleRatio
+		values["gpu.idle_seconds"] = s.GPU.Idle().Seconds()
+		values["gpu.active_ratio"] = float64(m.Active)
+		if energy, ok := s.GPU.Energy(); ok {
+			values["gpu.energy_joules"] = energy.Joules()
+		}
+		if power, ok := s.Power(); ok {
+			values["gpu.power_watts"] = power.Watts()
+		}
+		for _, state := range s.GPU.DVFMStates {
+			values[fmt.Sprintf("gpu.dvfm.%dmhz.residency_ratio", state.Freq)] += state.UsedRatio
+		}
+		for _, state := range s.GPU.SWRequestedState {
+			values["gpu.sw_requested_state."+state.SWReqState+".residency_ratio"] += state.UsedRatio
+		}
+		for _, state := range s.GPU.SWState {
+			values["gpu.sw_state."+state.SWState+".residency_ratio"] += state.UsedRatio
+		}
+	case *types.TasksSample:
+		all := &s.AllTasks
+		values["tasks.cpu_time_seconds"] = all.CPUTime().Seconds()
+		values["tasks.cpu_usage_cores"] = all.CPUTimeMSPerS / 1000
+		values["tasks.userland_ratio"] = all.CPUTimeUserlandRatio
+		values["tasks.interrupt_wakeups"] = float64(all.IntrWakeups)
+		values["tasks.interrupt_wakeups_per_second"] = all.IntrWakeupsPerS
+		values["tasks.idle_wakeups"] = float64(all.IdleWakeups)
+		values["tasks.idle_wakeups_per_second"] = all.IdleWakeupsPerS
+		values["tasks.disk_read_bytes"] = float64(all.DiskIOBytesRead)
+		values["tasks.disk_written_bytes"] = float64(all.DiskIOBytesWritten)
+		values["tasks.packets_received"] = float64(all.PacketsReceived)
+		values["tasks.packets_sent"] = float64(all.PacketsSent)
+		values["tasks.bytes_received"] = float64(all.BytesReceived)
+		values["tasks.bytes_sent"] = float64(all.BytesSent)
+		values["tasks.energy_impact"] = all.EnergyImpact
+		values["tasks.energy_impact_per_second"] = all.EnergyImpactPerS
+	case *types.BatterySample:
+		values["battery.charge_ratio"] = float64(s.Battery.Charge())
+	case *types.ThermalSample:
+		if s.ThermalPressure != "" {
+			values["thermal.pressure_rank"] = float64(types.ThermalPressureRank(s.ThermalPressure))
+		}
+	}
+	return values
+}
+
+// Describe summarizes values. weights are used for TimeWeightedMean only and
+// must be nil or the same length as values; when they are nil or sum to zero
+// the plain mean is used instead.
+func Describe(values, weights []float64) Summary {
+	n := len(values)
+	if n == 0 {
+		return Summary{}
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(n)
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	var stddev float64
+	if n > 1 {
+		stddev = math.Sqrt(squares / float64(n-1))
+	}
+
+	weighted := mean
+	if len(weights) == n {
+		var wsum, wtotal float64
+		for i, v := range values {
+			wsum += v * weights[i]
+			wtotal += weights[i]
+		}
+		if wtotal > 0 {
+			weighted = wsum / wtotal
+		}
+	}
+
+	return Summary{
+		Count:            n,
+		Min:              sorted[0],
+		Max:              sorted[n-1],
+		Mean:             mean,
+		Median:           Percentile(sorted, 50),
+		P90:              Percentile(sorted, 90),
+		P95:              Percentile(sorted, 95),
+		P99:              Percentile(sorted, 99),
+		StdDev:           stddev,
+		TimeWeightedMean: weighted,
+	}
+}
+
+// Percentile returns the p-th percentile (0-100) of sorted values using
+// linear interpolation between closest ranks. sorted must be in ascending
+// order. It returns NaN for an empty slice.
+func Percentile(sorted []float64, p float64) float64 {
+	n := len(sorted)
+	switch {
+	case n == 0:
+		return math.NaN()
+	case n == 1 || p <= 0:
+		return sorted[0]
+	case p >= 100:
+		return sorted[n-1]
+	}
+	rank := p / 100 * float64(n-1)
+	lo := int(math.Floor(rank))
+	frac := rank - float64(lo)
+	if lo+1 >= n {
+		return sorted[lo]
+	}
+	return sorted[lo] + frac*(sorted[lo+1]-sorted[lo])
+}
@@ -0,0 +1,224 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestDescribe(t *testing.T) {
+	s := Describe([]float64{4, 1, 3, 2, 5}, []float64{1, 1, 1, 1, 6})
+
+	if s.Count != 5 || s.Min != 1 || s.Max != 5 {
+		t.Errorf("Unexpected count/min/max: %+v", s)
+	}
+	if !almostEqual(s.Mean, 3) || !almostEqual(s.Median, 3) {
+		t.Errorf("Expected mean and median to be 3, got %f and %f", s.Mean, s.Median)
+	}
+	if !almostEqual(s.P90, 4.6) {
+		t.Errorf("Expected p90 to be 4.6, got %f", s.P90)
+	}
+	if !almostEqual(s.StdDev, math.Sqrt(2.5)) {
+		t.Errorf("Expected stddev to be sqrt(2.5), got %f", s.StdDev)
+	}
+	if !almostEqual(s.TimeWeightedMean, 4) {
+		t.Errorf("Expected time-weighted mean to be 4, got %f", s.TimeWeightedMean)
+	}
+
+	if empty := Describe(nil, nil); empty.Count != 0 {
+		t.Errorf("Expected empty summary, got %+v", empty)
+	}
+}
+
+func TestSummarizeGPU(t *testing.T) {
+	energy := func(v int64) *int64 { return &v }
+	series := types.GPUSeries{
+		{
+			BaseSample: types.BaseSample{ElapsedNS: int64(time.Second)},
+			GPU:        types.GPUInfo{FreqHz: 400, IdleRatio: 0.9, GPUEnergy: energy(100)},
+		},
+		{
+			BaseSample: types.BaseSample{ElapsedNS: int64(3 * time.Second)},
+			GPU:        types.GPUInfo{FreqHz: 800, IdleRatio: 0.5, GPUEnergy: energy(900)},
+		},
+	}
+
+	report := SummarizeGPU(series)
+
+	power, ok := report["gpu.power_watts"]
+	if !ok {
+		t.Fatalf("Expected gpu.power_watts in report, got %v", report.Names())
+	}
+	if !almostEqual(power.Mean, 0.2) {
+		t.Errorf("Expected mean power of 0.2 W, got %f", power.Mean)
+	}
+	if !almostEqual(power.TimeWeightedMean, 0.25) {
+		t.Errorf("Expected time-weighted power of 0.25 W, got %f", power.TimeWeightedMean)
+	}
+
+	freq := report["gpu.frequency_hz"]
+	if freq.Min != 400e6 || freq.Max != 800e6 {
+		t.Errorf("Expected frequency range 400-800 MHz, got %f-%f", freq.Min, freq.Max)
+	}
+}
+
+func TestValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample types.Sample
+		want   map[string]float64
+	}{
+		{
+			name: "gpu",
+			sample: &types.GPUPowerSample{
+				BaseSample: types.BaseSample{ElapsedNS: int64(time.Second)},
+				GPU: types.GPUInfo{
+					IdleNS:    int64(250 * time.Millisecond),
+					IdleRatio: 0.25,
+					DVFMStates: []types.DVFMState{
+						{Freq: 338, UsedNS: int64(500 * time.Millisecond), UsedRatio: 0.5},
+						{Freq: 1182, UsedNS: int64(100 * time.Millisecond), UsedRatio: 0.1},
+						{Freq: 1182, UsedNS: int64(150 * time.Millisecond), UsedRatio: 0.15},
+					},
+					SWRequestedState: []types.SWReqState{{SWReqState: "P1", UsedRatio: 0.75}},
+					SWState:          []types.SWState{{SWState: "SW_P1", UsedRatio: 0.7}},
+				},
+			},
+			want: map[string]float64{
+				"gpu.idle_seconds":                          0.25,
+				"gpu.dvfm.338mhz.residency_ratio":           0.5,
+				"gpu.dvfm.1182mhz.residency_ratio":          0.25,
+				"gpu.sw_requested_state.P1.residency_ratio": 0.75,
+				"gpu.sw_state.SW_P1.residency_ratio":        0.7,
+			},
+		},
+		{
+			name: "tasks",
+			sample: &types.TasksSample{AllTasks: types.TaskInfo{
+				CPUTimeNS:            int64(1500 * time.Millisecond),
+				CPUTimeMSPerS:        750,
+				CPUTimeUserlandRatio: 0.6,
+				IntrWakeups:          342,
+				IdleWakeups:          58,
+				DiskIOBytesWritten:   4096,
+				BytesSent:            1500,
+				EnergyImpact:         12.5,
+			}},
+			want: map[string]float64{
+				"tasks.cpu_time_seconds":   1.5,
+				"tasks.cpu_usage_cores":    0.75,
+				"tasks.userland_ratio":     0.6,
+				"tasks.interrupt_wakeups":  342,
+				"tasks.idle_wakeups":       58,
+				"tasks.disk_written_bytes": 4096,
+				"tasks.bytes_sent":         1500,
+				"tasks.energy_impact":      12.5,
+			},
+		},
+		{
+			name:   "thermal",
+			sample: &types.ThermalSample{ThermalPressure: "Heavy"},
+			want:   map[string]float64{"thermal.pressure_rank": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := Values(tt.sample)
+			for name, want := range tt.want {
+				got, ok := values[name]
+				if !ok {
+					t.Errorf("Expected %s in values", name)
+				} else if !almostEqual(got, want) {
+					t.Errorf("Expected %s to be %v, got %v", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSummarizeTasks(t *testing.T) {
+	samples := []types.Sample{
+		&types.TasksSample{BaseSample: types.BaseSample{ElapsedNS: int64(time.Second)}, AllTasks: types.TaskInfo{CPUTimeMSPerS: 200}},
+		&types.TasksSample{BaseSample: types.BaseSample{ElapsedNS: int64(3 * time.Second)}, AllTasks: types.TaskInfo{CPUTimeMSPerS: 600}},
+	}
+	usage, ok := Summarize(samples)["tasks.cpu_usage_cores"]
+	if !ok {
+		t.Fatal("Expected tasks.cpu_usage_cores in report")
+	}
+	if usage.Count != 2 || !almostEqual(usage.TimeWeightedMean, 0.5) {
+		t.Errorf("Expected 2 samples with a time-weighted mean of 0.5, got %+v", usage)
+	}
+}
+
+func TestStudentT(t *testing.T) {
+	// Reference values from standard t tables.
+	tests := []struct {
+		p, df, expected float64
+	}{
+		{0.975, 1, 12.7062},
+		{0.975, 4, 2.7764},
+		{0.975, 30, 2.0423},
+		{0.95, 10, 1.8125},
+	}
+	for _, tt := range tests {
+		if got := StudentTQuantile(tt.p, tt.df); math.Abs(got-tt.expected) > 1e-3 {
+			t.Errorf("StudentTQuantile(%v, %v): expected %v, got %v", tt.p, tt.df, tt.expected, got)
+		}
+	}
+	if got := StudentTCDF(0, 7); !almostEqual(got, 0.5) {
+		t.Errorf("Expected CDF at 0 to be 0.5, got %v", got)
+	}
+}
+
+func TestMeanConfidenceInterval(t *testing.T) {
+	e := MeanConfidenceInterval([]float64{10, 12, 11, 9, 13}, 0.95)
+	if !almostEqual(e.Mean, 11) {
+		t.Errorf("Expected mean 11, got %v", e.Mean)
+	}
+	margin := 2.7764 * math.Sqrt(2.5) / math.Sqrt(5)
+	if math.Abs(e.Low-(11-margin)) > 1e-3 || math.Abs(e.High-(11+margin)) > 1e-3 {
+		t.Errorf("Unexpected interval [%v, %v]", e.Low, e.High)
+	}
+
+	single := MeanConfidenceInterval([]float64{3}, 0.95)
+	if single.Low != 3 || single.High != 3 {
+		t.Errorf("Expected a collapsed interval for one value, got %+v", single)
+	}
+}
+
+func TestWelchTTest(t *testing.T) {
+	// Reference values computed with scipy.stats.ttest_ind(equal_var=False).
+	a := []float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4}
+	b := []float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4}
+
+	result, ok := WelchTTest(a, b)
+	if !ok {
+		t.Fatal("Expected the test to run")
+	}
+	if math.Abs(result.T-2.46) > 0.01 {
+		t.Errorf("Expected t = 2.46, got %v", result.T)
+	}
+	if math.Abs(result.DF-24.99) > 0.01 {
+		t.Errorf("Expected df = 24.99, got %v", result.DF)
+	}
+	if math.Abs(result.PValue-0.021) > 0.001 {
+		t.Errorf("Expected p = 0.021, got %v", result.PValue)
+	}
+	lo, hi := result.ConfidenceInterval(0.95)
+	if lo <= 0 || hi <= lo {
+		t.Errorf("Expected a positive interval, got [%v, %v]", lo, hi)
+	}
+
+	if _, ok := WelchTTest([]float64{1}, b); ok {
+		t.Error("Expected the test to need two values per sample")
+	}
+	if result, _ := WelchTTest([]float64{1, 1}, []float64{1, 1}); result.PValue != 1 {
+		t.Errorf("Expected p = 1 for identical constant samples, got %v", result.PValue)
+	}
+}
@@ -0,0 +1,192 @@
+package stats
+
+import "math"
+
+// Estimate is the mean of a set of observations with a two-sided confidence
+// interval derived from Student's t-distribution.
+type Estimate struct {
+	N      int
+	Mean   float64
+	StdDev float64
+	// Low and High delimit the confidence interval around Mean.
+	Low   float64
+	High  float64
+	Level float64
+}
+
+// MeanConfidenceInterval estimates the mean of values with a two-sided
+// confidence interval at the given level, e.g. 0.95. With fewer than two
+// values the interval collapses onto the mean.
+func MeanConfidenceInterval(values []float64, level float64) Estimate {
+	s := Describe(values, nil)
+	e := Estimate{N: s.Count, Mean: s.Mean, StdDev: s.StdDev, Low: s.Mean, High: s.Mean, Level: level}
+	if s.Count < 2 {
+		return e
+	}
+	t := StudentTQuantile(1-(1-level)/2, float64(s.Count-1))
+	margin := t * s.StdDev / math.Sqrt(float64(s.Count))
+	e.Low = s.Mean - margin
+	e.High = s.Mean + margin
+	return e
+}
+
+// StudentTCDF returns the cumulative distribution function of Student's
+// t-distribution with df degrees of freedom at t.
+func StudentTCDF(t, df float64) float64 {
+	if math.IsInf(t, 1) {
+		return 1
+	}
+	if math.IsInf(t, -1) {
+		return 0
+	}
+	x := df / (df + t*t)
+	tail := 0.5 * regularizedIncompleteBeta(df/2, 0.5, x)
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+// StudentTQuantile returns the value t such that StudentTCDF(t, df) == p.
+func StudentTQuantile(p, df float64) float64 {
+	switch {
+	case p <= 0:
+		return math.Inf(-1)
+	case p >= 1:
+		return math.Inf(1)
+	case p == 0.5:
+		return 0
+	}
+	lo, hi := -1.0, 1.0
+	for StudentTCDF(lo, df) > p {
+		lo *= 2
+	}
+	for StudentTCDF(hi, df) < p {
+		hi *= 2
+	}
+	for i := 0; i < 200 && hi-lo > 1e-12; i++ {
+		mid := (lo + hi) / 2
+		if StudentTCDF(mid, df) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// regularizedIncompleteBeta evaluates I_x(a, b) with the continued fraction
+// expansion from Numerical Recipes.
+func regularizedIncompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(a, b, x) / a
+	}
+	return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+}
+
+func betaContinuedFraction(a, b, x float64) float64 {
+	const (
+		maxIterations = 300
+		epsilon       = 1e-15
+		tiny          = 1e-300
+	)
+	qab, qap, qam := a+b, a+1, a-1
+	c, d := 1.0, 1-qab*x/qap
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		m2 := 2 * fm
+		aa := fm * (b - fm) * x / ((qam + m2) * (a + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		aa = -(a + fm) * (qab + fm) * x / ((a + m2) * (qap + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
+
+// TTest is the result of a two-sample t-test.
+type TTest struct {
+	// Difference is the mean of the second sample minus the mean of the
+	// first, and StdErr its standard error.
+	Difference float64
+	StdErr     float64
+	T          float64
+	DF         float64
+	// PValue is the two-sided p-value.
+	PValue float64
+}
+
+// ConfidenceInterval returns the two-sided confidence interval of the
+// difference at the given level.
+func (t TTest) ConfidenceInterval(level float64) (lo, hi float64) {
+	if t.StdErr == 0 {
+		return t.Difference, t.Difference
+	}
+	margin := StudentTQuantile(1-(1-level)/2, t.DF) * t.StdErr
+	return t.Difference - margin, t.Difference + margin
+}
+
+// WelchTTest compares the means of a and b without assuming equal
+// variances. Both samples need at least two values; otherwise ok is false.
+func WelchTTest(a, b []float64) (result TTest, ok bool) {
+	if len(a) < 2 || len(b) < 2 {
+		return TTest{}, false
+	}
+	sa, sb := Describe(a, nil), Describe(b, nil)
+	va := sa.StdDev * sa.StdDev / float64(sa.Count)
+	vb := sb.StdDev * sb.StdDev / float64(sb.Count)
+
+	result.Difference = sb.Mean - sa.Mean
+	result.StdErr = math.Sqrt(va + vb)
+	if result.StdErr == 0 {
+		result.DF = float64(sa.Count + sb.Count - 2)
+		if result.Difference == 0 {
+			result.PValue = 1
+		} else {
+			result.T = math.Copysign(math.Inf(1), result.Difference)
+		}
+		return result, true
+	}
+
+	result.T = result.Difference / result.StdErr
+	result.DF = (va + vb) * (va + vb) /
+		(va*va/float64(sa.Count-1) + vb*vb/float64(sb.Count-1))
+	result.PValue = 2 * StudentTCDF(-math.Abs(result.T), result.DF)
+	return result, true
+}
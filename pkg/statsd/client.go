@@ -0,0 +1,120 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Maximum packet sizes. DefaultMaxPacketSize fits the payload of a UDP
+// datagram in a 1500-byte Ethernet MTU with IPv6 headers; unix sockets carry
+// larger datagrams.
+const (
+	DefaultMaxPacketSize     = 1432
+	DefaultUnixMaxPacketSize = 8192
+)
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// MaxPacketSize is the maximum size of a datagram. Zero means
+	// DefaultMaxPacketSize for UDP and DefaultUnixMaxPacketSize for unix
+	// sockets.
+	MaxPacketSize int
+	// Encoding selects how metrics are named and tagged.
+	Encoding Options
+	// OnError, when set, is called by Run with the error of every failed
+	// send.
+	OnError func(error)
+}
+
+// Client sends samples to a StatsD agent. The metrics of a sample are
+// packed into as few datagrams as MaxPacketSize allows. It is safe for
+// concurrent use.
+type Client struct {
+	conn net.Conn
+	opts ClientOptions
+
+	mu     sync.Mutex
+	buf    []byte
+	packet []byte
+}
+
+// NewClient returns a client sending to address over network, which is
+// "udp", "udp4", "udp6" or "unixgram", such as "127.0.0.1:8125" or
+// "/var/run/datadog/dsd.socket".
+func NewClient(network, address string, opts ClientOptions) (*Client, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+		if opts.MaxPacketSize <= 0 {
+			opts.MaxPacketSize = DefaultMaxPacketSize
+		}
+	case "unixgram":
+		if opts.MaxPacketSize <= 0 {
+			opts.MaxPacketSize = DefaultUnixMaxPacketSize
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, opts: opts}, nil
+}
+
+// Send sends the metrics of sample. Lines are never split, so a line
+// longer than MaxPacketSize is sent in a datagram of its own.
+func (c *Client) Send(sample types.Sample) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buf = AppendSample(c.buf[:0], sample, c.opts.Encoding)
+	c.packet = c.packet[:0]
+	for lines := c.buf; len(lines) > 0; {
+		i := bytes.IndexByte(lines, '\n')
+		line := lines[:i]
+		lines = lines[i+1:]
+		// Lines of a packet are separated by newlines.
+		if len(c.packet) > 0 && len(c.packet)+1+len(line) > c.opts.MaxPacketSize {
+			if err := c.write(); err != nil {
+				return err
+			}
+		}
+		if len(c.packet) > 0 {
+			c.packet = append(c.packet, '\n')
+		}
+		c.packet = append(c.packet, line...)
+	}
+	if len(c.packet) > 0 {
+		return c.write()
+	}
+	return nil
+}
+
+func (c *Client) write() error {
+	_, err := c.conn.Write(c.packet)
+	c.packet = c.packet[:0]
+	if err != nil {
+		return fmt.Errorf("statsd send failed: %w", err)
+	}
+	return nil
+}
+
+// Run sends every sample of stream until the stream ends. Failed sends are
+// reported to OnError. The stream's samples must not be consumed elsewhere.
+func (c *Client) Run(stream *powermetrics.Stream) {
+	for sample := range stream.Samples() {
+		if err := c.Send(sample); err != nil && c.opts.OnError != nil {
+			c.opts.OnError(err)
+		}
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
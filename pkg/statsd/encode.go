@@ -0,0 +1,205 @@
+// Package statsd pushes powermetrics samples to a StatsD or DogStatsD agent.
+//
+// Values of the sample interval, such as power, frequency and ratios, are
+// gauges; quantities accumulated over the interval, such as energy, CPU time
+// and wakeups, are counters incremented by the amount of the interval.
+// Plain StatsD has no tags, so states are part of the metric name, as in
+// powermetrics.gpu.dvfm.338.residency_ratio. With DogStatsD tags they become
+// tags instead, along with hw_model and os_build:
+//
+//	powermetrics.gpu.dvfm.residency_ratio:0.0149|g|#hw_model:Mac16_8,os_build:24F74,frequency_mhz:338
+package statsd
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// DefaultPrefix prefixes every metric name.
+const DefaultPrefix = "powermetrics"
+
+// Options selects how metrics are named and tagged.
+type Options struct {
+	// Prefix is prepended to every metric name, followed by a dot. Empty
+	// means DefaultPrefix.
+	Prefix string
+	// DogStatsD enables tags: hw_model, os_build and the state tags
+	// frequency_mhz, state, kind, direction and sampler.
+	DogStatsD bool
+	// ExtraTags are added to every metric when DogStatsD is set, as
+	// "key:value" or "key".
+	ExtraTags []string
+}
+
+// metric is a single StatsD metric.
+type metric struct {
+	name  string
+	value float64
+	typ   string // "g" or "c"
+	tag   [2]string
+}
+
+func gauge(name string, v float64) metric   { return metric{name: name, value: v, typ: "g"} }
+func counter(name string, v float64) metric { return metric{name: name, value: v, typ: "c"} }
+
+// withTag returns m with a state tag. Without tags, the state value is
+// inserted in the name before its last element, or appended to names
+// without dots.
+func (m metric) withTag(key, value string) metric {
+	m.tag = [2]string{key, value}
+	return m
+}
+
+// AppendSample appends the metrics of sample to dst, one per line, and
+// returns the extended buffer. Unsupported sample types only count as
+// samples.
+func AppendSample(dst []byte, sample types.Sample, opts Options) []byte {
+	var metrics []metric
+	var sampler string
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		sampler = "gpu_power"
+		metrics = gpuMetrics(s)
+	case *types.TasksSample:
+		sampler = "tasks"
+		metrics = tasksMetrics(&s.AllTasks)
+	case *types.BatterySample:
+		sampler = "battery"
+		metrics = []metric{gauge("battery.percent_charge", float64(s.Battery.PercentCharge))}
+	case *types.ThermalSample:
+		sampler = "thermal"
+		metrics = []metric{gauge("thermal.pressure_level", float64(types.ThermalPressureRank(s.ThermalPressure)))}
+	default:
+		sampler = "unknown"
+	}
+	metrics = append(metrics, counter("samples", 1).withTag("sampler", sampler))
+
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	var tags []string
+	if opts.DogStatsD {
+		for _, tag := range [][2]string{{"hw_model", sample.GetHWModel()}, {"os_build", sample.GetKernOSVer()}} {
+			if tag[1] != "" {
+				tags = append(tags, tag[0]+":"+sanitizeTag(tag[1]))
+			}
+		}
+		for _, tag := range opts.ExtraTags {
+			tags = append(tags, sanitizeTag(tag))
+		}
+	}
+	for _, m := range metrics {
+		dst = appendMetric(dst, prefix, m, tags, opts.DogStatsD)
+	}
+	return dst
+}
+
+func gpuMetrics(s *types.GPUPowerSample) []metric {
+	metrics := []metric{
+		gauge("gpu.frequency_mhz", s.GPU.Frequency().Megahertz()),
+		gauge("gpu.idle_ratio", s.GPU.IdleRatio),
+		counter("gpu.idle_ms", s.GPU.Idle().Seconds()*1000),
+	}
+	if e, ok := s.GPU.Energy(); ok {
+		metrics = append(metrics, counter("gpu.energy_mj", e.Millijoules()))
+	}
+	if p, ok := s.Power(); ok {
+		metrics = append(metrics, gauge("gpu.power_watts", p.Watts()))
+	}
+
+	// States sharing a frequency are summed, as their metrics would
+	// otherwise overwrite each other.
+	ratios := make(map[int64]float64)
+	var freqs []int64
+	for _, state := range s.GPU.DVFMStates {
+		if _, ok := ratios[state.Freq]; !ok {
+			freqs = append(freqs, state.Freq)
+		}
+		ratios[state.Freq] += state.UsedRatio
+	}
+	for _, freq := range freqs {
+		metrics = append(metrics, gauge("gpu.dvfm.residency_ratio", ratios[freq]).withTag("frequency_mhz", strconv.FormatInt(freq, 10)))
+	}
+	for _, state := range s.GPU.SWState {
+		metrics = append(metrics, gauge("gpu.sw_state.residency_ratio", state.UsedRatio).withTag("state", state.SWState))
+	}
+	for _, state := range s.GPU.SWRequestedState {
+		metrics = append(metrics, gauge("gpu.sw_requested_state.residency_ratio", state.UsedRatio).withTag("state", state.SWReqState))
+	}
+	return metrics
+}
+
+func tasksMetrics(all *types.TaskInfo) []metric {
+	return []metric{
+		counter("tasks.cpu_ms", all.CPUTime().Seconds()*1000),
+		gauge("tasks.cpu_usage_cores", all.CPUTimeMSPerS/1000),
+		gauge("tasks.userland_ratio", all.CPUTimeUserlandRatio),
+		gauge("tasks.energy_impact_per_second", all.EnergyImpactPerS),
+		counter("tasks.wakeups", float64(all.IntrWakeups)).withTag("kind", "interrupt"),
+		counter("tasks.wakeups", float64(all.IdleWakeups)).withTag("kind", "idle"),
+		counter("tasks.disk_io_bytes", float64(all.DiskIOBytesRead)).withTag("direction", "read"),
+		counter("tasks.disk_io_bytes", float64(all.DiskIOBytesWritten)).withTag("direction", "write"),
+		counter("tasks.network_packets", float64(all.PacketsReceived)).withTag("direction", "receive"),
+		counter("tasks.network_packets", float64(all.PacketsSent)).withTag("direction", "transmit"),
+		counter("tasks.network_bytes", float64(all.BytesReceived)).withTag("direction", "receive"),
+		counter("tasks.network_bytes", float64(all.BytesSent)).withTag("direction", "transmit"),
+	}
+}
+
+// appendMetric appends one line. Values that are not finite are skipped.
+func appendMetric(dst []byte, prefix string, m metric, tags []string, dogStatsD bool) []byte {
+	if math.IsNaN(m.value) || math.IsInf(m.value, 0) {
+		return dst
+	}
+	name := m.name
+	if m.tag[0] != "" && !dogStatsD {
+		// gpu.dvfm.residency_ratio becomes gpu.dvfm.338.residency_ratio,
+		// and samples becomes samples.gpu_power.
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[:i+1] + sanitizeName(m.tag[1]) + name[i:]
+		} else {
+			name += "." + sanitizeName(m.tag[1])
+		}
+	}
+	dst = append(dst, prefix...)
+	dst = append(dst, '.')
+	dst = append(dst, name...)
+	dst = append(dst, ':')
+	dst = strconv.AppendFloat(dst, m.value, 'f', -1, 64)
+	dst = append(dst, '|')
+	dst = append(dst, m.typ...)
+	if dogStatsD && (len(tags) > 0 || m.tag[0] != "") {
+		dst = append(dst, "|#"...)
+		for i, tag := range tags {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, tag...)
+		}
+		if m.tag[0] != "" {
+			if len(tags) > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, m.tag[0]...)
+			dst = append(dst, ':')
+			dst = append(dst, sanitizeTag(m.tag[1])...)
+		}
+	}
+	return append(dst, '\n')
+}
+
+var (
+	// nameReplacer replaces the separators of the StatsD line format, and
+	// dots, which would add a level to the name.
+	nameReplacer = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+	// tagReplacer replaces the separators of DogStatsD tags. Colons are
+	// allowed, as the first one separates the key from the value.
+	tagReplacer = strings.NewReplacer("|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+)
+
+func sanitizeName(s string) string { return nameReplacer.Replace(s) }
+func sanitizeTag(s string) string  { return tagReplacer.Replace(s) }
@@ -0,0 +1,199 @@
+package statsd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestAppendSample(t *testing.T) {
+	gpu := sampletest.Read(t, sampletest.GPUPower)[0]
+	tasks := sampletest.Read(t, sampletest.Tasks)[0]
+	thermal := &types.ThermalSample{BaseSample: types.BaseSample{HWModel: "Mac16,8"}, ThermalPressure: "Heavy"}
+
+	tests := []struct {
+		name   string
+		sample types.Sample
+		opts   Options
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "gpu",
+			sample: gpu,
+			want: []string{
+				"powermetrics.gpu.frequency_mhz:426.525|g",
+				"powermetrics.gpu.energy_mj:19|c",
+				"powermetrics.gpu.dvfm.338.residency_ratio:0.0149429|g",
+				"powermetrics.gpu.sw_state.SW_P1.residency_ratio:0.0149429|g",
+				"powermetrics.gpu.sw_requested_state.P1.residency_ratio:0.851084|g",
+				"powermetrics.samples.gpu_power:1|c",
+			},
+			absent: []string{"|#"},
+		},
+		{
+			name:   "gpu dogstatsd",
+			sample: gpu,
+			opts:   Options{DogStatsD: true, ExtraTags: []string{"env:prod"}},
+			want: []string{
+				"powermetrics.gpu.energy_mj:19|c|#hw_model:Mac16_8,os_build:24F74,env:prod",
+				"powermetrics.gpu.dvfm.residency_ratio:0.0149429|g|#hw_model:Mac16_8,os_build:24F74,env:prod,frequency_mhz:338",
+				"powermetrics.gpu.sw_state.residency_ratio:0.0149429|g|#hw_model:Mac16_8,os_build:24F74,env:prod,state:SW_P1",
+				"powermetrics.samples:1|c|#hw_model:Mac16_8,os_build:24F74,env:prod,sampler:gpu_power",
+			},
+		},
+		{
+			name:   "tasks",
+			sample: tasks,
+			opts:   Options{Prefix: "mac"},
+			want: []string{
+				"mac.tasks.cpu_ms:101|c",
+				"mac.tasks.cpu_usage_cores:0.101|g",
+				"mac.tasks.interrupt.wakeups:342|c",
+				"mac.tasks.write.disk_io_bytes:4096|c",
+				"mac.tasks.energy_impact_per_second:18.25|g",
+			},
+		},
+		{
+			name:   "thermal dogstatsd",
+			sample: thermal,
+			opts:   Options{DogStatsD: true},
+			want: []string{
+				"powermetrics.thermal.pressure_level:2|g|#hw_model:Mac16_8",
+				"powermetrics.samples:1|c|#hw_model:Mac16_8,sampler:thermal",
+			},
+		},
+		{
+			name:   "battery",
+			sample: &types.BatterySample{Battery: types.BatteryInfo{PercentCharge: 87}},
+			want:   []string{"powermetrics.battery.percent_charge:87|g"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(AppendSample(nil, tt.sample, tt.opts))
+			lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					found = found || line == want
+				}
+				if !found {
+					t.Errorf("Expected line %q in\n%s", want, got)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(got, absent) {
+					t.Errorf("Expected output without %q", absent)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendSampleMergesDVFMStates(t *testing.T) {
+	sample := &types.GPUPowerSample{}
+	sample.GPU.DVFMStates = []types.DVFMState{
+		{Freq: 1182, UsedRatio: 0.25},
+		{Freq: 1182, UsedRatio: 0.5},
+	}
+	got := string(AppendSample(nil, sample, Options{}))
+	if strings.Count(got, "gpu.dvfm.1182.residency_ratio") != 1 || !strings.Contains(got, "gpu.dvfm.1182.residency_ratio:0.75|g") {
+		t.Errorf("Expected one summed DVFM state, got\n%s", got)
+	}
+}
+
+// receive returns the datagrams received by conn until it is idle.
+func receive(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	var packets []string
+	buf := make([]byte, 65536)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func TestClientUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	client, err := NewClient("udp", listener.LocalAddr().String(), ClientOptions{MaxPacketSize: 512})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	sample := sampletest.Read(t, sampletest.GPUPower)[0]
+	if err := client.Send(sample); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	packets := receive(t, listener)
+
+	want := strings.TrimSuffix(string(AppendSample(nil, sample, Options{})), "\n")
+	if len(packets) < 2 {
+		t.Fatalf("Expected the metrics to span several packets, got %d", len(packets))
+	}
+	for i, packet := range packets {
+		if len(packet) > 512 {
+			t.Errorf("Expected packet %d to fit 512 bytes, got %d", i, len(packet))
+		}
+		if i < len(packets)-1 && len(packet)+len(strings.SplitN(packets[i+1], "\n", 2)[0])+1 <= 512 {
+			t.Errorf("Expected packet %d to be filled", i)
+		}
+	}
+	if got := strings.Join(packets, "\n"); got != want {
+		t.Errorf("Expected packets to hold every line once, got\n%s", got)
+	}
+}
+
+func TestClientUnixgram(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, which t.TempDir may
+	// exceed on macOS.
+	dir, err := os.MkdirTemp("", "statsd")
+	if err != nil {
+		t.Fatalf("MkdirTemp failed: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "dsd.socket")
+	listener, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	client, err := NewClient("unixgram", path, ClientOptions{Encoding: Options{DogStatsD: true}})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	sample := &types.ThermalSample{BaseSample: types.BaseSample{HWModel: "Mac16,8"}, ThermalPressure: "Nominal"}
+	if err := client.Send(sample); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	packets := receive(t, listener)
+	want := "powermetrics.thermal.pressure_level:0|g|#hw_model:Mac16_8\npowermetrics.samples:1|c|#hw_model:Mac16_8,sampler:thermal"
+	if len(packets) != 1 || packets[0] != want {
+		t.Errorf("Expected one packet %q, got %q", want, packets)
+	}
+}
+
+func TestNewClientUnsupportedNetwork(t *testing.T) {
+	if _, err := NewClient("tcp", "127.0.0.1:8125", ClientOptions{}); err == nil {
+		t.Error("Expected an error for tcp")
+	}
+}
@@ -0,0 +1,419 @@
+// Package tabular exports powermetrics samples as CSV or TSV and reads them
+// back.
+//
+// Samples are flattened into fields with stable, dotted names derived from
+// the powermetrics keys: gpu.idle_ratio, gpu.dvfm.1380.used_ratio,
+// gpu.sw_state.SW_P1.used_ns, all_tasks.cputime_ns, tasks.412.name and so
+// on. Repeated names, such as DVFM states sharing a frequency, are numbered
+// from the second: gpu.dvfm.1182#2.used_ns. Every sample also has the
+// sampler, timestamp, elapsed_ns, hw_model, kern_osversion, kern_bootargs,
+// kern_boottime and is_delta fields.
+//
+// The wide layout writes one row per sample and one column per field. The
+// long, or tidy, layout writes one row per field of a sample.
+package tabular
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Sampler names, as written in the sampler column.
+const (
+	SamplerGPUPower = "gpu_power"
+	SamplerTasks    = "tasks"
+	SamplerBattery  = "battery"
+	SamplerThermal  = "thermal"
+)
+
+// Field is a flattened value of a sample.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// baseKeys are the fields shared by every sample, in column order.
+var baseKeys = []string{"sampler", "timestamp", "elapsed_ns", "hw_model", "kern_osversion", "kern_bootargs", "kern_boottime", "is_delta"}
+
+// Flatten returns the fields of sample: the base fields, then the sampler
+// fields with states and tasks in the order powermetrics reports them.
+// Unsupported sample types return an error.
+func Flatten(sample types.Sample) ([]Field, error) {
+	var fields []Field
+	add := func(key, value string) { fields = append(fields, Field{key, value}) }
+	addInt := func(key string, v int64) { add(key, strconv.FormatInt(v, 10)) }
+	addFloat := func(key string, v float64) { add(key, formatFloat(v)) }
+
+	var sampler string
+	switch sample.(type) {
+	case *types.GPUPowerSample:
+		sampler = SamplerGPUPower
+	case *types.TasksSample:
+		sampler = SamplerTasks
+	case *types.BatterySample:
+		sampler = SamplerBattery
+	case *types.ThermalSample:
+		sampler = SamplerThermal
+	default:
+		return nil, fmt.Errorf("unsupported sample type %T", sample)
+	}
+	add("sampler", sampler)
+	add("timestamp", formatTime(sample.GetTimestamp()))
+	addInt("elapsed_ns", sample.GetElapsedNS())
+	add("hw_model", sample.GetHWModel())
+	add("kern_osversion", sample.GetKernOSVer())
+	add("kern_bootargs", sample.GetKernBootArgs())
+	addInt("kern_boottime", sample.GetKernBootTime())
+	add("is_delta", strconv.FormatBool(sample.GetIsDelta()))
+
+	switch s := sample.(type) {
+	case *types.GPUPowerSample:
+		addFloat("gpu.freq_hz", s.GPU.FreqHz)
+		addInt("gpu.idle_ns", s.GPU.IdleNS)
+		addFloat("gpu.idle_ratio", s.GPU.IdleRatio)
+		if s.GPU.GPUEnergy != nil {
+			addInt("gpu.gpu_energy", *s.GPU.GPUEnergy)
+		}
+		names := make(uniqueNames)
+		for _, state := range s.GPU.DVFMStates {
+			prefix := "gpu.dvfm." + names.next(strconv.FormatInt(state.Freq, 10))
+			addInt(prefix+".used_ns", state.UsedNS)
+			addFloat(prefix+".used_ratio", state.UsedRatio)
+		}
+		names = make(uniqueNames)
+		for _, state := range s.GPU.SWRequestedState {
+			prefix := "gpu.sw_requested_state." + names.next(state.SWReqState)
+			addInt(prefix+".used_ns", state.UsedNS)
+			addFloat(prefix+".used_ratio", state.UsedRatio)
+		}
+		names = make(uniqueNames)
+		for _, state := range s.GPU.SWState {
+			prefix := "gpu.sw_state." + names.next(state.SWState)
+			addInt(prefix+".used_ns", state.UsedNS)
+			addFloat(prefix+".used_ratio", state.UsedRatio)
+		}
+	case *types.TasksSample:
+		fields = appendTask(fields, "all_tasks.", &s.AllTasks)
+		names := make(uniqueNames)
+		for i := range s.Tasks {
+			fields = appendTask(fields, "tasks."+names.next(strconv.Itoa(s.Tasks[i].PID))+".", &s.Tasks[i])
+		}
+	case *types.BatterySample:
+		addInt("battery.percent_charge", int64(s.Battery.PercentCharge))
+	case *types.ThermalSample:
+		add("thermal_pressure", s.ThermalPressure)
+	}
+	return fields, nil
+}
+
+// uniqueNames numbers repeated state names, such as DVFM states sharing a
+// frequency: the second 1182 MHz state is named 1182#2.
+type uniqueNames map[string]int
+
+func (u uniqueNames) next(name string) string {
+	u[name]++
+	if n := u[name]; n > 1 {
+		return name + "#" + strconv.Itoa(n)
+	}
+	return name
+}
+
+// baseName strips the number added by uniqueNames.
+func baseName(name string) string {
+	if i := strings.LastIndexByte(name, '#'); i >= 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+// taskKeys are the fields of a task, in column order. The PID is part of
+// the key of per-task fields.
+var taskKeys = []string{
+	"name", "interval_ns", "cputime_ns", "cputime_ms_per_s", "cputime_userland_ratio",
+	"intr_wakeups", "intr_wakeups_per_s", "idle_wakeups", "idle_wakeups_per_s",
+	"diskio_bytesread", "diskio_byteswritten", "packets_received", "packets_sent",
+	"bytes_received", "bytes_sent", "energy_impact", "energy_impact_per_s",
+}
+
+func appendTask(fields []Field, prefix string, t *types.TaskInfo) []Field {
+	values := []string{
+		t.Name,
+		strconv.FormatInt(t.IntervalNS, 10),
+		strconv.FormatInt(t.CPUTimeNS, 10),
+		formatFloat(t.CPUTimeMSPerS),
+		formatFloat(t.CPUTimeUserlandRatio),
+		strconv.FormatInt(t.IntrWakeups, 10),
+		formatFloat(t.IntrWakeupsPerS),
+		strconv.FormatInt(t.IdleWakeups, 10),
+		formatFloat(t.IdleWakeupsPerS),
+		strconv.FormatInt(t.DiskIOBytesRead, 10),
+		strconv.FormatInt(t.DiskIOBytesWritten, 10),
+		strconv.FormatInt(t.PacketsReceived, 10),
+		strconv.FormatInt(t.PacketsSent, 10),
+		strconv.FormatInt(t.BytesReceived, 10),
+		strconv.FormatInt(t.BytesSent, 10),
+		formatFloat(t.EnergyImpact),
+		formatFloat(t.EnergyImpactPerS),
+	}
+	for i, key := range taskKeys {
+		fields = append(fields, Field{prefix + key, values[i]})
+	}
+	return fields
+}
+
+// Unflatten rebuilds a sample from its fields, as returned by Flatten. The
+// order of the fields does not matter.
+func Unflatten(fields []Field) (types.Sample, error) {
+	var sampler string
+	for _, f := range fields {
+		if f.Key == "sampler" {
+			sampler = f.Value
+		}
+	}
+
+	var (
+		sample types.Sample
+		base   *types.BaseSample
+		set    func(key, value string) error
+	)
+	switch sampler {
+	case SamplerGPUPower:
+		s := &types.GPUPowerSample{}
+		sample, base, set = s, &s.BaseSample, gpuSetter(s)
+	case SamplerTasks:
+		s := &types.TasksSample{}
+		sample, base, set = s, &s.BaseSample, tasksSetter(s)
+	case SamplerBattery:
+		s := &types.BatterySample{}
+		sample, base = s, &s.BaseSample
+		set = func(key, value string) error {
+			if key != "battery.percent_charge" {
+				return errUnknownKey
+			}
+			v, err := strconv.Atoi(value)
+			s.Battery.PercentCharge = v
+			return err
+		}
+	case SamplerThermal:
+		s := &types.ThermalSample{}
+		sample, base = s, &s.BaseSample
+		set = func(key, value string) error {
+			if key != "thermal_pressure" {
+				return errUnknownKey
+			}
+			s.ThermalPressure = value
+			return nil
+		}
+	case "":
+		return nil, fmt.Errorf("missing sampler field")
+	default:
+		return nil, fmt.Errorf("unsupported sampler %q", sampler)
+	}
+
+	for _, f := range fields {
+		var err error
+		switch f.Key {
+		case "sampler":
+		case "timestamp":
+			base.Timestamp, err = parseTime(f.Value)
+		case "elapsed_ns":
+			base.ElapsedNS, err = strconv.ParseInt(f.Value, 10, 64)
+		case "hw_model":
+			base.HWModel = f.Value
+		case "kern_osversion":
+			base.KernOSVer = f.Value
+		case "kern_bootargs":
+			base.KernBootArgs = f.Value
+		case "kern_boottime":
+			base.KernBootTime, err = strconv.ParseInt(f.Value, 10, 64)
+		case "is_delta":
+			base.IsDelta, err = strconv.ParseBool(f.Value)
+		default:
+			err = set(f.Key, f.Value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field %q: %w", sampler, f.Key, err)
+		}
+	}
+	return sample, nil
+}
+
+var errUnknownKey = errors.New("unknown key")
+
+// gpuSetter returns a function setting the GPU fields of s. States are
+// appended in the order their fields appear.
+func gpuSetter(s *types.GPUPowerSample) func(key, value string) error {
+	dvfm := make(map[string]int)
+	requested := make(map[string]int)
+	states := make(map[string]int)
+	return func(key, value string) (err error) {
+		switch key {
+		case "gpu.freq_hz":
+			s.GPU.FreqHz, err = strconv.ParseFloat(value, 64)
+			return err
+		case "gpu.idle_ns":
+			s.GPU.IdleNS, err = strconv.ParseInt(value, 10, 64)
+			return err
+		case "gpu.idle_ratio":
+			s.GPU.IdleRatio, err = strconv.ParseFloat(value, 64)
+			return err
+		case "gpu.gpu_energy":
+			e, err := strconv.ParseInt(value, 10, 64)
+			s.GPU.GPUEnergy = &e
+			return err
+		}
+
+		name, field, ok := splitStateKey(key, "gpu.dvfm.")
+		if ok {
+			freq, err := strconv.ParseInt(baseName(name), 10, 64)
+			if err != nil {
+				return err
+			}
+			i, ok := dvfm[name]
+			if !ok {
+				i = len(s.GPU.DVFMStates)
+				dvfm[name] = i
+				s.GPU.DVFMStates = append(s.GPU.DVFMStates, types.DVFMState{Freq: freq})
+			}
+			return setResidency(field, value, &s.GPU.DVFMStates[i].UsedNS, &s.GPU.DVFMStates[i].UsedRatio)
+		}
+		if name, field, ok = splitStateKey(key, "gpu.sw_requested_state."); ok {
+			i, ok := requested[name]
+			if !ok {
+				i = len(s.GPU.SWRequestedState)
+				requested[name] = i
+				s.GPU.SWRequestedState = append(s.GPU.SWRequestedState, types.SWReqState{SWReqState: baseName(name)})
+			}
+			return setResidency(field, value, &s.GPU.SWRequestedState[i].UsedNS, &s.GPU.SWRequestedState[i].UsedRatio)
+		}
+		if name, field, ok = splitStateKey(key, "gpu.sw_state."); ok {
+			i, ok := states[name]
+			if !ok {
+				i = len(s.GPU.SWState)
+				states[name] = i
+				s.GPU.SWState = append(s.GPU.SWState, types.SWState{SWState: baseName(name)})
+			}
+			return setResidency(field, value, &s.GPU.SWState[i].UsedNS, &s.GPU.SWState[i].UsedRatio)
+		}
+		return errUnknownKey
+	}
+}
+
+// splitStateKey splits prefix<name>.<field>. State names may contain dots.
+func splitStateKey(key, prefix string) (name, field string, ok bool) {
+	rest, ok := strings.CutPrefix(key, prefix)
+	if !ok {
+		return "", "", false
+	}
+	i := strings.LastIndexByte(rest, '.')
+	if i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+func setResidency(field, value string, usedNS *int64, usedRatio *float64) (err error) {
+	switch field {
+	case "used_ns":
+		*usedNS, err = strconv.ParseInt(value, 10, 64)
+	case "used_ratio":
+		*usedRatio, err = strconv.ParseFloat(value, 64)
+	default:
+		err = errUnknownKey
+	}
+	return err
+}
+
+// tasksSetter returns a function setting the task fields of s. Tasks are
+// appended in the order their fields appear.
+func tasksSetter(s *types.TasksSample) func(key, value string) error {
+	tasks := make(map[string]int)
+	return func(key, value string) error {
+		if field, ok := strings.CutPrefix(key, "all_tasks."); ok {
+			return setTask(&s.AllTasks, field, value)
+		}
+		rest, ok := strings.CutPrefix(key, "tasks.")
+		if !ok {
+			return errUnknownKey
+		}
+		pidText, field, ok := strings.Cut(rest, ".")
+		if !ok {
+			return errUnknownKey
+		}
+		pid, err := strconv.Atoi(baseName(pidText))
+		if err != nil {
+			return err
+		}
+		i, ok := tasks[pidText]
+		if !ok {
+			i = len(s.Tasks)
+			tasks[pidText] = i
+			s.Tasks = append(s.Tasks, types.TaskInfo{PID: pid})
+		}
+		return setTask(&s.Tasks[i], field, value)
+	}
+}
+
+func setTask(t *types.TaskInfo, field, value string) (err error) {
+	ints := map[string]*int64{
+		"interval_ns":         &t.IntervalNS,
+		"cputime_ns":          &t.CPUTimeNS,
+		"intr_wakeups":        &t.IntrWakeups,
+		"idle_wakeups":        &t.IdleWakeups,
+		"diskio_bytesread":    &t.DiskIOBytesRead,
+		"diskio_byteswritten": &t.DiskIOBytesWritten,
+		"packets_received":    &t.PacketsReceived,
+		"packets_sent":        &t.PacketsSent,
+		"bytes_received":      &t.BytesReceived,
+		"bytes_sent":          &t.BytesSent,
+	}
+	floats := map[string]*float64{
+		"cputime_ms_per_s":       &t.CPUTimeMSPerS,
+		"cputime_userland_ratio": &t.CPUTimeUserlandRatio,
+		"intr_wakeups_per_s":     &t.IntrWakeupsPerS,
+		"idle_wakeups_per_s":     &t.IdleWakeupsPerS,
+		"energy_impact":          &t.EnergyImpact,
+		"energy_impact_per_s":    &t.EnergyImpactPerS,
+	}
+	if p, ok := ints[field]; ok {
+		*p, err = strconv.ParseInt(value, 10, 64)
+		return err
+	}
+	if p, ok := floats[field]; ok {
+		*p, err = strconv.ParseFloat(value, 64)
+		return err
+	}
+	if field == "name" {
+		t.Name = value
+		return nil
+	}
+	return errUnknownKey
+}
+
+// formatFloat formats v with the fewest digits that parse back to v.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatTime formats t in RFC 3339 with nanoseconds, or empty for the zero
+// time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
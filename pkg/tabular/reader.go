@@ -0,0 +1,138 @@
+package tabular
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Reader reads samples written by a Writer. The layout is detected from
+// the header.
+type Reader struct {
+	r      *csv.Reader
+	header []string
+	long   bool
+	// next is the first row of the next sample in the long layout.
+	next []string
+	line int
+}
+
+// NewReader returns a reader reading from r. Comma is the field delimiter;
+// zero means a comma.
+func NewReader(r io.Reader, comma rune) *Reader {
+	cr := csv.NewReader(r)
+	if comma != 0 {
+		cr.Comma = comma
+	}
+	cr.FieldsPerRecord = -1
+	return &Reader{r: cr}
+}
+
+// Layout returns the layout of the table, once the header was read by Read.
+func (r *Reader) Layout() Layout {
+	if r.long {
+		return Long
+	}
+	return Wide
+}
+
+// Read returns the next sample, or io.EOF at the end of the table.
+func (r *Reader) Read() (types.Sample, error) {
+	if r.header == nil {
+		header, err := r.r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		r.header = header
+		r.long = slices.Equal(header, LongHeader)
+	}
+	if r.long {
+		return r.readLong()
+	}
+	return r.readWide()
+}
+
+// ReadAll returns every remaining sample.
+func (r *Reader) ReadAll() ([]types.Sample, error) {
+	var samples []types.Sample
+	for {
+		sample, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return samples, nil
+		}
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
+	}
+}
+
+func (r *Reader) readWide() (types.Sample, error) {
+	row, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.line++
+	if len(row) != len(r.header) {
+		return nil, fmt.Errorf("row %d: expected %d cells, got %d", r.line, len(r.header), len(row))
+	}
+	fields := make([]Field, 0, len(row))
+	for i, value := range row {
+		// Empty cells belong to fields of other samples. The base fields
+		// are kept, as their empty values are meaningful.
+		if value != "" || slices.Contains(baseKeys, r.header[i]) {
+			fields = append(fields, Field{r.header[i], value})
+		}
+	}
+	sample, err := Unflatten(fields)
+	if err != nil {
+		return nil, fmt.Errorf("row %d: %w", r.line, err)
+	}
+	return sample, nil
+}
+
+func (r *Reader) readLong() (types.Sample, error) {
+	var (
+		fields []Field
+		index  string
+	)
+	for {
+		row := r.next
+		r.next = nil
+		if row == nil {
+			var err error
+			row, err = r.r.Read()
+			if errors.Is(err, io.EOF) && fields != nil {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			r.line++
+			if len(row) != len(LongHeader) {
+				return nil, fmt.Errorf("row %d: expected %d cells, got %d", r.line, len(LongHeader), len(row))
+			}
+		}
+		if fields == nil {
+			index = row[0]
+			fields = []Field{{"sampler", row[1]}, {"timestamp", row[2]}}
+		} else if row[0] != index {
+			r.next = row
+			break
+		}
+		fields = append(fields, Field{row[3], row[4]})
+	}
+
+	sample, err := Unflatten(fields)
+	if err != nil {
+		return nil, fmt.Errorf("sample %s: %w", index, err)
+	}
+	return sample, nil
+}
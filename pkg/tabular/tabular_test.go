@@ -0,0 +1,138 @@
+package tabular
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics/internal/sampletest"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestRoundTrip(t *testing.T) {
+	samples := sampletest.Samples(t)
+	columns, err := SampleColumns(samples)
+	if err != nil {
+		t.Fatalf("SampleColumns failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"wide", Options{}},
+		{"wide tsv", Options{Comma: '\t'}},
+		{"wide streaming", Options{Streaming: true, Columns: columns}},
+		{"long", Options{Layout: Long}},
+		{"long tsv streaming", Options{Layout: Long, Comma: '\t', Streaming: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, tt.opts)
+			for _, sample := range samples {
+				if err := w.Write(sample); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			if dropped := w.Dropped(); len(dropped) > 0 {
+				t.Errorf("Expected no dropped fields, got %v", dropped)
+			}
+
+			r := NewReader(&buf, tt.opts.Comma)
+			got, err := r.ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll failed: %v", err)
+			}
+			if r.Layout() != tt.opts.Layout {
+				t.Errorf("Expected layout %v, got %v", tt.opts.Layout, r.Layout())
+			}
+			if len(got) != len(samples) {
+				t.Fatalf("Expected %d samples, got %d", len(samples), len(got))
+			}
+			for i := range samples {
+				want, _ := Flatten(samples[i])
+				have, err := Flatten(got[i])
+				if err != nil {
+					t.Fatalf("Flatten failed: %v", err)
+				}
+				if !reflect.DeepEqual(want, have) {
+					t.Errorf("Sample %d differs after the round trip:\nwant %v\ngot  %v", i, want, have)
+				}
+			}
+		})
+	}
+}
+
+func TestWideColumns(t *testing.T) {
+	samples := sampletest.Read(t, sampletest.GPUPower)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Options{})
+	for _, sample := range samples {
+		if err := w.Write(sample); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(samples)+1 {
+		t.Fatalf("Expected a header and %d rows, got %d lines", len(samples), len(lines))
+	}
+	header := strings.Split(lines[0], ",")
+	if !reflect.DeepEqual(header[:len(baseKeys)], baseKeys) {
+		t.Errorf("Expected the header to start with %v, got %v", baseKeys, header[:len(baseKeys)])
+	}
+	for _, want := range []string{"gpu.gpu_energy", "gpu.dvfm.338.used_ratio", "gpu.dvfm.618.used_ns", "gpu.sw_state.SW_P1.used_ratio", "gpu.sw_requested_state.P2.used_ns", "gpu.dvfm.1182#2.used_ratio"} {
+		found := false
+		for _, column := range header {
+			found = found || column == want
+		}
+		if !found {
+			t.Errorf("Expected column %q in %v", want, header)
+		}
+	}
+}
+
+func TestStreamingDropsUnknownColumns(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Options{Streaming: true})
+	if err := w.Write(&types.ThermalSample{ThermalPressure: "Nominal"}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "thermal_pressure\n") {
+		t.Errorf("Expected the first sample to be written immediately, got %q", buf.String())
+	}
+	if err := w.Write(&types.BatterySample{Battery: types.BatteryInfo{PercentCharge: 50}}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got := w.Dropped(); !reflect.DeepEqual(got, []string{"battery.percent_charge"}) {
+		t.Errorf("Expected battery.percent_charge to be dropped, got %v", got)
+	}
+}
+
+func TestUnflattenErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []Field
+	}{
+		{"missing sampler", []Field{{"elapsed_ns", "1"}}},
+		{"unknown sampler", []Field{{"sampler", "cpu_power"}}},
+		{"unknown key", []Field{{"sampler", "thermal"}, {"gpu.idle_ns", "1"}}},
+		{"invalid number", []Field{{"sampler", "gpu_power"}, {"gpu.dvfm.338.used_ns", "many"}}},
+		{"invalid timestamp", []Field{{"sampler", "tasks"}, {"timestamp", "yesterday"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unflatten(tt.fields); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
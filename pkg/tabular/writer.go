@@ -0,0 +1,225 @@
+package tabular
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// Layout is the shape of the table.
+type Layout int
+
+// Layouts
+const (
+	// Wide writes one row per sample and one column per field. Cells of
+	// fields a sample does not have are empty.
+	Wide Layout = iota
+	// Long writes one row per field of a sample, with the columns of
+	// LongHeader.
+	Long
+)
+
+// LongHeader is the header of the long layout. The sample column numbers
+// the samples of a table from zero.
+var LongHeader = []string{"sample", "sampler", "timestamp", "key", "value"}
+
+// Options configures a Writer.
+type Options struct {
+	Layout Layout
+	// Comma is the field delimiter. Zero means a comma; use '\t' for TSV.
+	Comma rune
+	// Streaming writes the rows of every sample to the underlying writer as
+	// soon as it is written. In the wide layout, the header is then Columns,
+	// or the fields of the first sample.
+	Streaming bool
+	// Columns fixes the columns of the wide layout, such as those returned
+	// by SampleColumns.
+	Columns []string
+}
+
+// Writer writes samples as CSV or TSV.
+//
+// In the wide layout, the columns are Options.Columns when set, otherwise
+// they are the fields of the samples written before the first Flush, or the
+// fields of the first sample when streaming. Fields of later samples that
+// are not in the header are dropped and reported by Dropped; use Columns,
+// or the long layout, when samplers are mixed or tasks come and go.
+type Writer struct {
+	w         *csv.Writer
+	opts      Options
+	header    []string
+	index     map[string]int
+	pending   [][]Field
+	dropped   map[string]bool
+	samples   int
+	wroteHead bool
+}
+
+// NewWriter returns a writer writing to w.
+func NewWriter(w io.Writer, opts Options) *Writer {
+	cw := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+	writer := &Writer{w: cw, opts: opts, dropped: make(map[string]bool)}
+	if opts.Columns != nil {
+		writer.setHeader(opts.Columns)
+	}
+	return writer
+}
+
+// Write adds a sample.
+func (w *Writer) Write(sample types.Sample) error {
+	fields, err := Flatten(sample)
+	if err != nil {
+		return err
+	}
+
+	if w.opts.Layout == Long {
+		if err := w.writeLong(fields); err != nil {
+			return err
+		}
+	} else {
+		if w.header == nil && !w.opts.Streaming {
+			w.pending = append(w.pending, fields)
+			return nil
+		}
+		if w.header == nil {
+			w.setHeader(keys(fields))
+		}
+		if err := w.writeWide(fields); err != nil {
+			return err
+		}
+	}
+	if w.opts.Streaming {
+		w.w.Flush()
+		return w.w.Error()
+	}
+	return nil
+}
+
+// Flush writes the rows not yet written to the underlying writer. In the
+// wide layout, the first Flush decides the header unless it was set.
+func (w *Writer) Flush() error {
+	if w.opts.Layout == Wide && w.header == nil && len(w.pending) > 0 {
+		w.setHeader(Columns(w.pending...))
+	}
+	for _, fields := range w.pending {
+		if err := w.writeWide(fields); err != nil {
+			return err
+		}
+	}
+	w.pending = nil
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Dropped returns the fields that were not written because they were
+// missing from the wide header.
+func (w *Writer) Dropped() []string {
+	dropped := make([]string, 0, len(w.dropped))
+	for key := range w.dropped {
+		dropped = append(dropped, key)
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+func (w *Writer) setHeader(header []string) {
+	w.header = header
+	w.index = make(map[string]int, len(header))
+	for i, key := range header {
+		w.index[key] = i
+	}
+}
+
+func (w *Writer) writeWide(fields []Field) error {
+	if !w.wroteHead {
+		if err := w.w.Write(w.header); err != nil {
+			return err
+		}
+		w.wroteHead = true
+	}
+	row := make([]string, len(w.header))
+	for _, f := range fields {
+		i, ok := w.index[f.Key]
+		if !ok {
+			w.dropped[f.Key] = true
+			continue
+		}
+		row[i] = f.Value
+	}
+	return w.w.Write(row)
+}
+
+func (w *Writer) writeLong(fields []Field) error {
+	if !w.wroteHead {
+		if err := w.w.Write(LongHeader); err != nil {
+			return err
+		}
+		w.wroteHead = true
+	}
+	var sampler, timestamp string
+	for _, f := range fields {
+		switch f.Key {
+		case "sampler":
+			sampler = f.Value
+		case "timestamp":
+			timestamp = f.Value
+		}
+	}
+	index := strconv.Itoa(w.samples)
+	for _, f := range fields {
+		if f.Key == "sampler" || f.Key == "timestamp" {
+			continue
+		}
+		if err := w.w.Write([]string{index, sampler, timestamp, f.Key, f.Value}); err != nil {
+			return err
+		}
+	}
+	w.samples++
+	return nil
+}
+
+// Columns returns the wide layout columns of the given flattened samples:
+// the base fields, then every other field in order of first appearance.
+func Columns(samples ...[]Field) []string {
+	columns := append([]string(nil), baseKeys...)
+	seen := make(map[string]bool)
+	for _, key := range baseKeys {
+		seen[key] = true
+	}
+	for _, fields := range samples {
+		for _, f := range fields {
+			if !seen[f.Key] {
+				seen[f.Key] = true
+				columns = append(columns, f.Key)
+			}
+		}
+	}
+	return columns
+}
+
+// SampleColumns flattens samples and returns their wide layout columns.
+func SampleColumns(samples []types.Sample) ([]string, error) {
+	flattened := make([][]Field, 0, len(samples))
+	for _, sample := range samples {
+		fields, err := Flatten(sample)
+		if err != nil {
+			return nil, err
+		}
+		flattened = append(flattened, fields)
+	}
+	return Columns(flattened...), nil
+}
+
+func keys(fields []Field) []string {
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.Key
+	}
+	return keys
+}
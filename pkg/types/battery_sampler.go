@@ -1,5 +1,7 @@
 package types
 
+import "github.com/matiasinsaurralde/powermetrics/pkg/units"
+
 type BatterySample struct {
 	BaseSample
 	Battery BatteryInfo `plist:"battery"`
@@ -8,3 +10,8 @@ type BatterySample struct {
 type BatteryInfo struct {
 	PercentCharge int `plist:"percent_charge"`
 }
+
+// Charge returns the battery charge level as a ratio.
+func (b *BatteryInfo) Charge() units.Ratio {
+	return units.Ratio(b.PercentCharge) / 100
+}
@@ -0,0 +1,129 @@
+package types
+
+import (
+	"sort"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
+// PState is the residency of the GPU in a single DVFM frequency.
+type PState struct {
+	Frequency units.Frequency
+	Used      time.Duration
+	Residency units.Ratio
+}
+
+// GPUMetrics holds metrics derived from one or more GPU power samples.
+type GPUMetrics struct {
+	// Elapsed is the total length of the sampled intervals.
+	Elapsed time.Duration
+	// Active is the fraction of Elapsed the GPU was not idle.
+	Active units.Ratio
+	// EffectiveFrequency is the residency-weighted average over the DVFM
+	// states. It is zero when no DVFM state was used.
+	EffectiveFrequency units.Frequency
+	// Energy is the total GPU energy. HasEnergy reports whether any sample
+	// carried an energy reading.
+	Energy    units.Energy
+	HasEnergy bool
+	// AveragePower is Energy over Elapsed. It is zero when HasEnergy is false
+	// or Elapsed is zero.
+	AveragePower units.Power
+	// PStates lists the DVFM states ordered by frequency, with duplicated
+	// frequencies merged. Residency is relative to Elapsed.
+	PStates []PState
+}
+
+// Busiest returns the DVFM state with the highest residency. It reports false
+// when no state was used.
+func (m GPUMetrics) Busiest() (PState, bool) {
+	var busiest PState
+	for _, state := range m.PStates {
+		if state.Used > busiest.Used {
+			busiest = state
+		}
+	}
+	return busiest, busiest.Used > 0
+}
+
+// Metrics returns the metrics derived from a single sample.
+func (s *GPUPowerSample) Metrics() GPUMetrics {
+	return GPUSeries{s}.Metrics()
+}
+
+// GPUSeries is an ordered series of GPU power samples, such as
+// powermetrics.Result.Samples.
+type GPUSeries []*GPUPowerSample
+
+// Metrics returns the metrics derived from every sample of the series. Ratios
+// are weighted by each sample's elapsed time. Samples without a positive
+// elapsed time are skipped entirely, energy included, so that they do not
+// skew AveragePower.
+func (series GPUSeries) Metrics() GPUMetrics {
+	var (
+		m       GPUMetrics
+		active  float64
+		used    = make(map[int64]time.Duration)
+		weights float64
+		freqSum float64
+	)
+	for _, s := range series {
+		if s == nil {
+			continue
+		}
+		elapsed := s.Elapsed()
+		if elapsed <= 0 {
+			continue
+		}
+		if energy, ok := s.GPU.Energy(); ok {
+			m.Energy += energy
+			m.HasEnergy = true
+		}
+		m.Elapsed += elapsed
+		active += clampRatio(1-s.GPU.IdleRatio) * float64(elapsed)
+		for _, state := range s.GPU.DVFMStates {
+			d := state.Used()
+			if d <= 0 && state.UsedRatio > 0 {
+				d = state.Residency().Of(elapsed)
+			}
+			used[state.Freq] += d
+			weights += float64(d)
+			freqSum += float64(d) * float64(state.Freq)
+		}
+	}
+
+	if m.Elapsed > 0 {
+		m.Active = units.Ratio(active / float64(m.Elapsed))
+		if m.HasEnergy {
+			m.AveragePower = m.Energy.Over(m.Elapsed)
+		}
+	}
+	if weights > 0 {
+		m.EffectiveFrequency = units.Frequency(freqSum/weights) * units.Megahertz
+	}
+
+	m.PStates = make([]PState, 0, len(used))
+	for freq, d := range used {
+		m.PStates = append(m.PStates, PState{
+			Frequency: units.Frequency(freq) * units.Megahertz,
+			Used:      d,
+			Residency: units.RatioOf(d, m.Elapsed),
+		})
+	}
+	sort.Slice(m.PStates, func(i, j int) bool {
+		return m.PStates[i].Frequency < m.PStates[j].Frequency
+	})
+	return m
+}
+
+func clampRatio(r float64) float64 {
+	switch {
+	case r < 0:
+		return 0
+	case r > 1:
+		return 1
+	default:
+		return r
+	}
+}
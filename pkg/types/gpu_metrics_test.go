@@ -0,0 +1,96 @@
+package types
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestGPUSeriesMetrics(t *testing.T) {
+	first := &GPUPowerSample{
+		BaseSample: BaseSample{ElapsedNS: int64(time.Second)},
+		GPU: GPUInfo{
+			IdleRatio: 0.75,
+			DVFMStates: []DVFMState{
+				{Freq: 338, UsedNS: int64(100 * time.Millisecond)},
+				{Freq: 1182, UsedNS: int64(50 * time.Millisecond)},
+				{Freq: 1182, UsedNS: int64(100 * time.Millisecond)},
+			},
+			GPUEnergy: int64Ptr(500),
+		},
+	}
+	second := &GPUPowerSample{
+		BaseSample: BaseSample{ElapsedNS: int64(3 * time.Second)},
+		GPU: GPUInfo{
+			IdleRatio: 0.25,
+			DVFMStates: []DVFMState{
+				{Freq: 338, UsedNS: int64(250 * time.Millisecond)},
+				{Freq: 1182, UsedNS: int64(2 * time.Second)},
+			},
+			GPUEnergy: int64Ptr(3500),
+		},
+	}
+	zero := &GPUPowerSample{
+		GPU: GPUInfo{IdleRatio: 1, GPUEnergy: int64Ptr(0)},
+	}
+
+	m := GPUSeries{first, zero, second}.Metrics()
+
+	if m.Elapsed != 4*time.Second {
+		t.Errorf("Expected elapsed to be 4s, got %v", m.Elapsed)
+	}
+	if math.Abs(float64(m.Active)-0.625) > 1e-9 {
+		t.Errorf("Expected active ratio to be 0.625, got %f", m.Active)
+	}
+	if !m.HasEnergy || math.Abs(m.Energy.Joules()-4) > 1e-9 {
+		t.Errorf("Expected energy to be 4 J, got %v", m.Energy)
+	}
+	if math.Abs(m.AveragePower.Watts()-1) > 1e-9 {
+		t.Errorf("Expected average power to be 1 W, got %v", m.AveragePower)
+	}
+
+	if len(m.PStates) != 2 {
+		t.Fatalf("Expected duplicated frequencies to be merged into 2 states, got %d", len(m.PStates))
+	}
+	busiest, ok := m.Busiest()
+	if !ok || busiest.Frequency.Megahertz() != 1182 {
+		t.Fatalf("Expected busiest state to be 1182 MHz, got %v", busiest.Frequency)
+	}
+	if busiest.Used != 2150*time.Millisecond {
+		t.Errorf("Expected busiest state to be used for 2.15s, got %v", busiest.Used)
+	}
+
+	expected := (338*350.0 + 1182*2150.0) / 2500.0
+	if math.Abs(m.EffectiveFrequency.Megahertz()-expected) > 1e-9 {
+		t.Errorf("Expected effective frequency to be %f MHz, got %f", expected, m.EffectiveFrequency.Megahertz())
+	}
+}
+
+func TestGPUMetricsZeroInterval(t *testing.T) {
+	sample := &GPUPowerSample{GPU: GPUInfo{IdleRatio: 0.5, GPUEnergy: int64Ptr(10)}}
+	m := sample.Metrics()
+
+	if m.Elapsed != 0 || m.Active != 0 || m.AveragePower != 0 {
+		t.Errorf("Expected zero-length interval to produce zero ratios and power, got %+v", m)
+	}
+	if _, ok := m.Busiest(); ok {
+		t.Error("Expected no busiest state for an idle sample")
+	}
+}
+
+func TestGPUSeriesMetricsSkipsZeroInterval(t *testing.T) {
+	series := GPUSeries{
+		{BaseSample: BaseSample{ElapsedNS: int64(time.Second)}, GPU: GPUInfo{GPUEnergy: int64Ptr(1000)}},
+		{GPU: GPUInfo{GPUEnergy: int64Ptr(500)}},
+	}
+	m := series.Metrics()
+
+	if m.Energy.Joules() != 1 {
+		t.Errorf("Expected 1 J, got %v", m.Energy)
+	}
+	if m.AveragePower.Watts() != 1 {
+		t.Errorf("Expected an average power of 1 W, got %v", m.AveragePower)
+	}
+}
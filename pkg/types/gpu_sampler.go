@@ -1,34 +1,110 @@
 package types
 
+import (
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/units"
+)
+
 type GPUPowerSample struct {
 	BaseSample
 	GPU GPUInfo `plist:"gpu"`
 }
 
+// Power returns the average GPU power over the sample interval, derived from
+// the interval energy and ElapsedNS. It reports false when the sample has no
+// energy reading or no elapsed time.
+func (s *GPUPowerSample) Power() (units.Power, bool) {
+	energy, ok := s.GPU.Energy()
+	if !ok || s.ElapsedNS <= 0 {
+		return 0, false
+	}
+	return energy.Over(s.Elapsed()), true
+}
+
 type GPUInfo struct {
+	// FreqHz is the average active frequency. Despite its name powermetrics
+	// reports it in MHz; use Frequency for a unit-safe value.
 	FreqHz           float64      `plist:"freq_hz"`
 	IdleNS           int64        `plist:"idle_ns"`
 	IdleRatio        float64      `plist:"idle_ratio"`
 	DVFMStates       []DVFMState  `plist:"dvfm_states"`
 	SWRequestedState []SWReqState `plist:"sw_requested_state"`
 	SWState          []SWState    `plist:"sw_state"`
-	GPUEnergy        *int64       `plist:"gpu_energy,omitempty"`
+	// GPUEnergy is the energy spent during the sample interval in mJ.
+	GPUEnergy *int64 `plist:"gpu_energy,omitempty"`
+}
+
+// Frequency returns the average active GPU frequency.
+func (g *GPUInfo) Frequency() units.Frequency {
+	return units.Frequency(g.FreqHz) * units.Megahertz
+}
+
+// Idle returns the time the GPU spent idle during the interval.
+func (g *GPUInfo) Idle() time.Duration {
+	return time.Duration(g.IdleNS)
+}
+
+// Energy returns the GPU energy spent during the interval. It reports false
+// when powermetrics did not include a gpu_energy reading.
+func (g *GPUInfo) Energy() (units.Energy, bool) {
+	if g.GPUEnergy == nil {
+		return 0, false
+	}
+	return units.Energy(*g.GPUEnergy) * units.Millijoule, true
 }
 
 type DVFMState struct {
+	// Freq is the state frequency in MHz; use Frequency for a unit-safe value.
 	Freq      int64   `plist:"freq"`
 	UsedNS    int64   `plist:"used_ns"`
 	UsedRatio float64 `plist:"used_ratio"`
 }
 
+// Frequency returns the frequency of the DVFM state.
+func (d *DVFMState) Frequency() units.Frequency {
+	return units.Frequency(d.Freq) * units.Megahertz
+}
+
+// Used returns the time spent in the DVFM state.
+func (d *DVFMState) Used() time.Duration {
+	return time.Duration(d.UsedNS)
+}
+
+// Residency returns the fraction of the interval spent in the DVFM state.
+func (d *DVFMState) Residency() units.Ratio {
+	return units.Ratio(d.UsedRatio)
+}
+
 type SWReqState struct {
 	SWReqState string  `plist:"sw_req_state"`
 	UsedNS     int64   `plist:"used_ns"`
 	UsedRatio  float64 `plist:"used_ratio"`
 }
 
+// Used returns the time spent in the requested software state.
+func (s *SWReqState) Used() time.Duration {
+	return time.Duration(s.UsedNS)
+}
+
+// Residency returns the fraction of the interval spent in the requested
+// software state.
+func (s *SWReqState) Residency() units.Ratio {
+	return units.Ratio(s.UsedRatio)
+}
+
 type SWState struct {
 	SWState   string  `plist:"sw_state"`
 	UsedNS    int64   `plist:"used_ns"`
 	UsedRatio float64 `plist:"used_ratio"`
 }
+
+// Used returns the time spent in the software state.
+func (s *SWState) Used() time.Duration {
+	return time.Duration(s.UsedNS)
+}
+
+// Residency returns the fraction of the interval spent in the software state.
+func (s *SWState) Residency() units.Ratio {
+	return units.Ratio(s.UsedRatio)
+}
@@ -49,3 +49,8 @@ func (s *BaseSample) GetKernBootTime() int64 {
 func (s *BaseSample) GetIsDelta() bool {
 	return s.IsDelta
 }
+
+// Elapsed returns the length of the sample interval.
+func (s *BaseSample) Elapsed() time.Duration {
+	return time.Duration(s.ElapsedNS)
+}
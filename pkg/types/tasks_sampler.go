@@ -0,0 +1,41 @@
+package types
+
+import "time"
+
+type TasksSample struct {
+	BaseSample
+	Tasks    []TaskInfo `plist:"tasks"`
+	AllTasks TaskInfo   `plist:"all_tasks"`
+}
+
+type TaskInfo struct {
+	PID                  int     `plist:"pid"`
+	Name                 string  `plist:"name"`
+	IntervalNS           int64   `plist:"interval_ns"`
+	CPUTimeNS            int64   `plist:"cputime_ns"`
+	CPUTimeMSPerS        float64 `plist:"cputime_ms_per_s"`
+	CPUTimeUserlandRatio float64 `plist:"cputime_userland_ratio"`
+	IntrWakeups          int64   `plist:"intr_wakeups"`
+	IntrWakeupsPerS      float64 `plist:"intr_wakeups_per_s"`
+	IdleWakeups          int64   `plist:"idle_wakeups"`
+	IdleWakeupsPerS      float64 `plist:"idle_wakeups_per_s"`
+	DiskIOBytesRead      int64   `plist:"diskio_bytesread"`
+	DiskIOBytesWritten   int64   `plist:"diskio_byteswritten"`
+	PacketsReceived      int64   `plist:"packets_received"`
+	PacketsSent          int64   `plist:"packets_sent"`
+	BytesReceived        int64   `plist:"bytes_received"`
+	BytesSent            int64   `plist:"bytes_sent"`
+	EnergyImpact         float64 `plist:"energy_impact"`
+	EnergyImpactPerS     float64 `plist:"energy_impact_per_s"`
+}
+
+// CPUTime returns the CPU time the task used during the interval.
+func (t *TaskInfo) CPUTime() time.Duration {
+	return time.Duration(t.CPUTimeNS)
+}
+
+// Wakeups returns the interrupt and idle wakeups of the task during the
+// interval.
+func (t *TaskInfo) Wakeups() int64 {
+	return t.IntrWakeups + t.IdleWakeups
+}
@@ -0,0 +1,21 @@
+package types
+
+type ThermalSample struct {
+	BaseSample
+	ThermalPressure string `plist:"thermal_pressure"`
+}
+
+// ThermalPressureLevels lists the thermal pressure levels reported by
+// powermetrics, from least to most severe.
+var ThermalPressureLevels = []string{"Nominal", "Moderate", "Heavy", "Trapping", "Sleeping"}
+
+// ThermalPressureRank returns the position of level in ThermalPressureLevels.
+// Unknown levels rank above every known one.
+func ThermalPressureRank(level string) int {
+	for i, l := range ThermalPressureLevels {
+		if l == level {
+			return i
+		}
+	}
+	return len(ThermalPressureLevels)
+}
@@ -4,8 +4,8 @@ type ResultCollection struct {
 	Samples []Sample
 }
 
-func (rc *ResultCollection) GetGPUSamples() []*GPUPowerSample {
-	var gpuSamples []*GPUPowerSample
+func (rc *ResultCollection) GetGPUSamples() GPUSeries {
+	var gpuSamples GPUSeries
 	for _, sample := range rc.Samples {
 		if gpuSample, ok := sample.(*GPUPowerSample); ok {
 			gpuSamples = append(gpuSamples, gpuSample)
@@ -23,3 +23,23 @@ func (rc *ResultCollection) GetBatterySamples() []*BatterySample {
 	}
 	return batterySamples
 }
+
+func (rc *ResultCollection) GetTasksSamples() []*TasksSample {
+	var tasksSamples []*TasksSample
+	for _, sample := range rc.Samples {
+		if tasksSample, ok := sample.(*TasksSample); ok {
+			tasksSamples = append(tasksSamples, tasksSample)
+		}
+	}
+	return tasksSamples
+}
+
+func (rc *ResultCollection) GetThermalSamples() []*ThermalSample {
+	var thermalSamples []*ThermalSample
+	for _, sample := range rc.Samples {
+		if thermalSample, ok := sample.(*ThermalSample); ok {
+			thermalSamples = append(thermalSamples, thermalSample)
+		}
+	}
+	return thermalSamples
+}
@@ -0,0 +1,152 @@
+// Package units provides unit-safe types for the physical quantities
+// reported by powermetrics.
+//
+// powermetrics reports energy per sampling interval in millijoules and
+// frequencies in megahertz, while field names such as freq_hz suggest
+// otherwise. The types in this package carry their unit with them so that
+// callers never have to remember which scale a raw field uses.
+package units
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Energy is an amount of energy stored in joules.
+type Energy float64
+
+// Common energy units.
+const (
+	Microjoule Energy = 1e-6
+	Millijoule Energy = 1e-3
+	Joule      Energy = 1
+	Kilojoule  Energy = 1e3
+	WattHour   Energy = 3600
+)
+
+// Joules returns the energy as a floating point number of joules.
+func (e Energy) Joules() float64 { return float64(e) }
+
+// Millijoules returns the energy as a floating point number of millijoules.
+func (e Energy) Millijoules() float64 { return float64(e / Millijoule) }
+
+// WattHours returns the energy as a floating point number of watt-hours.
+func (e Energy) WattHours() float64 { return float64(e / WattHour) }
+
+// Over returns the average power needed to spend e during d.
+// It returns zero when d is not positive.
+func (e Energy) Over(d time.Duration) Power {
+	if d <= 0 {
+		return 0
+	}
+	return Power(float64(e) / d.Seconds())
+}
+
+// String formats the energy with an SI prefix, e.g. "788 mJ".
+func (e Energy) String() string { return formatSI(float64(e), "J") }
+
+// Power is a rate of energy use stored in watts.
+type Power float64
+
+// Common power units.
+const (
+	Microwatt Power = 1e-6
+	Milliwatt Power = 1e-3
+	Watt      Power = 1
+	Kilowatt  Power = 1e3
+)
+
+// Watts returns the power as a floating point number of watts.
+func (p Power) Watts() float64 { return float64(p) }
+
+// Milliwatts returns the power as a floating point number of milliwatts.
+func (p Power) Milliwatts() float64 { return float64(p / Milliwatt) }
+
+// Over returns the energy spent drawing p for d.
+func (p Power) Over(d time.Duration) Energy {
+	return Energy(float64(p) * d.Seconds())
+}
+
+// String formats the power with an SI prefix, e.g. "157 mW".
+func (p Power) String() string { return formatSI(float64(p), "W") }
+
+// Frequency is a clock frequency stored in hertz.
+type Frequency float64
+
+// Common frequency units.
+const (
+	Hertz     Frequency = 1
+	Kilohertz Frequency = 1e3
+	Megahertz Frequency = 1e6
+	Gigahertz Frequency = 1e9
+)
+
+// Hertz returns the frequency as a floating point number of hertz.
+func (f Frequency) Hertz() float64 { return float64(f) }
+
+// Megahertz returns the frequency as a floating point number of megahertz.
+func (f Frequency) Megahertz() float64 { return float64(f / Megahertz) }
+
+// Gigahertz returns the frequency as a floating point number of gigahertz.
+func (f Frequency) Gigahertz() float64 { return float64(f / Gigahertz) }
+
+// String formats the frequency with an SI prefix, e.g. "1.38 GHz".
+func (f Frequency) String() string { return formatSI(float64(f), "Hz") }
+
+// Ratio is a dimensionless fraction of a whole, usually of a time interval.
+// A value of 1 means 100%.
+type Ratio float64
+
+// RatioOf returns part as a fraction of whole. It returns zero when whole is
+// not positive.
+func RatioOf(part, whole time.Duration) Ratio {
+	if whole <= 0 {
+		return 0
+	}
+	return Ratio(float64(part) / float64(whole))
+}
+
+// Percent returns the ratio as a percentage.
+func (r Ratio) Percent() float64 { return float64(r) * 100 }
+
+// Of returns the portion of d covered by the ratio.
+func (r Ratio) Of(d time.Duration) time.Duration {
+	return time.Duration(math.Round(float64(r) * float64(d)))
+}
+
+// Complement returns 1 - r.
+func (r Ratio) Complement() Ratio { return 1 - r }
+
+// String formats the ratio as a percentage, e.g. "98.25%".
+func (r Ratio) String() string { return fmt.Sprintf("%.2f%%", r.Percent()) }
+
+var siPrefixes = []struct {
+	scale  float64
+	prefix string
+}{
+	{1e9, "G"},
+	{1e6, "M"},
+	{1e3, "k"},
+	{1, ""},
+	{1e-3, "m"},
+	{1e-6, "µ"},
+	{1e-9, "n"},
+}
+
+// formatSI formats v using the largest SI prefix that keeps the mantissa at
+// or above one, with three significant digits.
+func formatSI(v float64, unit string) string {
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%g %s", v, unit)
+	}
+	abs := math.Abs(v)
+	p := siPrefixes[len(siPrefixes)-1]
+	for _, candidate := range siPrefixes {
+		if abs >= candidate.scale {
+			p = candidate
+			break
+		}
+	}
+	return fmt.Sprintf("%.3g %s%s", v/p.scale, p.prefix, unit)
+}
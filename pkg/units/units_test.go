@@ -0,0 +1,63 @@
+package units
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEnergyOver(t *testing.T) {
+	energy := 788 * Millijoule
+	power := energy.Over(5 * time.Second)
+	if math.Abs(power.Milliwatts()-157.6) > 1e-9 {
+		t.Errorf("Expected 157.6 mW, got %f", power.Milliwatts())
+	}
+
+	if got := energy.Over(0); got != 0 {
+		t.Errorf("Expected zero power for zero duration, got %v", got)
+	}
+
+	if got := power.Over(5 * time.Second); math.Abs(got.Millijoules()-788) > 1e-9 {
+		t.Errorf("Expected 788 mJ round trip, got %f", got.Millijoules())
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{ String() string }
+		expected string
+	}{
+		{"millijoules", 788 * Millijoule, "788 mJ"},
+		{"joules", 12.5 * Joule, "12.5 J"},
+		{"milliwatts", 157.6 * Milliwatt, "158 mW"},
+		{"zero power", Power(0), "0 W"},
+		{"megahertz", 338 * Megahertz, "338 MHz"},
+		{"gigahertz", 1380 * Megahertz, "1.38 GHz"},
+		{"ratio", Ratio(0.982517), "98.25%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.value.String(); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRatio(t *testing.T) {
+	r := RatioOf(250*time.Millisecond, time.Second)
+	if r != 0.25 {
+		t.Errorf("Expected ratio 0.25, got %f", r)
+	}
+	if r.Of(4*time.Second) != time.Second {
+		t.Errorf("Expected 1s, got %v", r.Of(4*time.Second))
+	}
+	if r.Complement() != 0.75 {
+		t.Errorf("Expected complement 0.75, got %f", r.Complement())
+	}
+	if RatioOf(time.Second, 0) != 0 {
+		t.Error("Expected zero ratio for zero whole")
+	}
+}
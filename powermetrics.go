@@ -4,11 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
-	"strings"
 	"time"
 
 	"github.com/matiasinsaurralde/powermetrics/internal/samplers"
-	howett_plist "howett.net/plist"
 )
 
 // CommandRunner interface for executing external commands
@@ -57,6 +55,8 @@ type Sampler string
 
 const (
 	GPUPower Sampler = "gpu_power"
+	Tasks    Sampler = "tasks"
+	Thermal  Sampler = "thermal"
 )
 
 // Format represents the output format
@@ -71,11 +71,14 @@ const (
 var (
 	ErrUnsupportedSampler = fmt.Errorf("unsupported sampler")
 	ErrUnsupportedFormat  = fmt.Errorf("unsupported format")
+	ErrNoSamplers         = fmt.Errorf("no samplers")
 )
 
 // Supported samplers
 var supportedSamplers = map[Sampler]bool{
 	GPUPower: true,
+	Tasks:    true,
+	Thermal:  true,
 }
 
 // Config holds the configuration for powermetrics execution
@@ -162,25 +165,8 @@ func (p *Powermetrics) Collect(config *Config) (*Result, error) {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	// Build command arguments
-	samplerStrings := make([]string, len(config.Samplers))
-	for i, sampler := range config.Samplers {
-		samplerStrings[i] = string(sampler)
-	}
-
-	args := []string{
-		fmt.Sprintf("--sample-count=%d", config.SampleCount),
-		fmt.Sprintf("--format=%s", config.Format),
-		fmt.Sprintf("--samplers=%s", strings.Join(samplerStrings, ",")),
-	}
-
-	// Add sample rate if specified
-	if config.SampleRate > 0 {
-		args = append(args, fmt.Sprintf("--sample-rate=%d", int(config.SampleRate.Milliseconds())))
-	}
-
 	// Execute powermetrics command
-	output, err := p.runner.Run("powermetrics", args...)
+	output, err := p.runner.Run("powermetrics", buildArgs(config)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute powermetrics: %w", err)
 	}
@@ -201,12 +187,11 @@ func (p *Powermetrics) Collect(config *Config) (*Result, error) {
 			}
 		} else {
 			// Fall back to single sample parsing
-			var parsed samplers.PlistRoot
-			decoder := howett_plist.NewDecoder(bytes.NewReader(output))
-			if err := decoder.Decode(&parsed); err != nil {
+			parsed, err := decodeSample(output)
+			if err != nil {
 				return nil, fmt.Errorf("failed to decode plist output: %w", err)
 			}
-			result.PlistData = &parsed
+			result.PlistData = parsed
 		}
 	}
 
@@ -229,13 +214,12 @@ func parseMultipleSamples(output []byte) ([]*samplers.PlistRoot, error) {
 		// Reconstruct the XML with the header
 		xmlData := append([]byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>"), part...)
 
-		var parsed samplers.PlistRoot
-		decoder := howett_plist.NewDecoder(bytes.NewReader(xmlData))
-		if err := decoder.Decode(&parsed); err != nil {
+		parsed, err := decodeSample(xmlData)
+		if err != nil {
 			continue // Skip invalid plists
 		}
 
-		samples = append(samples, &parsed)
+		samples = append(samples, parsed)
 	}
 
 	if len(samples) == 0 {
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/matiasinsaurralde/powermetrics/internal/samplers"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
 	howett_plist "howett.net/plist"
 )
 
@@ -342,3 +343,68 @@ func TestNewAndNewWithRunner(t *testing.T) {
 		t.Error("Expected NewWithRunner() to use the provided runner")
 	}
 }
+
+func TestGPUPowerUnits(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power.xml")
+	if err != nil {
+		t.Fatalf("Failed to read gpu_power.xml: %v", err)
+	}
+
+	var parsed samplers.PlistRoot
+	decoder := howett_plist.NewDecoder(bytes.NewReader(xmlData))
+	if err := decoder.Decode(&parsed); err != nil {
+		t.Fatalf("Failed to decode plist: %v", err)
+	}
+
+	if got := parsed.GPU.Frequency().Megahertz(); got != 338 {
+		t.Errorf("Expected GPU frequency to be 338 MHz, got %f", got)
+	}
+
+	if got := parsed.GPU.DVFMStates[0].Frequency().Megahertz(); got != 338 {
+		t.Errorf("Expected first DVFM state to be 338 MHz, got %f", got)
+	}
+
+	energy, ok := parsed.GPU.Energy()
+	if !ok {
+		t.Fatal("Expected GPU energy to be present")
+	}
+	if energy.Millijoules() != 9 {
+		t.Errorf("Expected GPU energy to be 9 mJ, got %f", energy.Millijoules())
+	}
+
+	power, ok := parsed.Power()
+	if !ok {
+		t.Fatal("Expected GPU power to be available")
+	}
+	expected := 9e-3 / (float64(parsed.ElapsedNS) / 1e9)
+	if diff := power.Watts() - expected; diff > 1e-12 || diff < -1e-12 {
+		t.Errorf("Expected GPU power to be %g W, got %g W", expected, power.Watts())
+	}
+}
+
+func TestResultGPUMetrics(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+	result, err := pm.Collect(DefaultConfig().GPU())
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	m := types.GPUSeries(result.Samples).Metrics()
+	if !m.HasEnergy || m.Energy.Millijoules() != 19+107+788+146+126 {
+		t.Errorf("Expected summed GPU energy of 1186 mJ, got %v", m.Energy)
+	}
+	if m.Active <= 0 || m.Active >= 1 {
+		t.Errorf("Expected active ratio between 0 and 1, got %v", m.Active)
+	}
+	if m.EffectiveFrequency <= 0 {
+		t.Errorf("Expected positive effective frequency, got %v", m.EffectiveFrequency)
+	}
+	if _, ok := m.Busiest(); !ok {
+		t.Error("Expected a busiest P-state")
+	}
+}
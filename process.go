@@ -0,0 +1,363 @@
+package powermetrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// DefaultProcessSampleRate is the sample rate RunProcess uses when no
+// configuration is given. The tasks sampler is more expensive than the GPU
+// sampler, so it is sampled less often than DefaultMeasureSampleRate.
+const DefaultProcessSampleRate = 500 * time.Millisecond
+
+// ProcessUsage is the resource usage attributed to a spawned command and
+// its descendants.
+type ProcessUsage struct {
+	// PID is the process ID of the command.
+	PID int
+	// PIDs lists every process ID attributed to the command, in ascending
+	// order.
+	PIDs []int
+	// CPUTime, Wakeups and EnergyImpact are summed over the tasks of the
+	// attributed processes only.
+	CPUTime      time.Duration
+	Wakeups      int64
+	EnergyImpact float64
+	// Samples is the number of tasks samples in which the command or one
+	// of its descendants appears.
+	Samples int
+	// System is the whole-system measurement over the same window, for
+	// reference.
+	System *Measurement
+	// ProcessState is the state of the command after it exited.
+	ProcessState *os.ProcessState
+}
+
+// RunProcess runs cmd with the real command runner. See
+// Powermetrics.RunProcess.
+func RunProcess(ctx context.Context, config *Config, cmd *exec.Cmd) (*ProcessUsage, error) {
+	return New().RunProcess(ctx, config, cmd)
+}
+
+// StartProcess starts cmd with the real command runner. See
+// Powermetrics.StartProcess.
+func StartProcess(ctx context.Context, config *Config, cmd *exec.Cmd) (*ProcessRun, error) {
+	return New().StartProcess(ctx, config, cmd)
+}
+
+// processPollInterval is how often the process table is listed while a
+// command runs.
+const processPollInterval = 50 * time.Millisecond
+
+// RunProcess starts cmd, tracks its process ID and the IDs of its
+// descendants across tasks samples, and sums the CPU time, wakeups and
+// energy impact of only those processes. It is StartProcess followed by
+// ProcessRun.Wait.
+//
+// On Unix, cmd runs in a process group of its own, so interrupts from the
+// terminal no longer reach it; callers forward them to the group. The
+// process table is listed with ps(1) every 50ms and whenever a tasks sample
+// arrives, and processes are attributed while they run in that group or
+// descend from an attributed process. The totals are a lower bound:
+// processes that start and exit between two listings are missed, and
+// powermetrics reports them under DEAD_TASKS. A process that has exited is
+// still attributed in the next two tasks samples, which cover its last
+// interval, so a process reusing its ID within that window is counted too.
+//
+// The Tasks sampler is added to the configuration when missing. A nil
+// config samples the GPU and tasks every DefaultProcessSampleRate. If cmd
+// fails, the usage is returned together with its error.
+func (p *Powermetrics) RunProcess(ctx context.Context, config *Config, cmd *exec.Cmd) (*ProcessUsage, error) {
+	run, err := p.StartProcess(ctx, config, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return run.Wait()
+}
+
+// ProcessRun is a command started by StartProcess.
+type ProcessRun struct {
+	// Process is the started command. On Unix, its ID is also the ID of
+	// its process group.
+	Process *os.Process
+
+	done  chan struct{}
+	usage *ProcessUsage
+	err   error
+}
+
+// Wait waits for the command to exit and for the samples covering it, and
+// returns the usage as RunProcess does.
+func (r *ProcessRun) Wait() (*ProcessUsage, error) {
+	<-r.done
+	return r.usage, r.err
+}
+
+// StartProcess starts sampling and cmd, and returns once cmd has started.
+// See RunProcess.
+func (p *Powermetrics) StartProcess(ctx context.Context, config *Config, cmd *exec.Cmd) (*ProcessRun, error) {
+	if config == nil {
+		config = &Config{
+			SampleRate: DefaultProcessSampleRate,
+			Format:     FormatPlist,
+			Samplers:   []Sampler{GPUPower, Tasks},
+		}
+	}
+	processConfig := *config
+	if !hasSampler(processConfig.Samplers, Tasks) {
+		processConfig.Samplers = append(append([]Sampler(nil), processConfig.Samplers...), Tasks)
+	}
+
+	tracker := newProcessTracker()
+	refresh := func() {
+		if table, err := p.processTable(); err == nil {
+			tracker.refresh(table)
+		}
+	}
+	observe := func(sample types.Sample) {
+		tasks, ok := sample.(*types.TasksSample)
+		if !ok {
+			return
+		}
+		refresh()
+		tracker.observe(tasks)
+	}
+
+	setProcessGroup(cmd)
+	run := &ProcessRun{done: make(chan struct{})}
+	started := make(chan struct{})
+	go func() {
+		defer close(run.done)
+		system, err := p.measure(ctx, &processConfig, func(ctx context.Context) error {
+			if err := cmd.Start(); err != nil {
+				return err
+			}
+			tracker.add(cmd.Process.Pid, processGroup(cmd))
+			close(started)
+			stop := pollProcesses(refresh)
+			defer stop()
+			return cmd.Wait()
+		}, observe)
+		if system == nil {
+			run.err = err
+			return
+		}
+		usage := tracker.usage()
+		usage.System = system
+		usage.ProcessState = cmd.ProcessState
+		run.usage, run.err = usage, err
+	}()
+
+	select {
+	case <-started:
+		run.Process = cmd.Process
+		return run, nil
+	case <-run.done:
+		return nil, run.err
+	}
+}
+
+// pollProcesses calls refresh every processPollInterval until the returned
+// function is called.
+func pollProcesses(refresh func()) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(processPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				refresh()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// processInfo is an entry of the process table.
+type processInfo struct {
+	ppid int
+	pgid int
+}
+
+// processTable returns the parent and process group of every running
+// process.
+func (p *Powermetrics) processTable() (map[int]processInfo, error) {
+	output, err := p.runner.Run("ps", "-A", "-o", "pid=,ppid=,pgid=")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	return parseProcessTable(output), nil
+}
+
+// parseProcessTable parses the output of ps -o pid=,ppid=,pgid=.
+func parseProcessTable(output []byte) map[int]processInfo {
+	table := make(map[int]processInfo)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		var ids [3]int
+		valid := true
+		for i, field := range fields {
+			id, err := strconv.Atoi(field)
+			if err != nil {
+				valid = false
+				break
+			}
+			ids[i] = id
+		}
+		if valid {
+			table[ids[0]] = processInfo{ppid: ids[1], pgid: ids[2]}
+		}
+	}
+	return table
+}
+
+func hasSampler(list []Sampler, sampler Sampler) bool {
+	for _, s := range list {
+		if s == sampler {
+			return true
+		}
+	}
+	return false
+}
+
+// retireAfter is the number of tasks samples in which an exited process is
+// still attributed. The first sample observed after a process exits may end
+// before it did, the second covers its exit.
+const retireAfter = 2
+
+// processTracker accumulates the usage of a process tree. It is safe for
+// concurrent use.
+type processTracker struct {
+	mu   sync.Mutex
+	root int
+	// pgid is the process group of the command, or 0 when it does not
+	// have one of its own.
+	pgid int
+	// tracked holds the attributed processes, running or exited less than
+	// retireAfter samples ago.
+	tracked map[int]bool
+	// exited counts the samples observed since a tracked process exited.
+	exited map[int]int
+	// pids holds every process ever attributed.
+	pids  map[int]bool
+	total ProcessUsage
+}
+
+func newProcessTracker() *processTracker {
+	return &processTracker{
+		tracked: make(map[int]bool),
+		exited:  make(map[int]int),
+		pids:    make(map[int]bool),
+	}
+}
+
+// add starts tracking pid as the root of the tree, in process group pgid
+// unless it is 0.
+func (t *processTracker) add(pid, pgid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root = pid
+	t.pgid = pgid
+	t.tracked[pid] = true
+	t.pids[pid] = true
+}
+
+// running reports whether pid is tracked and has not exited.
+func (t *processTracker) running(pid int) bool {
+	_, exited := t.exited[pid]
+	return t.tracked[pid] && !exited
+}
+
+// refresh tracks every process of the command's process group or whose
+// parent is a tracked running process, and marks the tracked processes
+// missing from table as exited.
+func (t *processTracker) refresh(table map[int]processInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for pid := range t.tracked {
+		if _, ok := table[pid]; !ok {
+			if _, exited := t.exited[pid]; !exited {
+				t.exited[pid] = 0
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for pid, info := range table {
+			if t.tracked[pid] {
+				continue
+			}
+			if (t.pgid != 0 && info.pgid == t.pgid) || t.running(info.ppid) {
+				t.tracked[pid] = true
+				t.pids[pid] = true
+				changed = true
+			}
+		}
+	}
+}
+
+// observe adds the usage of the tracked tasks in sample, and stops tracking
+// the processes that exited retireAfter samples ago.
+func (t *processTracker) observe(sample *types.TasksSample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen := false
+	for i := range sample.Tasks {
+		task := &sample.Tasks[i]
+		if !t.tracked[task.PID] {
+			continue
+		}
+		seen = true
+		t.total.CPUTime += task.CPUTime()
+		t.total.Wakeups += task.Wakeups()
+		t.total.EnergyImpact += task.EnergyImpact
+	}
+	if seen {
+		t.total.Samples++
+	}
+	for pid, n := range t.exited {
+		if n+1 >= retireAfter {
+			delete(t.tracked, pid)
+			delete(t.exited, pid)
+		} else {
+			t.exited[pid] = n + 1
+		}
+	}
+}
+
+// usage returns the accumulated usage.
+func (t *processTracker) usage() *ProcessUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := t.total
+	usage.PID = t.root
+	usage.PIDs = make([]int, 0, len(t.pids))
+	for pid := range t.pids {
+		usage.PIDs = append(usage.PIDs, pid)
+	}
+	sort.Ints(usage.PIDs)
+	return &usage
+}
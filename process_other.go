@@ -0,0 +1,13 @@
+//go:build !unix
+
+package powermetrics
+
+import "os/exec"
+
+// setProcessGroup does nothing: process groups are a Unix feature.
+func setProcessGroup(*exec.Cmd) {}
+
+// processGroup returns 0, as cmd has no process group of its own.
+func processGroup(*exec.Cmd) int {
+	return 0
+}
@@ -0,0 +1,164 @@
+package powermetrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+// processRunner serves tasks samples to the stream and a fixed process list
+// to ps.
+type processRunner struct {
+	tasks []byte
+	ps    []byte
+}
+
+func (r *processRunner) Run(name string, args ...string) ([]byte, error) {
+	return r.ps, nil
+}
+
+func (r *processRunner) Start(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(r.tasks)), nil
+}
+
+func readTasksSamples(t *testing.T) []*types.TasksSample {
+	t.Helper()
+	xmlData, err := os.ReadFile("testdata/tasks_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read tasks XML: %v", err)
+	}
+	var samples []*types.TasksSample
+	for _, doc := range bytes.Split(xmlData, []byte{0}) {
+		decoded, err := decodeSamples(doc, []Sampler{Tasks})
+		if err != nil {
+			t.Fatalf("Failed to decode tasks sample: %v", err)
+		}
+		samples = append(samples, decoded[0].(*types.TasksSample))
+	}
+	return samples
+}
+
+func TestTasksXMLUnmarshaling(t *testing.T) {
+	samples := readTasksSamples(t)
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d", len(samples))
+	}
+
+	task := samples[1].Tasks[3]
+	if task.PID != 4243 || task.Name != "cc1" {
+		t.Errorf("Expected task 4243 cc1, got %d %s", task.PID, task.Name)
+	}
+	if task.CPUTime() != 700*time.Millisecond {
+		t.Errorf("Expected 700ms CPU time, got %v", task.CPUTime())
+	}
+	if task.Wakeups() != 15 {
+		t.Errorf("Expected 15 wakeups, got %d", task.Wakeups())
+	}
+	if samples[1].AllTasks.EnergyImpact <= 0 {
+		t.Error("Expected all_tasks energy impact to be positive")
+	}
+}
+
+func TestProcessTracker(t *testing.T) {
+	tracker := newProcessTracker()
+	tracker.add(4242, 4242)
+	// 4300 left the process group, and is tracked as a child of 4243.
+	tracker.refresh(parseProcessTable([]byte("    1     0     1\n  412     1   412\n 4242     1  4242\n 4243  4242  4242\n 4300  4243  4300\n")))
+
+	for _, sample := range readTasksSamples(t) {
+		tracker.observe(sample)
+	}
+	usage := tracker.usage()
+
+	if len(usage.PIDs) != 3 || usage.PIDs[0] != 4242 || usage.PIDs[2] != 4300 {
+		t.Errorf("Expected PIDs [4242 4243 4300], got %v", usage.PIDs)
+	}
+	if usage.CPUTime != 1001500*time.Microsecond {
+		t.Errorf("Expected 1.0015s of CPU time, got %v", usage.CPUTime)
+	}
+	if usage.Wakeups != 27 {
+		t.Errorf("Expected 27 wakeups, got %d", usage.Wakeups)
+	}
+	if usage.EnergyImpact != 125.75 {
+		t.Errorf("Expected an energy impact of 125.75, got %v", usage.EnergyImpact)
+	}
+	if usage.Samples != 3 {
+		t.Errorf("Expected 3 samples, got %d", usage.Samples)
+	}
+}
+
+func TestProcessTrackerSamples(t *testing.T) {
+	// 4242 exits before the last sample, and its child is not tracked.
+	tracker := newProcessTracker()
+	tracker.add(4242, 0)
+	for _, sample := range readTasksSamples(t) {
+		tracker.observe(sample)
+	}
+	if usage := tracker.usage(); usage.Samples != 2 {
+		t.Errorf("Expected 2 samples, got %d", usage.Samples)
+	}
+}
+
+func TestProcessTrackerGroup(t *testing.T) {
+	tracker := newProcessTracker()
+	tracker.add(100, 100)
+	// 102 was orphaned and reparented to launchd, but stays in the group.
+	tracker.refresh(parseProcessTable([]byte("1 0 1\n100 1 100\n101 100 100\n102 1 100\n103 1 103\n")))
+
+	if usage := tracker.usage(); len(usage.PIDs) != 3 || usage.PIDs[2] != 102 {
+		t.Errorf("Expected PIDs [100 101 102], got %v", usage.PIDs)
+	}
+}
+
+func TestProcessTrackerExited(t *testing.T) {
+	tracker := newProcessTracker()
+	tracker.add(100, 100)
+	tracker.refresh(parseProcessTable([]byte("100 1 100\n101 100 100\n")))
+	// 101 exits, and its ID is reused by a process outside the group.
+	tracker.refresh(parseProcessTable([]byte("100 1 100\n")))
+	tracker.refresh(parseProcessTable([]byte("100 1 100\n101 1 101\n")))
+
+	sample := &types.TasksSample{Tasks: []types.TaskInfo{{PID: 101, EnergyImpact: 1}}}
+	for range retireAfter + 1 {
+		tracker.observe(sample)
+	}
+	if usage := tracker.usage(); usage.EnergyImpact != retireAfter || usage.Samples != retireAfter {
+		t.Errorf("Expected 101 to be attributed in %d samples, got %+v", retireAfter, usage)
+	}
+	if tracker.tracked[101] {
+		t.Error("Expected 101 to no longer be tracked")
+	}
+}
+
+func TestParseProcessTable(t *testing.T) {
+	table := parseProcessTable([]byte("    1     0     1\n  412     1   412\ninvalid\n  413   x   413\n"))
+	if len(table) != 2 || table[412] != (processInfo{ppid: 1, pgid: 412}) {
+		t.Errorf("Expected 2 processes, got %v", table)
+	}
+}
+
+func TestRunProcess(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/tasks_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read tasks XML: %v", err)
+	}
+
+	pm := NewWithRunner(&processRunner{tasks: xmlData, ps: []byte("1 0 1\n")})
+	usage, err := pm.RunProcess(context.Background(), nil, exec.Command("true"))
+	if err != nil {
+		t.Fatalf("RunProcess failed: %v", err)
+	}
+
+	if usage.PID == 0 || usage.ProcessState == nil || !usage.ProcessState.Success() {
+		t.Errorf("Expected the command to run successfully, got %+v", usage)
+	}
+	if usage.System == nil {
+		t.Errorf("Expected a system measurement, got %+v", usage)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package powermetrics
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd the leader of a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.SysProcAttr.Pgid = 0
+}
+
+// processGroup returns the process group of the started cmd.
+func processGroup(cmd *exec.Cmd) int {
+	return cmd.Process.Pid
+}
@@ -0,0 +1,196 @@
+package powermetrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	howett_plist "howett.net/plist"
+)
+
+// Marker marks the start of a workload phase in a stream.
+type Marker struct {
+	// Name is the phase that starts at the marker. An empty name ends the
+	// current phase without starting a new one.
+	Name string
+	// Offset is the time since the stream started, on the same clock the
+	// sample intervals are chained on.
+	Offset time.Duration
+	// Time is the local time of the marker.
+	Time time.Time
+}
+
+// Mark records a marker starting the phase name at the current time, and
+// writes it to the recording when one is active. It is safe to call from
+// any goroutine.
+func (s *Stream) Mark(name string) Marker {
+	now := time.Now()
+	marker := Marker{Name: name, Offset: now.Sub(s.started), Time: now}
+	s.addMarker(marker, encodeMarker(marker))
+	return marker
+}
+
+// Markers returns the markers recorded so far, including those replayed
+// from a recording, in the order they were added.
+func (s *Stream) Markers() []Marker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Marker(nil), s.markers...)
+}
+
+// Record starts a stream like Stream and writes every powermetrics document
+// to w, followed by a NUL byte as powermetrics does, together with the
+// markers added with Mark. The recording can be read back with
+// ReadRecording, or replayed through Stream with a MockCommandRunner.
+func (p *Powermetrics) Record(ctx context.Context, config *Config, w io.Writer) (*Stream, error) {
+	return p.stream(ctx, config, w)
+}
+
+func (s *Stream) addMarker(marker Marker, doc []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markers = append(s.markers, marker)
+	s.writeLocked(doc)
+}
+
+func (s *Stream) record(doc []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeLocked(doc)
+}
+
+// writeLocked writes doc to the recording. A write error stops the
+// recording and is reported by Err once the stream ends.
+func (s *Stream) writeLocked(doc []byte) {
+	if s.recorder == nil || doc == nil {
+		return
+	}
+	// doc may alias the scanner buffer, so it must not be appended to.
+	record := make([]byte, len(doc)+1)
+	copy(record, doc)
+	if _, err := s.recorder.Write(record); err != nil {
+		s.recorder = nil
+		s.recordErr = fmt.Errorf("failed to record: %w", err)
+	}
+}
+
+// markerDocument is the plist representation of a Marker.
+type markerDocument struct {
+	Marker    *string   `plist:"marker"`
+	OffsetNS  int64     `plist:"offset_ns"`
+	Timestamp time.Time `plist:"timestamp"`
+}
+
+var markerKey = []byte("<key>marker</key>")
+
+// encodeMarker returns the plist document of marker, or nil if it cannot
+// be encoded.
+func encodeMarker(marker Marker) []byte {
+	var buf bytes.Buffer
+	encoder := howett_plist.NewEncoderForFormat(&buf, howett_plist.XMLFormat)
+	err := encoder.Encode(markerDocument{
+		Marker:    &marker.Name,
+		OffsetNS:  int64(marker.Offset),
+		Timestamp: marker.Time,
+	})
+	if err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// decodeMarker decodes doc when it is a marker document.
+func decodeMarker(doc []byte) (Marker, bool) {
+	if !bytes.Contains(doc, markerKey) {
+		return Marker{}, false
+	}
+	var parsed markerDocument
+	if err := howett_plist.NewDecoder(bytes.NewReader(doc)).Decode(&parsed); err != nil || parsed.Marker == nil {
+		return Marker{}, false
+	}
+	return Marker{
+		Name:   *parsed.Marker,
+		Offset: time.Duration(parsed.OffsetNS),
+		Time:   parsed.Timestamp,
+	}, true
+}
+
+// Recording is a recorded stream.
+type Recording struct {
+	// Samplers are the samplers found in the recording, in the order
+	// their samples appear for every powermetrics sample.
+	Samplers []Sampler
+	Samples  []types.Sample
+	Markers  []Marker
+}
+
+// ReadRecording reads a recording written by Record, or the raw
+// plist output of powermetrics. The samplers are detected from the first
+// sample.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDocumentSize)
+	scanner.Split(splitPlistDocuments)
+
+	recording := &Recording{}
+	for i := 0; scanner.Scan(); i++ {
+		doc := scanner.Bytes()
+		if marker, ok := decodeMarker(doc); ok {
+			recording.Markers = append(recording.Markers, marker)
+			continue
+		}
+		if recording.Samplers == nil {
+			samplerList, err := detectSamplers(doc)
+			if err != nil {
+				return nil, fmt.Errorf("document %d: %w", i+1, err)
+			}
+			recording.Samplers = samplerList
+		}
+		samples, err := decodeSamples(doc, recording.Samplers)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		recording.Samples = append(recording.Samples, samples...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return recording, nil
+}
+
+// Phases attributes the recorded samples to the phases delimited by the
+// markers. See Phases.
+func (r *Recording) Phases() []Phase {
+	return Phases(r.Samples, len(r.Samplers), r.Markers)
+}
+
+// detectSamplers returns the supported samplers whose keys are present in
+// doc.
+func detectSamplers(doc []byte) ([]Sampler, error) {
+	var keys struct {
+		GPU             *struct{}      `plist:"gpu"`
+		Tasks           *[]interface{} `plist:"tasks"`
+		ThermalPressure *string        `plist:"thermal_pressure"`
+	}
+	if err := howett_plist.NewDecoder(bytes.NewReader(doc)).Decode(&keys); err != nil {
+		return nil, err
+	}
+	var found []Sampler
+	if keys.GPU != nil {
+		found = append(found, GPUPower)
+	}
+	if keys.Tasks != nil {
+		found = append(found, Tasks)
+	}
+	if keys.ThermalPressure != nil {
+		found = append(found, Thermal)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no supported sampler in document")
+	}
+	return found, nil
+}
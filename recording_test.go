@@ -0,0 +1,66 @@
+package powermetrics
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+func TestRecording(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	var recorded bytes.Buffer
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+	stream, err := pm.Record(context.Background(), DefaultConfig().GPU(), &recorded)
+	if err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	mark := stream.Mark("compile")
+	var count int
+	for range stream.Samples() {
+		count++
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Expected stream to end without error, got %v", err)
+	}
+	if markers := stream.Markers(); count != 5 || len(markers) != 1 || markers[0].Name != "compile" {
+		t.Fatalf("Expected 5 samples and the compile marker, got %d and %+v", count, markers)
+	}
+
+	recording, err := ReadRecording(bytes.NewReader(recorded.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadRecording failed: %v", err)
+	}
+	if len(recording.Samplers) != 1 || recording.Samplers[0] != GPUPower {
+		t.Errorf("Expected the gpu_power sampler, got %v", recording.Samplers)
+	}
+	if len(recording.Samples) != 5 {
+		t.Errorf("Expected 5 samples, got %d", len(recording.Samples))
+	}
+	if len(recording.Markers) != 1 {
+		t.Fatalf("Expected 1 marker, got %d", len(recording.Markers))
+	}
+	if got := recording.Markers[0]; got.Name != mark.Name || got.Offset != mark.Offset || !got.Time.Equal(mark.Time.Truncate(1e9)) {
+		t.Errorf("Expected marker %+v, got %+v", mark, got)
+	}
+	if phases := recording.Phases(); len(phases) != 1 || phases[0].Energy <= 0 {
+		t.Errorf("Expected one phase with energy, got %+v", phases)
+	}
+
+	// A recording replays through Stream with its markers.
+	replay, err := NewWithRunner(&MockCommandRunner{Output: recorded.Bytes()}).Stream(context.Background(), DefaultConfig().GPU())
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	count = 0
+	for range replay.Samples() {
+		count++
+	}
+	if markers := replay.Markers(); count != 5 || len(markers) != 1 || markers[0].Name != "compile" {
+		t.Errorf("Expected 5 replayed samples and the compile marker, got %d and %+v", count, markers)
+	}
+}
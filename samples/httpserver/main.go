@@ -8,25 +8,9 @@ import (
 	"time"
 
 	"github.com/matiasinsaurralde/powermetrics"
+	"github.com/matiasinsaurralde/powermetrics/pkg/jsonfeed"
 )
 
-type GPUResponse struct {
-	Timestamp time.Time `json:"timestamp"`
-	GPU       struct {
-		FrequencyHz  float64 `json:"frequency_hz"`
-		FrequencyMHz float64 `json:"frequency_mhz"`
-		IdleRatio    float64 `json:"idle_ratio"`
-		IdlePercent  float64 `json:"idle_percent"`
-		DVFMStates   []struct {
-			FrequencyHz float64 `json:"frequency_hz"`
-			UsedRatio   float64 `json:"used_ratio"`
-			UsedPercent float64 `json:"used_percent"`
-		} `json:"dvfm_states"`
-	} `json:"gpu"`
-	HardwareModel string `json:"hardware_model"`
-	KernelVersion string `json:"kernel_version"`
-}
-
 func gpuMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers for web access
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -59,30 +43,10 @@ func gpuMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build response
-	response := GPUResponse{
-		Timestamp:     result.PlistData.Timestamp,
-		HardwareModel: result.PlistData.HWModel,
-		KernelVersion: result.PlistData.KernOSVer,
-	}
-
-	// GPU data
-	response.GPU.FrequencyHz = result.PlistData.GPU.FreqHz
-	response.GPU.FrequencyMHz = result.PlistData.GPU.FreqHz / 1e6
-	response.GPU.IdleRatio = result.PlistData.GPU.IdleRatio
-	response.GPU.IdlePercent = result.PlistData.GPU.IdleRatio * 100
-
-	// DVFM states
-	for _, state := range result.PlistData.GPU.DVFMStates {
-		dvfmState := struct {
-			FrequencyHz float64 `json:"frequency_hz"`
-			UsedRatio   float64 `json:"used_ratio"`
-			UsedPercent float64 `json:"used_percent"`
-		}{
-			FrequencyHz: float64(state.Freq),
-			UsedRatio:   state.UsedRatio,
-			UsedPercent: state.UsedRatio * 100,
-		}
-		response.GPU.DVFMStates = append(response.GPU.DVFMStates, dvfmState)
+	response, err := jsonfeed.FromSample(result.PlistData)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error converting metrics: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	// Return JSON response
@@ -95,8 +59,19 @@ func gpuMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	schema, err := jsonfeed.Schema()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error generating schema: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	_, _ = w.Write(schema)
+}
+
 func main() {
 	http.HandleFunc("/gpu", gpuMetricsHandler)
+	http.HandleFunc("/schema", schemaHandler)
 
 	port := ":8080"
 	fmt.Printf("Starting HTTP server on port %s\n", port)
@@ -27,10 +27,13 @@ func main() {
 	// Access GPU power data (single sample)
 	if result.PlistData != nil {
 		gpu := result.PlistData.GPU
-		fmt.Printf("GPU Frequency: %.2f Hz\n", gpu.FreqHz)
+		fmt.Printf("GPU Frequency: %v\n", gpu.Frequency())
 		fmt.Printf("GPU Idle Ratio: %.2f%%\n", gpu.IdleRatio*100)
-		if gpu.GPUEnergy != nil {
-			fmt.Printf("GPU Energy: %d mW\n", *gpu.GPUEnergy)
+		if energy, ok := gpu.Energy(); ok {
+			fmt.Printf("GPU Energy: %v\n", energy)
+		}
+		if power, ok := result.PlistData.Power(); ok {
+			fmt.Printf("GPU Power: %v\n", power)
 		}
 	}
 
@@ -83,12 +83,11 @@ func main() {
 				sparkGroup.Title = "GPU Idle Ratio History (Error)"
 			} else if result.PlistData != nil {
 				gpu := result.PlistData.GPU
-				// Show frequency in Hz (the raw value from powermetrics)
-				freqStr := fmt.Sprintf("%.0f Hz", gpu.FreqHz)
+				freqStr := gpu.Frequency().String()
 
 				energyStr := "N/A"
-				if gpu.GPUEnergy != nil {
-					energyStr = fmt.Sprintf("%d mW", *gpu.GPUEnergy)
+				if energy, ok := gpu.Energy(); ok {
+					energyStr = energy.String()
 				}
 
 				var idleStr string
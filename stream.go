@@ -0,0 +1,349 @@
+package powermetrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matiasinsaurralde/powermetrics/internal/samplers"
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+	howett_plist "howett.net/plist"
+)
+
+// ErrStreamingUnsupported is returned by Stream when the command runner
+// cannot stream command output.
+var ErrStreamingUnsupported = fmt.Errorf("command runner does not support streaming")
+
+// ErrSkippedSamples is returned by Measure when powermetrics produced
+// invalid samples whose interval is unknown.
+var ErrSkippedSamples = fmt.Errorf("skipped invalid samples of unknown duration")
+
+// maxDocumentSize bounds the size of a single plist document in a stream.
+const maxDocumentSize = 16 << 20
+
+// StreamRunner is implemented by command runners that can stream the output
+// of a long-running command. Closing the returned reader stops the command.
+type StreamRunner interface {
+	Start(ctx context.Context, name string, args ...string) (io.ReadCloser, error)
+}
+
+// Start runs the command and returns its standard output. Closing the
+// reader interrupts the command and waits for it to exit.
+func (r *RealCommandRunner) Start(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// Start replays the mock output as a stream.
+func (m *MockCommandRunner) Start(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return io.NopCloser(bytes.NewReader(m.Output)), nil
+}
+
+type commandReader struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	once sync.Once
+	err  error
+}
+
+func (c *commandReader) Close() error {
+	c.once.Do(func() {
+		if err := c.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			_ = c.cmd.Process.Kill()
+		}
+		// The process was stopped on purpose, so its exit status is not
+		// interesting; only report failures to wait for it.
+		var exitErr *exec.ExitError
+		if err := c.cmd.Wait(); err != nil && !errors.As(err, &exitErr) {
+			c.err = err
+		}
+	})
+	return c.err
+}
+
+// Stream is a running powermetrics process delivering samples as they are
+// produced.
+type Stream struct {
+	samplers []Sampler
+	samples  chan types.Sample
+	reader   io.ReadCloser
+	started  time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+
+	mu        sync.Mutex
+	markers   []Marker
+	recorder  io.Writer
+	recordErr error
+	// skipped holds the elapsed time of the documents that failed to
+	// decode, by the number of samples delivered before them, and
+	// skippedUnknown counts those without an elapsed time.
+	skipped        map[int]time.Duration
+	skippedUnknown int
+}
+
+// Stream starts powermetrics with the given configuration and delivers its
+// samples until the context is cancelled, Stop is called or the configured
+// SampleCount is reached. A SampleCount of zero samples indefinitely. The
+// output format is always plist.
+func (p *Powermetrics) Stream(ctx context.Context, config *Config) (*Stream, error) {
+	return p.stream(ctx, config, nil)
+}
+
+// stream implements Stream, writing the output to recorder when it is not
+// nil.
+func (p *Powermetrics) stream(ctx context.Context, config *Config, recorder io.Writer) (*Stream, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	if err := ValidateSamplers(config.Samplers); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	runner, ok := p.runner.(StreamRunner)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+
+	streamConfig := *config
+	streamConfig.Format = FormatPlist
+
+	ctx, cancel := context.WithCancel(ctx)
+	reader, err := runner.Start(ctx, "powermetrics", buildArgs(&streamConfig)...)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to execute powermetrics: %w", err)
+	}
+
+	s := &Stream{
+		samplers: config.Samplers,
+		samples:  make(chan types.Sample),
+		reader:   reader,
+		started:  time.Now(),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		recorder: recorder,
+		skipped:  make(map[int]time.Duration),
+	}
+	go s.run(ctx)
+	return s, nil
+}
+
+// Samples returns the channel samples are delivered on. Every powermetrics
+// sample yields one value per configured sampler, such as a
+// *types.GPUPowerSample or a *types.TasksSample. The channel is closed when
+// the stream ends.
+func (s *Stream) Samples() <-chan types.Sample {
+	return s.samples
+}
+
+// Started returns the local time at which powermetrics was started, which
+// is the beginning of the first sample interval.
+func (s *Stream) Started() time.Time {
+	return s.started
+}
+
+// Stop stops powermetrics and waits for the stream to end. It returns the
+// same error as Err.
+func (s *Stream) Stop() error {
+	s.cancel()
+	<-s.done
+	return s.err
+}
+
+// Done returns a channel that is closed when the stream has ended.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that ended the stream, if any. Stopping the stream
+// or cancelling its context is not an error.
+func (s *Stream) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *Stream) run(ctx context.Context) {
+	defer close(s.done)
+	defer close(s.samples)
+
+	stop := context.AfterFunc(ctx, func() { _ = s.reader.Close() })
+	defer stop()
+
+	scanner := bufio.NewScanner(s.reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDocumentSize)
+	scanner.Split(splitPlistDocuments)
+
+	delivered := 0
+	for scanner.Scan() {
+		doc := scanner.Bytes()
+		if marker, ok := decodeMarker(doc); ok {
+			s.addMarker(marker, doc)
+			continue
+		}
+		s.record(doc)
+		samples, err := decodeSamples(doc, s.samplers)
+		if err != nil {
+			s.skip(delivered, doc)
+			continue
+		}
+		for _, sample := range samples {
+			select {
+			case s.samples <- sample:
+				delivered++
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		s.err = fmt.Errorf("failed to read powermetrics output: %w", err)
+	}
+	if err := s.reader.Close(); err != nil && ctx.Err() == nil && s.err == nil {
+		s.err = fmt.Errorf("powermetrics exited: %w", err)
+	}
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = s.recordErr
+	}
+	s.mu.Unlock()
+}
+
+// skip records the elapsed time of an invalid document following the
+// first delivered samples, so that the intervals of the next samples can
+// still be placed.
+func (s *Stream) skip(delivered int, doc []byte) {
+	var base types.BaseSample
+	err := howett_plist.NewDecoder(bytes.NewReader(doc)).Decode(&base)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || base.ElapsedNS <= 0 {
+		s.skippedUnknown++
+		return
+	}
+	s.skipped[delivered] += time.Duration(base.ElapsedNS)
+}
+
+// skippedBefore returns the elapsed time of the documents skipped right
+// before the sample following the first delivered samples.
+func (s *Stream) skippedBefore(delivered int) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skipped[delivered]
+}
+
+// skippedUnknownCount returns the number of skipped documents without an
+// elapsed time.
+func (s *Stream) skippedUnknownCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skippedUnknown
+}
+
+var plistEnd = []byte("</plist>")
+
+// splitPlistDocuments is a bufio.SplitFunc that yields one plist document at
+// a time. powermetrics separates documents with NUL bytes.
+func splitPlistDocuments(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.Index(data, plistEnd); i >= 0 {
+		end := i + len(plistEnd)
+		return end, bytes.TrimLeft(data[:end], "\x00\r\n\t "), nil
+	}
+	if atEOF {
+		if len(bytes.Trim(data, "\x00\r\n\t ")) > 0 {
+			return len(data), data, nil
+		}
+		return len(data), nil, nil
+	}
+	return 0, nil, nil
+}
+
+// decodeSample decodes a single plist document.
+func decodeSample(doc []byte) (*samplers.PlistRoot, error) {
+	var parsed samplers.PlistRoot
+	decoder := howett_plist.NewDecoder(bytes.NewReader(doc))
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// decodeSamples decodes a single plist document into one sample per
+// sampler, in the order the samplers were requested.
+func decodeSamples(doc []byte, samplerList []Sampler) ([]types.Sample, error) {
+	samples := make([]types.Sample, 0, len(samplerList))
+	for _, sampler := range samplerList {
+		sample := newSample(sampler)
+		if sample == nil {
+			continue
+		}
+		decoder := howett_plist.NewDecoder(bytes.NewReader(doc))
+		if err := decoder.Decode(sample); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// newSample returns an empty sample of sampler, or nil when samples of
+// sampler are not decoded.
+func newSample(sampler Sampler) types.Sample {
+	switch sampler {
+	case GPUPower:
+		return &types.GPUPowerSample{}
+	case Tasks:
+		return &types.TasksSample{}
+	case Thermal:
+		return &types.ThermalSample{}
+	default:
+		return nil
+	}
+}
+
+// buildArgs returns the powermetrics command line arguments for config.
+func buildArgs(config *Config) []string {
+	samplerStrings := make([]string, len(config.Samplers))
+	for i, sampler := range config.Samplers {
+		samplerStrings[i] = string(sampler)
+	}
+
+	args := []string{
+		fmt.Sprintf("--sample-count=%d", config.SampleCount),
+		fmt.Sprintf("--format=%s", config.Format),
+		fmt.Sprintf("--samplers=%s", strings.Join(samplerStrings, ",")),
+	}
+
+	// Add sample rate if specified
+	if config.SampleRate > 0 {
+		args = append(args, fmt.Sprintf("--sample-rate=%d", int(config.SampleRate.Milliseconds())))
+	}
+	return args
+}
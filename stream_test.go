@@ -0,0 +1,82 @@
+package powermetrics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/matiasinsaurralde/powermetrics/pkg/types"
+)
+
+func TestStreamWithMock(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+	stream, err := pm.Stream(context.Background(), DefaultConfig().GPU())
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	var samples []types.Sample
+	for sample := range stream.Samples() {
+		samples = append(samples, sample)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Expected stream to end without error, got %v", err)
+	}
+
+	if len(samples) != 5 {
+		t.Fatalf("Expected 5 samples, got %d", len(samples))
+	}
+	for i, sample := range samples {
+		if _, ok := sample.(*types.GPUPowerSample); !ok {
+			t.Errorf("Sample %d: Expected *types.GPUPowerSample, got %T", i, sample)
+		}
+	}
+}
+
+func TestStreamStop(t *testing.T) {
+	xmlData, err := os.ReadFile("testdata/gpu_power_multiple_samples.xml")
+	if err != nil {
+		t.Fatalf("Failed to read test XML: %v", err)
+	}
+
+	pm := NewWithRunner(&MockCommandRunner{Output: xmlData})
+	stream, err := pm.Stream(context.Background(), DefaultConfig().GPU())
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	<-stream.Samples()
+	if err := stream.Stop(); err != nil {
+		t.Errorf("Expected Stop to succeed, got %v", err)
+	}
+	for range stream.Samples() {
+	}
+}
+
+type runOnlyRunner struct{}
+
+func (runOnlyRunner) Run(name string, args ...string) ([]byte, error) { return nil, nil }
+
+func TestStreamErrors(t *testing.T) {
+	pm := NewWithRunner(runOnlyRunner{})
+	if _, err := pm.Stream(context.Background(), nil); !errors.Is(err, ErrStreamingUnsupported) {
+		t.Errorf("Expected ErrStreamingUnsupported, got %v", err)
+	}
+
+	pm = NewWithRunner(&MockCommandRunner{})
+	config := &Config{Samplers: []Sampler{"invalid_sampler"}}
+	if _, err := pm.Stream(context.Background(), config); !errors.Is(err, ErrUnsupportedSampler) {
+		t.Errorf("Expected ErrUnsupportedSampler, got %v", err)
+	}
+
+	pm = NewWithRunner(&MockCommandRunner{Err: errors.New("permission denied")})
+	if _, err := pm.Stream(context.Background(), nil); err == nil {
+		t.Error("Expected an error from a failing runner")
+	}
+}